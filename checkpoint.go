@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/sha256"
+	"datacollector/database"
+	"datacollector/executor"
+	"datacollector/logging"
+	"datacollector/models"
+	"datacollector/state"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// checkpointFingerprint hashes what decides which rows a query writes and how
+// they look: the query, the database, the output file and format, and the
+// column and value settings. A checkpoint is only resumed into output with
+// the same fingerprint; connection, concurrency and timing settings may change.
+func checkpointFingerprint(workload *models.Workload, dbConfig database.Config) string {
+	shape := map[string]interface{}{
+		"query":                  workload.Query,
+		"count_only":             workload.CountOnly,
+		"type":                   dbConfig.Type,
+		"database":               dbConfig.Database,
+		"outdir":                 workload.OutputDir,
+		"outfile":                workload.OutputFile,
+		"output_format":          workload.OutputFormat.List(),
+		"encoding":               workload.Encoding,
+		"destinations":           workload.Destinations,
+		"per_target_output":      workload.PerTargetOutput,
+		"stream_per_target":      workload.StreamPerTarget,
+		"column_types":           workload.ColumnTypes,
+		"quote_all":              workload.QuoteAll,
+		"sanitize_formulas":      workload.SanitizeFormulas,
+		"null_value":             workload.NullSentinel(),
+		"bool_format":            workload.BoolFormat,
+		"binary_format":          workload.BinaryFormat,
+		"array_format":           workload.ArrayFormat,
+		"json_format":            workload.JSONFormat,
+		"query_name_column":      workload.QueryNameColumn,
+		"collected_at_column":    workload.CollectedAtColumn,
+		"static_columns":         workload.StaticColumns,
+		"column_filter":          workload.ColumnFilter,
+		"columns_order":          workload.ColumnsOrder,
+		"drop_unordered_columns": workload.DropUnorderedColumns,
+		"deny_columns":           workload.DenyColumns,
+		"disambiguate_columns":   workload.DisambiguateColumns,
+		"column_aliases":         workload.ColumnAliases,
+		"column_transforms":      workload.ColumnTransforms,
+		"column_casts":           workload.ColumnCasts,
+		"cast_mode":              workload.CastMode,
+		"computed_columns":       workload.ComputedColumns,
+		"computed_column_errors": workload.ComputedColumnErrors,
+		"null_defaults":          workload.NullDefaults,
+		"sample_rate":            workload.SampleRate,
+		"sample_n":               workload.SampleN,
+	}
+	data, err := json.Marshal(shape)
+	if err != nil {
+		// Every value above encodes; an unexpected failure makes the checkpoint never match
+		logging.Warnf("Warning: could not fingerprint query %s for its checkpoint: %v", workload.QueryName, err)
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// resumeCheckpoint returns the checkpoint a -resume run continues for the
+// workload's query, or nil to collect every target. A checkpoint is discarded
+// when the query or its output settings changed, when it is older than
+// max_age, when its output file is gone, and on any run without -resume.
+func resumeCheckpoint(checkpoints *state.Checkpoints, workload *models.Workload, fingerprint string) *state.Checkpoint {
+	checkpoint := checkpoints.Get(workload.QueryName)
+	if checkpoint == nil {
+		if workload.Resume {
+			logging.Infof("No checkpoint for query %s; collecting every target", workload.QueryName)
+		}
+		return nil
+	}
+
+	maxAge := workload.Checkpoint.MaxAge.Duration
+	if maxAge <= 0 {
+		maxAge = models.DefaultCheckpointMaxAge
+	}
+	reason := ""
+	switch age := time.Since(checkpoint.SavedAt); {
+	case !workload.Resume:
+		reason = "the run was started without -resume"
+	case fingerprint == "" || checkpoint.Fingerprint != fingerprint:
+		reason = "the query or its output settings changed since it was saved"
+	case age > maxAge:
+		reason = fmt.Sprintf("it was saved %v ago, longer than max_age %v", age.Round(time.Second), maxAge)
+	default:
+		for _, path := range checkpoint.OutputFiles {
+			if _, err := os.Stat(path); err != nil {
+				reason = fmt.Sprintf("its output file %s is gone", path)
+				break
+			}
+		}
+	}
+	if reason != "" {
+		if workload.Resume {
+			logging.Warnf("Warning: discarding the checkpoint of query %s: %s; collecting every target", workload.QueryName, reason)
+		} else {
+			logging.Infof("Discarding the checkpoint of query %s: %s", workload.QueryName, reason)
+		}
+		checkpoints.Delete(workload.QueryName)
+		return nil
+	}
+	return checkpoint
+}
+
+// remainingTargets returns the targets a checkpoint doesn't list as written
+func remainingTargets(targets []string, checkpoint *state.Checkpoint) []string {
+	written := make(map[string]bool, len(checkpoint.Completed))
+	for _, target := range checkpoint.Completed {
+		written[target] = true
+	}
+	var remaining []string
+	for _, target := range targets {
+		if !written[target] {
+			remaining = append(remaining, target)
+		}
+	}
+	return remaining
+}
+
+// saveCheckpoint records which of the query's targets are in the output now
+// that it has been written: those of the previous checkpoint and those that
+// succeeded in this run. Failed and incomplete targets are left out, so
+// -resume collects them again. Once every target is written the query's
+// checkpoint is removed.
+func saveCheckpoint(checkpoints *state.Checkpoints, workload *models.Workload, targets []string, previous *state.Checkpoint,
+	fingerprint string, files []string, result executor.ExecutionResult) error {
+	written := make(map[string]bool, len(targets))
+	targetFiles := make(map[string]string)
+	if previous != nil {
+		for _, target := range previous.Completed {
+			written[target] = true
+		}
+		for target, path := range previous.TargetFiles {
+			targetFiles[target] = path
+		}
+		if len(files) == 0 {
+			files = previous.OutputFiles
+		}
+	}
+	for target := range result.TargetRows {
+		written[target] = true
+	}
+	for target, path := range result.TargetFiles {
+		targetFiles[target] = path
+	}
+
+	completed := []string{}
+	for _, target := range targets {
+		if written[target] {
+			completed = append(completed, target)
+		}
+	}
+	if len(completed) == len(targets) {
+		checkpoints.Delete(workload.QueryName)
+		if err := checkpoints.Save(); err != nil {
+			return err
+		}
+		if previous != nil {
+			logging.Infof("Every target of query %s is now written; removed its checkpoint", workload.QueryName)
+		}
+		return nil
+	}
+
+	checkpoints.Set(workload.QueryName, &state.Checkpoint{
+		Fingerprint: fingerprint,
+		SavedAt:     time.Now(),
+		OutputFiles: files,
+		TargetFiles: targetFiles,
+		Completed:   completed,
+	})
+	if err := checkpoints.Save(); err != nil {
+		return err
+	}
+	logging.Infof("Checkpoint saved to %s: %d of %d target(s) of query %s written; run again with -resume to collect the rest",
+		workload.Checkpoint.StateFile, len(completed), len(targets), workload.QueryName)
+	return nil
+}
@@ -0,0 +1,85 @@
+package csv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestAppendToCSVFileMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "new.csv")
+	if err := AppendToCSV([][]string{{"1"}}, path, true, []string{"id"}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, path, 0600)
+
+	// Appending to an existing file leaves its mode alone
+	if err := AppendToCSV([][]string{{"2"}}, path, true, []string{"id"}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, path, 0600)
+}
+
+func TestAppendToCSVGzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.csv.gz")
+	headers := []string{"id", "name"}
+	if err := AppendToCSV([][]string{{"1", "a"}}, path, true, headers, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendToCSV([][]string{{"2", "b"}, {"3", "c"}}, path, true, headers, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, gzipMagic) {
+		t.Fatal("appended file isn't gzip-compressed")
+	}
+
+	records, err := ReadCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{headers, {"1", "a"}, {"2", "b"}, {"3", "c"}}
+	if !slices.EqualFunc(records, want, slices.Equal) {
+		t.Errorf("ReadCSV() = %q, want %q", records, want)
+	}
+}
+
+func TestMergeCSVFilesFileMode(t *testing.T) {
+	dir := t.TempDir()
+	inputs := []string{filepath.Join(dir, "a.csv"), filepath.Join(dir, "b.csv")}
+	for i, input := range inputs {
+		if err := os.WriteFile(input, []byte("id\n"+string(rune('1'+i))+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 0664 is narrowed by the usual 022 umask unless the mode is set explicitly
+	output := filepath.Join(dir, "merged.csv")
+	rows, err := MergeCSVFiles(inputs, output, 0664)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Errorf("merged %d rows, want 2", rows)
+	}
+	assertMode(t, output, 0664)
+}
+
+// assertMode fails unless the file at path has exactly the permissions want
+func assertMode(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode().Perm(); got != want {
+		t.Errorf("%s has mode %04o, want %04o", filepath.Base(path), got, want)
+	}
+}
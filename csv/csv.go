@@ -1,66 +1,188 @@
 package csv
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"datacollector/models"
 )
 
+// maxNameAttempts bounds how many random suffixes are tried for a unique filename
+const maxNameAttempts = 100
+
+// random generates filename suffixes; rand.Rand isn't safe for concurrent use,
+// so access is serialised through randomMu
+var (
+	random   *rand.Rand
+	randomMu sync.Mutex
+)
+
+func init() {
+	// Seed once per process, mixing in the PID so parallel processes differ
+	random = rand.New(rand.NewSource(time.Now().UnixNano() ^ int64(os.Getpid())<<32))
+}
+
 // generateRandomString returns a random string of the specified length
 func generateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	randomMu.Lock()
+	defer randomMu.Unlock()
 	result := make([]byte, length)
 	for i := range result {
-		result[i] = charset[rand.Intn(len(charset))]
+		result[i] = charset[random.Intn(len(charset))]
 	}
 	return string(result)
 }
 
-// WriteToCSV writes the given data to a CSV file
-func WriteToCSV(data [][]string, headers []string, options models.WriteOptions) (string, error) {
-	// Initialize random seed
-	rand.Seed(time.Now().UnixNano())
-
+// OutputPath creates the output directory if needed and returns the full path
+// the file described by options should be written to. With AppendDate the
+// name gets a timestamp and random suffix, and the path is reserved by
+// creating it exclusively (regenerating the suffix if it is taken), so
+// concurrent or back-to-back runs never share a file.
+func OutputPath(options models.WriteOptions) (string, error) {
 	// Create directory if it doesn't exist
 	if options.Directory != "" {
-		if err := os.MkdirAll(options.Directory, 0755); err != nil {
+		if err := os.MkdirAll(options.Directory, options.DirPerm()); err != nil {
 			return "", fmt.Errorf("error creating directory: %w", err)
 		}
 	}
 
-	// Generate filename
-	filename := options.Filename
-	if options.AppendDate {
+	if !options.AppendDate {
+		return filepath.Join(options.Directory, outputFilename(options.Filename, options.Extension())), nil
+	}
+
+	for attempt := 0; attempt < maxNameAttempts; attempt++ {
 		// Add timestamp and 4 random chars to filename to make it unique
 		timestamp := time.Now().Format("2006-01-02_150405")
 		randomChars := generateRandomString(4)
-		ext := filepath.Ext(filename)
-		basename := filename[:len(filename)-len(ext)]
-		filename = fmt.Sprintf("%s_%s_%s%s", basename, timestamp, randomChars, ext)
+		ext := filepath.Ext(options.Filename)
+		basename := options.Filename[:len(options.Filename)-len(ext)]
+		filename := outputFilename(fmt.Sprintf("%s_%s_%s%s", basename, timestamp, randomChars, ext), options.Extension())
+		fullPath := filepath.Join(options.Directory, filename)
+
+		// Reserve the name; an existing file means we need another suffix
+		file, err := os.OpenFile(fullPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, options.FilePerm())
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reserving output file: %w", err)
+		}
+		file.Close()
+		return fullPath, nil
 	}
 
-	// Ensure .csv extension
-	if filepath.Ext(filename) != ".csv" {
-		filename = filename + ".csv"
+	return "", fmt.Errorf("could not find a free output filename for %s after %d attempts", options.Filename, maxNameAttempts)
+}
+
+// ReserveStem picks one file name stem shared by the outputs of several
+// formats and, with AppendDate, reserves <stem><ext> in options.Directory for
+// every extension the same way OutputPath does. Writing each format with
+// AppendDate off and Filename set to the stem then lands on those paths.
+func ReserveStem(options models.WriteOptions, extensions []string) (string, error) {
+	if options.Directory != "" {
+		if err := os.MkdirAll(options.Directory, options.DirPerm()); err != nil {
+			return "", fmt.Errorf("error creating directory: %w", err)
+		}
 	}
 
-	// Create full path
-	fullPath := filepath.Join(options.Directory, filename)
+	basename := options.Filename
+	for _, ext := range extensions {
+		if filepath.Ext(basename) == ext {
+			basename = basename[:len(basename)-len(ext)]
+			break
+		}
+	}
+	if !options.AppendDate {
+		return basename, nil
+	}
+
+	for attempt := 0; attempt < maxNameAttempts; attempt++ {
+		timestamp := time.Now().Format("2006-01-02_150405")
+		stem := fmt.Sprintf("%s_%s_%s", basename, timestamp, generateRandomString(4))
+
+		// Every extension must be free; otherwise release the ones taken so far
+		var reserved []string
+		taken := false
+		for _, ext := range extensions {
+			fullPath := filepath.Join(options.Directory, stem+ext)
+			file, err := os.OpenFile(fullPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, options.FilePerm())
+			if errors.Is(err, os.ErrExist) {
+				taken = true
+				break
+			}
+			if err != nil {
+				removeAll(reserved)
+				return "", fmt.Errorf("error reserving output file: %w", err)
+			}
+			file.Close()
+			reserved = append(reserved, fullPath)
+		}
+		if !taken {
+			return stem, nil
+		}
+		removeAll(reserved)
+	}
+
+	return "", fmt.Errorf("could not find a free output filename for %s after %d attempts", options.Filename, maxNameAttempts)
+}
+
+// removeAll deletes the given files, ignoring errors
+func removeAll(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// outputFilename ensures filename has the extension ext (e.g. ".csv")
+func outputFilename(filename string, ext string) string {
+	if filepath.Ext(filename) != ext {
+		return filename + ext
+	}
+	return filename
+}
+
+// CreateFile creates or truncates path with exactly the given permissions.
+// Unlike os.Create the mode is explicit, and it is re-applied in case the
+// file already existed or the umask masked some bits.
+func CreateFile(path string, perm os.FileMode) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// WriteToCSV writes the given data to a CSV file
+func WriteToCSV(data [][]string, headers []string, options models.WriteOptions) (string, error) {
+	fullPath, err := OutputPath(options)
+	if err != nil {
+		return "", err
+	}
 
 	// Create the file
-	file, err := os.Create(fullPath)
+	file, err := CreateFile(fullPath, options.FilePerm())
 	if err != nil {
 		return "", fmt.Errorf("error creating CSV file: %w", err)
 	}
 	defer file.Close()
 
 	// Create CSV writer
-	writer := csv.NewWriter(file)
+	writer := NewWriter(file, options)
 	defer writer.Flush()
 
 	// Write headers if provided
@@ -70,32 +192,161 @@ func WriteToCSV(data [][]string, headers []string, options models.WriteOptions)
 		}
 	}
 
+	// Write the column types as a second header row when requested
+	if options.ColumnTypesMode == models.ColumnTypesRow && len(headers) > 0 {
+		if err := writer.Write(AlignTypes(headers, options.ColumnTypes)); err != nil {
+			return "", fmt.Errorf("error writing column types to CSV: %w", err)
+		}
+	}
+
 	// Write data rows
 	if err := writer.WriteAll(data); err != nil {
 		return "", fmt.Errorf("error writing data to CSV: %w", err)
 	}
 
+	// Write the column types to a sidecar file when requested
+	if options.ColumnTypesMode == models.ColumnTypesSidecar && len(headers) > 0 {
+		if err := WriteTypesSidecar(fullPath+".types", headers, options.ColumnTypes, options.FilePerm()); err != nil {
+			return "", err
+		}
+	}
+
 	return fullPath, nil
 }
 
-// AppendToCSV appends data to an existing CSV file or creates a new one if it doesn't exist
-func AppendToCSV(data [][]string, filePath string, writeHeaders bool, headers []string) error {
-	// Check if file exists to determine if we need to write headers
+// AppendToOutput appends the records produced by rows to an output file
+// written earlier with the same options, e.g. by WriteToCSV. The file's
+// header must equal headers, so the appended records line up with the
+// columns already there. If writing fails the file is truncated back to its
+// previous size, leaving it as it was.
+func AppendToOutput(filePath string, headers []string, options models.WriteOptions, rows func(emit func([]string) error) error) error {
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("error opening output file to append to: %w", err)
+	}
+	defer file.Close()
+
+	expected := headers
+	if options.SanitizeFormulas {
+		expected = SanitizeFormulas(headers)
+	}
+	reader := csv.NewReader(decodeInput(file, options.Encoding))
+	reader.Comma = options.Comma()
+	reader.FieldsPerRecord = -1
+	existing, err := reader.Read()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading header of %s: %w", filePath, err)
+	}
+	if len(existing) > 0 && !equalHeaders(existing, expected) {
+		return fmt.Errorf("can't append to %s: its header %v differs from the result's %v", filePath, existing, expected)
+	}
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("error seeking to the end of %s: %w", filePath, err)
+	}
+	if size > 0 && options.Encoding == models.EncodingUTF8BOM {
+		options.Encoding = models.EncodingUTF8 // The file already starts with its byte order mark
+	}
+	writer := NewWriter(file, options)
+	err = func() error {
+		// A file left empty (no columns when it was written) gets its header now
+		if len(existing) == 0 && len(headers) > 0 {
+			if err := writer.Write(headers); err != nil {
+				return fmt.Errorf("error writing headers to CSV: %w", err)
+			}
+			if options.ColumnTypesMode == models.ColumnTypesRow {
+				if err := writer.Write(AlignTypes(headers, options.ColumnTypes)); err != nil {
+					return fmt.Errorf("error writing column types to CSV: %w", err)
+				}
+			}
+		}
+		if err := rows(writer.Write); err != nil {
+			return err
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return fmt.Errorf("error writing data to CSV: %w", err)
+		}
+		return nil
+	}()
+	if err != nil {
+		if truncateErr := file.Truncate(size); truncateErr != nil {
+			return fmt.Errorf("%w (and %s could not be restored: %v)", err, filePath, truncateErr)
+		}
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing CSV file: %w", err)
+	}
+	return nil
+}
+
+// AlignTypes returns one type name per header, padding missing entries with ""
+func AlignTypes(headers []string, types []string) []string {
+	aligned := make([]string, len(headers))
+	copy(aligned, types)
+	return aligned
+}
+
+// WriteTypesSidecar writes a "column,type" CSV describing each output column to path
+func WriteTypesSidecar(path string, headers []string, types []string, perm os.FileMode) error {
+	file, err := CreateFile(path, perm)
+	if err != nil {
+		return fmt.Errorf("error creating column types file: %w", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	records := [][]string{{"column", "type"}}
+	for i, columnType := range AlignTypes(headers, types) {
+		records = append(records, []string{headers[i], columnType})
+	}
+	if err := writer.WriteAll(records); err != nil {
+		return fmt.Errorf("error writing column types file: %w", err)
+	}
+	return nil
+}
+
+// AppendToCSV appends data to an existing CSV file or creates a new one if it
+// doesn't exist. A file it starts (new or empty) gets exactly the permissions perm.
+// A gzip file (a ".gz" path, or an existing file starting with the gzip magic
+// bytes) gets the rows as a new gzip member, which gzip readers, including
+// ReadCSV, read as one continuous stream.
+func AppendToCSV(data [][]string, filePath string, writeHeaders bool, headers []string, perm os.FileMode) error {
+	// Check if file exists to determine if we need to write headers;
+	// an existing but empty file (e.g. a reserved output path) counts as new
 	fileExists := false
-	if _, err := os.Stat(filePath); err == nil {
+	if info, err := os.Stat(filePath); err == nil && info.Size() > 0 {
 		fileExists = true
 	}
+	compressed, err := appendsGzip(filePath, fileExists)
+	if err != nil {
+		return err
+	}
 
 	// Open file in append mode or create it
-	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, perm)
 	if err != nil {
 		return fmt.Errorf("error opening/creating CSV file: %w", err)
 	}
 	defer file.Close()
+	// As in CreateFile, the umask must not narrow the configured mode
+	if !fileExists {
+		if err := file.Chmod(perm); err != nil {
+			return fmt.Errorf("error setting CSV file mode: %w", err)
+		}
+	}
+
+	var out io.Writer = file
+	var gz *gzip.Writer
+	if compressed {
+		gz = gzip.NewWriter(file)
+		out = gz
+	}
 
 	// Create CSV writer
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	writer := csv.NewWriter(out)
 
 	// Write headers if the file is new and headers are provided
 	if !fileExists && writeHeaders && len(headers) > 0 {
@@ -109,13 +360,48 @@ func AppendToCSV(data [][]string, filePath string, writeHeaders bool, headers []
 		return fmt.Errorf("error writing data to CSV: %w", err)
 	}
 
+	// Closing the member writes its trailer; without it the file is truncated
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("error writing data to CSV: %w", err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing CSV file: %w", err)
+	}
 	return nil
 }
 
-// ReadCSV reads data from a CSV file
+// appendsGzip reports whether AppendToCSV must write filePath as gzip. A
+// ".gz" path that already holds uncompressed data is an error, since a gzip
+// member after it would leave a file no reader can use.
+func appendsGzip(filePath string, fileExists bool) (bool, error) {
+	named := strings.EqualFold(filepath.Ext(filePath), ".gz")
+	if !fileExists {
+		return named, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, fmt.Errorf("error opening/creating CSV file: %w", err)
+	}
+	defer file.Close()
+	magic := make([]byte, len(gzipMagic))
+	n, err := io.ReadFull(file, magic)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false, fmt.Errorf("error reading CSV file: %w", err)
+	}
+	gzipped := bytes.Equal(magic[:n], gzipMagic)
+	if named && !gzipped {
+		return false, fmt.Errorf("can't append to %s: it has a .gz extension but isn't gzip-compressed", filePath)
+	}
+	return gzipped, nil
+}
+
+// ReadCSV reads data from a CSV file, which may be gzip-compressed
 func ReadCSV(filePath string) ([][]string, error) {
 	// Open the file
-	file, err := os.Open(filePath)
+	file, err := openInput(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("error opening CSV file: %w", err)
 	}
@@ -132,3 +418,146 @@ func ReadCSV(filePath string) ([][]string, error) {
 
 	return records, nil
 }
+
+// ReadCSVAsMaps reads a CSV file, treating the first row as headers, and returns
+// one map per data row keyed by column name. Duplicate header names are made
+// unique by suffixing later occurrences with their position ("id", "id_2", "id_3").
+// An empty file yields an empty slice and no error.
+func ReadCSVAsMaps(filePath string) ([]map[string]string, error) {
+	records, err := ReadCSV(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []map[string]string{}
+	if len(records) == 0 {
+		return result, nil
+	}
+
+	headers, _ := UniqueHeaders(records[0])
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(headers))
+		for i, header := range headers {
+			if i < len(record) {
+				row[header] = record[i]
+			}
+		}
+		result = append(result, row)
+	}
+
+	return result, nil
+}
+
+// UniqueHeaders returns a copy of headers in which the second and later
+// occurrences of a name get a numeric suffix (id, id_2, id_3, ...), skipping
+// names that are already taken. It also describes each rename ("id -> id_2").
+func UniqueHeaders(headers []string) ([]string, []string) {
+	unique := make([]string, len(headers))
+	seen := make(map[string]int, len(headers))
+	taken := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		taken[header] = true
+	}
+
+	var renamed []string
+	for i, header := range headers {
+		seen[header]++
+		if seen[header] == 1 {
+			unique[i] = header
+			continue
+		}
+
+		// Find the next free suffix for this name
+		n := seen[header]
+		candidate := fmt.Sprintf("%s_%d", header, n)
+		for taken[candidate] {
+			n++
+			candidate = fmt.Sprintf("%s_%d", header, n)
+		}
+		seen[header] = n
+		taken[candidate] = true
+		unique[i] = candidate
+		renamed = append(renamed, header+" -> "+candidate)
+	}
+
+	return unique, renamed
+}
+
+// MergeCSVFiles concatenates the CSV files at paths into outputPath, writing
+// the shared header once. Every input must have the same header as the first
+// non-empty one; headers are checked before anything is written, and a
+// mismatch aborts with an error naming the offending file.
+// It returns the number of data rows written. A new outputPath gets the
+// permissions perm.
+func MergeCSVFiles(paths []string, outputPath string, perm os.FileMode) (int, error) {
+	// Validate all headers up front so a mismatch leaves no partial output
+	var headers []string
+	var headerSource string
+	for _, path := range paths {
+		header, err := readHeader(path)
+		if err != nil {
+			return 0, err
+		}
+		if header == nil {
+			continue // Empty file: nothing to merge, not even a header
+		}
+		if headers == nil {
+			headers, headerSource = header, path
+		} else if !equalHeaders(headers, header) {
+			return 0, fmt.Errorf("header mismatch in %s: got %v, expected %v (from %s)",
+				path, header, headers, headerSource)
+		}
+	}
+	if headers == nil {
+		return 0, fmt.Errorf("no CSV data found in %d input file(s)", len(paths))
+	}
+
+	rowCount := 0
+	for _, path := range paths {
+		records, err := ReadCSV(path)
+		if err != nil {
+			return rowCount, err
+		}
+		if len(records) == 0 {
+			continue
+		}
+		if err := AppendToCSV(records[1:], outputPath, true, headers, perm); err != nil {
+			return rowCount, err
+		}
+		rowCount += len(records) - 1
+	}
+
+	return rowCount, nil
+}
+
+// readHeader returns the first record of a (possibly gzipped) CSV file, or
+// nil if it is empty
+func readHeader(filePath string) ([]string, error) {
+	file, err := openInput(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening CSV file: %w", err)
+	}
+	defer file.Close()
+
+	header, err := csv.NewReader(file).Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV header from %s: %w", filePath, err)
+	}
+	return header, nil
+}
+
+// equalHeaders reports whether two header rows are identical
+func equalHeaders(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package csv
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"datacollector/models"
+)
+
+// reserveConcurrently calls reserve from n goroutines at once and returns the results
+func reserveConcurrently(t *testing.T, n int, reserve func() (string, error)) []string {
+	t.Helper()
+	names := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			names[i], errs[i] = reserve()
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return names
+}
+
+// assertDistinct fails unless every name is different
+func assertDistinct(t *testing.T, names []string) {
+	t.Helper()
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			t.Fatalf("%s was handed out twice", name)
+		}
+		seen[name] = true
+	}
+}
+
+func TestOutputPathUnique(t *testing.T) {
+	options := models.WriteOptions{Directory: t.TempDir(), Filename: "results", AppendDate: true}
+	paths := reserveConcurrently(t, 200, func() (string, error) { return OutputPath(options) })
+	assertDistinct(t, paths)
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("reserved path %s was not created: %v", path, err)
+		}
+	}
+}
+
+func TestReserveStemUnique(t *testing.T) {
+	options := models.WriteOptions{Directory: t.TempDir(), Filename: "results", AppendDate: true}
+	extensions := []string{".csv", ".json"}
+	stems := reserveConcurrently(t, 200, func() (string, error) { return ReserveStem(options, extensions) })
+	assertDistinct(t, stems)
+	for _, stem := range stems {
+		for _, ext := range extensions {
+			if _, err := os.Stat(filepath.Join(options.Directory, stem+ext)); err != nil {
+				t.Errorf("reserved file %s%s was not created: %v", stem, ext, err)
+			}
+		}
+	}
+}
+
+func TestWriteToCSVFileMode(t *testing.T) {
+	for _, appendDate := range []bool{false, true} {
+		name := "fixed name"
+		if appendDate {
+			name = "reserved name" // Created first with O_EXCL by OutputPath
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "restricted")
+			options := models.WriteOptions{
+				Directory:  dir,
+				Filename:   "out",
+				AppendDate: appendDate,
+				FileMode:   0600,
+				DirMode:    0700,
+			}
+			path, err := WriteToCSV([][]string{{"1"}}, []string{"id"}, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertMode(t, path, 0600)
+			assertMode(t, dir, 0700)
+
+			// The umask must not narrow a wider mode either
+			options.FileMode = 0660
+			path, err = WriteToCSV([][]string{{"1"}}, []string{"id"}, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertMode(t, path, 0660)
+		})
+	}
+}
+
+func TestReserveStemFileMode(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "restricted")
+	options := models.WriteOptions{Directory: dir, Filename: "out", AppendDate: true, FileMode: 0600, DirMode: 0700}
+	stem, err := ReserveStem(options, []string{".csv", ".json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMode(t, dir, 0700)
+	for _, ext := range []string{".csv", ".json"} {
+		assertMode(t, filepath.Join(dir, stem+ext), 0600)
+	}
+}
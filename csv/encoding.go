@@ -0,0 +1,100 @@
+package csv
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+
+	"datacollector/models"
+)
+
+// utf8BOM is the byte order mark written first with models.EncodingUTF8BOM
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// codepages are the single-byte encodings output files can be written in
+var codepages = map[string]encoding.Encoding{
+	"windows-1250": charmap.Windows1250, // Central European
+	"windows-1251": charmap.Windows1251, // Cyrillic
+	"windows-1252": charmap.Windows1252, // Western European, the usual Windows ANSI code page
+	"iso-8859-1":   charmap.ISO8859_1,
+	"iso-8859-15":  charmap.ISO8859_15,
+}
+
+// ValidateEncoding checks a configured output encoding
+func ValidateEncoding(name string) error {
+	if name == "" || name == models.EncodingUTF8 || name == models.EncodingUTF8BOM || codepages[name] != nil {
+		return nil
+	}
+	names := []string{models.EncodingUTF8, models.EncodingUTF8BOM}
+	for codepage := range codepages {
+		names = append(names, codepage)
+	}
+	sort.Strings(names[2:])
+	return fmt.Errorf("unsupported encoding %q (supported: %s)", name, strings.Join(names, ", "))
+}
+
+// encodeOutput returns w converting the UTF-8 written to it to the named
+// encoding: a byte order mark goes first with models.EncodingUTF8BOM, a code
+// page re-encodes every byte. Plain UTF-8 returns w itself.
+func encodeOutput(w io.Writer, name string) io.Writer {
+	if name == models.EncodingUTF8BOM {
+		return &bomWriter{w: w}
+	}
+	if codepage := codepages[name]; codepage != nil {
+		return &encodingWriter{w: transform.NewWriter(w, codepage.NewEncoder()), name: name}
+	}
+	return w
+}
+
+// decodeInput returns r converting a file written in the named encoding back
+// to UTF-8, without its byte order mark
+func decodeInput(r io.Reader, name string) io.Reader {
+	if name == models.EncodingUTF8BOM {
+		return transform.NewReader(r, unicode.UTF8BOM.NewDecoder())
+	}
+	if codepage := codepages[name]; codepage != nil {
+		return transform.NewReader(r, codepage.NewDecoder())
+	}
+	return r
+}
+
+// bomWriter writes a byte order mark before the first bytes written to it
+type bomWriter struct {
+	w       io.Writer
+	started bool
+}
+
+// Write writes p, preceded by the byte order mark on the first call
+func (b *bomWriter) Write(p []byte) (int, error) {
+	if !b.started {
+		if _, err := b.w.Write(utf8BOM); err != nil {
+			return 0, err
+		}
+		b.started = true
+	}
+	return b.w.Write(p)
+}
+
+// encodingWriter is a transform.Writer to a code page whose errors name the
+// encoding, since a character it lacks is otherwise reported without context.
+// Code page encoders keep no state between runes, so it needs no closing:
+// only the bytes of a rune split across two writes are held back.
+type encodingWriter struct {
+	w    io.Writer
+	name string
+}
+
+// Write encodes p
+func (e *encodingWriter) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	if err != nil {
+		return n, fmt.Errorf("can't write the output in %s (use utf-8 for characters it lacks): %w", e.name, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,48 @@
+package csv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"datacollector/models"
+)
+
+func TestWriteUTF8BOM(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf, models.WriteOptions{Encoding: models.EncodingUTF8BOM})
+	if err := writer.WriteAll([][]string{{"name"}, {"café"}}); err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte(nil), utf8BOM...), "name\ncafé\n"...)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("output = %q, want %q", buf.Bytes(), want)
+	}
+}
+
+func TestWriteCodepage(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewWriter(&buf, models.WriteOptions{Encoding: "windows-1252"})
+	if err := writer.WriteAll([][]string{{"café", "€"}}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "caf\xe9,\x80\n"; buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestWriteCodepageMissingCharacter(t *testing.T) {
+	options := models.WriteOptions{
+		Directory: t.TempDir(),
+		Filename:  "cyrillic",
+		Encoding:  "windows-1252",
+	}
+	_, err := WriteToCSV([][]string{{"Москва"}}, []string{"city"}, options)
+	if err == nil {
+		t.Fatal("WriteToCSV() wrote Cyrillic text in windows-1252")
+	}
+	want := "can't write the output in windows-1252 (use utf-8 for characters it lacks)"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("WriteToCSV() error = %q, want it to contain %q", err, want)
+	}
+}
@@ -0,0 +1,25 @@
+package csv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUniqueHeaders(t *testing.T) {
+	tests := []struct {
+		headers []string
+		want    []string
+		renamed []string
+	}{
+		{[]string{"id", "name"}, []string{"id", "name"}, nil},
+		{[]string{"id", "name", "id", "name"}, []string{"id", "name", "id_2", "name_2"}, []string{"id -> id_2", "name -> name_2"}},
+		{[]string{"id", "id", "id"}, []string{"id", "id_2", "id_3"}, []string{"id -> id_2", "id -> id_3"}},
+		{[]string{"id", "id_2", "id"}, []string{"id", "id_2", "id_3"}, []string{"id -> id_3"}},
+	}
+	for _, tt := range tests {
+		got, renamed := UniqueHeaders(tt.headers)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) || fmt.Sprint(renamed) != fmt.Sprint(tt.renamed) {
+			t.Errorf("UniqueHeaders(%v) = %v, %v, want %v, %v", tt.headers, got, renamed, tt.want, tt.renamed)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package csv
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gzipMagic are the first bytes of every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// inputFile is an opened input CSV, decompressed when it is gzipped
+type inputFile struct {
+	io.Reader
+	file *os.File
+	gz   *gzip.Reader
+}
+
+// openInput opens filePath for reading. Files with a ".gz" extension or
+// starting with the gzip magic bytes are decompressed transparently.
+func openInput(filePath string) (*inputFile, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(len(gzipMagic))
+	if !strings.EqualFold(filepath.Ext(filePath), ".gz") && string(magic) != string(gzipMagic) {
+		return &inputFile{Reader: buffered, file: file}, nil
+	}
+
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error opening gzip stream: %w", err)
+	}
+	return &inputFile{Reader: gz, file: file, gz: gz}, nil
+}
+
+// Close closes the decompressor, if any, and the file
+func (f *inputFile) Close() error {
+	if f.gz != nil {
+		f.gz.Close()
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,186 @@
+package csv
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"strings"
+
+	"datacollector/models"
+)
+
+// Writer is the part of encoding/csv.Writer used to write output files
+type Writer interface {
+	Write(record []string) error
+	WriteAll(records [][]string) error
+	Flush()
+	Error() error
+}
+
+// NewWriter returns a writer for w using the delimiter of options.Format.
+// With options.QuoteAll every field is enclosed in double quotes, which
+// encoding/csv cannot do by itself.
+// With options.SanitizeFormulas fields that a spreadsheet would evaluate as
+// a formula are prefixed with a single quote.
+// Buffered rows are flushed every options.FlushEvery() records.
+// The bytes are written in options.Encoding.
+func NewWriter(w io.Writer, options models.WriteOptions) Writer {
+	w = encodeOutput(w, options.Encoding)
+	var writer Writer
+	if options.QuoteAll {
+		writer = &quotingWriter{w: bufio.NewWriter(w), comma: options.Comma()}
+	} else {
+		csvWriter := csv.NewWriter(w)
+		csvWriter.Comma = options.Comma()
+		writer = csvWriter
+	}
+	if options.SanitizeFormulas {
+		writer = &sanitizingWriter{Writer: writer}
+	}
+	if every := options.FlushEvery(); every > 0 {
+		writer = &flushingWriter{Writer: writer, every: every}
+	}
+	return writer
+}
+
+// flushingWriter flushes the underlying writer every `every` records and
+// returns any error from that flush, so a write failure such as a full disk
+// surfaces while rows are written rather than at the final Flush
+type flushingWriter struct {
+	Writer
+	every   int
+	pending int
+}
+
+// Write writes a record, flushing once every `every` records
+func (f *flushingWriter) Write(record []string) error {
+	if err := f.Writer.Write(record); err != nil {
+		return err
+	}
+	f.pending++
+	if f.pending < f.every {
+		return nil
+	}
+	f.pending = 0
+	f.Writer.Flush()
+	return f.Writer.Error()
+}
+
+// WriteAll writes all records in flushed batches and a final flush
+func (f *flushingWriter) WriteAll(records [][]string) error {
+	for _, record := range records {
+		if err := f.Write(record); err != nil {
+			return err
+		}
+	}
+	f.Flush()
+	return f.Error()
+}
+
+// Flush writes any buffered data and starts a new batch
+func (f *flushingWriter) Flush() {
+	f.pending = 0
+	f.Writer.Flush()
+}
+
+// sanitizingWriter neutralizes spreadsheet formula injection by prefixing
+// fields that start with =, +, - or @ with a single quote
+type sanitizingWriter struct {
+	Writer
+}
+
+// Write writes record with its formula-like fields escaped
+func (s *sanitizingWriter) Write(record []string) error {
+	return s.Writer.Write(SanitizeFormulas(record))
+}
+
+// WriteAll writes all records with their formula-like fields escaped
+func (s *sanitizingWriter) WriteAll(records [][]string) error {
+	for _, record := range records {
+		if err := s.Write(record); err != nil {
+			return err
+		}
+	}
+	s.Flush()
+	return s.Error()
+}
+
+// SanitizeFormulas returns record with every field starting with =, +, - or
+// @ prefixed with a single quote. Other fields are left untouched, and record
+// itself is only copied when a field changes.
+func SanitizeFormulas(record []string) []string {
+	var sanitized []string
+	for i, field := range record {
+		if !isFormula(field) {
+			continue
+		}
+		if sanitized == nil {
+			sanitized = append([]string(nil), record...)
+		}
+		sanitized[i] = "'" + field
+	}
+	if sanitized == nil {
+		return record
+	}
+	return sanitized
+}
+
+// isFormula reports whether a spreadsheet would treat field as a formula
+func isFormula(field string) bool {
+	if field == "" {
+		return false
+	}
+	switch field[0] {
+	case '=', '+', '-', '@':
+		return true
+	}
+	return false
+}
+
+// quotingWriter writes RFC 4180 records in which every field is quoted and
+// embedded quotes are doubled, using the same "\n" line endings as csv.Writer
+type quotingWriter struct {
+	w     *bufio.Writer
+	comma rune
+	err   error
+}
+
+// Write writes a single record; like csv.Writer it is buffered until Flush
+func (q *quotingWriter) Write(record []string) error {
+	if q.err != nil {
+		return q.err
+	}
+	for i, field := range record {
+		if i > 0 {
+			q.w.WriteRune(q.comma)
+		}
+		q.w.WriteByte('"')
+		q.w.WriteString(strings.ReplaceAll(field, `"`, `""`))
+		q.w.WriteByte('"')
+	}
+	_, q.err = q.w.WriteString("\n")
+	return q.err
+}
+
+// WriteAll writes all records and flushes, as csv.Writer.WriteAll does
+func (q *quotingWriter) WriteAll(records [][]string) error {
+	for _, record := range records {
+		if err := q.Write(record); err != nil {
+			return err
+		}
+	}
+	q.Flush()
+	return q.err
+}
+
+// Flush writes any buffered data to the underlying writer
+func (q *quotingWriter) Flush() {
+	if err := q.w.Flush(); err != nil && q.err == nil {
+		q.err = err
+	}
+}
+
+// Error reports any error from a previous Write or Flush
+func (q *quotingWriter) Error() error {
+	return q.err
+}
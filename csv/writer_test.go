@@ -0,0 +1,55 @@
+package csv
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSanitizeFormulas(t *testing.T) {
+	tests := []struct {
+		name   string
+		record []string
+		want   []string
+	}{
+		{"equals", []string{"=SUM(A1:A2)", "x"}, []string{"'=SUM(A1:A2)", "x"}},
+		{"plus", []string{"+1", "x"}, []string{"'+1", "x"}},
+		{"minus", []string{"-1", "x"}, []string{"'-1", "x"}},
+		{"at", []string{"@cmd", "x"}, []string{"'@cmd", "x"}},
+		{"several", []string{"=1", "ok", "@2"}, []string{"'=1", "ok", "'@2"}},
+		{"trigger not first", []string{"a=b", "1+1", "x-y"}, []string{"a=b", "1+1", "x-y"}},
+		{"empty", []string{"", "x"}, []string{"", "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := slices.Clone(tt.record)
+			got := SanitizeFormulas(tt.record)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("SanitizeFormulas(%q) = %q, want %q", original, got, tt.want)
+			}
+			if !slices.Equal(tt.record, original) {
+				t.Errorf("SanitizeFormulas modified its input: %q, was %q", tt.record, original)
+			}
+		})
+	}
+}
+
+func TestSanitizeFormulasNoCopy(t *testing.T) {
+	record := []string{"plain", "1", ""}
+	got := SanitizeFormulas(record)
+	if &got[0] != &record[0] {
+		t.Error("SanitizeFormulas copied a record that needed no changes")
+	}
+}
+
+func TestIsFormula(t *testing.T) {
+	for _, field := range []string{"=", "+", "-", "@", "=A1", "-5"} {
+		if !isFormula(field) {
+			t.Errorf("isFormula(%q) = false, want true", field)
+		}
+	}
+	for _, field := range []string{"", "a", "1", " =A1", "'=A1"} {
+		if isFormula(field) {
+			t.Errorf("isFormula(%q) = true, want false", field)
+		}
+	}
+}
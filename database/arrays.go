@@ -0,0 +1,229 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Representations of PostgreSQL array values (columns of type int[], text[], ...)
+const (
+	ArrayFormatText  = "text"  // The PostgreSQL literal, e.g. {1,2,3} (default)
+	ArrayFormatComma = "comma" // The elements joined with commas, nested arrays flattened: 1,2,3
+	ArrayFormatJSON  = "json"  // A JSON array, with numbers, booleans and JSON elements unquoted: [1,2,3]
+)
+
+// ValidateArrayFormat checks a configured array representation
+func ValidateArrayFormat(format string) error {
+	switch format {
+	case "", ArrayFormatText, ArrayFormatComma, ArrayFormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("invalid array_format %q (expected %q, %q or %q)", format,
+			ArrayFormatText, ArrayFormatComma, ArrayFormatJSON)
+	}
+}
+
+// isArrayType reports whether a database type name denotes a PostgreSQL
+// array; the driver names them after the element type with a leading
+// underscore (_INT4, _TEXT, _JSONB)
+func isArrayType(columnType string) bool {
+	return len(columnType) > 1 && columnType[0] == '_'
+}
+
+// formatArray renders a PostgreSQL array literal in the configured
+// representation. Literals that don't parse are left as they are.
+func formatArray(literal string, columnType string, options QueryOptions) string {
+	if options.ArrayFormat == "" || options.ArrayFormat == ArrayFormatText {
+		return literal
+	}
+	elements, err := parseArray(literal)
+	if err != nil {
+		return literal
+	}
+
+	elementType := strings.ToUpper(columnType[1:])
+	if options.ArrayFormat == ArrayFormatComma {
+		var leaves []string
+		flattenArray(elements, elementType, options, &leaves)
+		return strings.Join(leaves, ",")
+	}
+
+	// Element text such as "<b>" is written as is rather than \u-escaped
+	var encoded strings.Builder
+	encoder := json.NewEncoder(&encoded)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(arrayJSON(elements, elementType)); err != nil {
+		return literal
+	}
+	return strings.TrimSuffix(encoded.String(), "\n")
+}
+
+// flattenArray appends the elements of a parsed array to leaves, depth first,
+// with NULL elements written as the NULL text and booleans in bool_format
+func flattenArray(elements []interface{}, elementType string, options QueryOptions, leaves *[]string) {
+	for _, element := range elements {
+		switch v := element.(type) {
+		case nil:
+			*leaves = append(*leaves, options.NullValue)
+		case []interface{}:
+			flattenArray(v, elementType, options, leaves)
+		case string:
+			if b, ok := parseBool(v); ok && elementType == "BOOL" {
+				v = formatBool(b, options.BoolFormat)
+			}
+			*leaves = append(*leaves, v)
+		}
+	}
+}
+
+// arrayJSON converts a parsed array to values the JSON encoder writes as the
+// JSON array: numbers and booleans by the element type, and JSON elements
+// as the documents they hold
+func arrayJSON(elements []interface{}, elementType string) []interface{} {
+	converted := make([]interface{}, len(elements))
+	for i, element := range elements {
+		switch v := element.(type) {
+		case []interface{}:
+			converted[i] = arrayJSON(v, elementType)
+		case string:
+			converted[i] = elementJSON(v, elementType)
+		}
+	}
+	return converted
+}
+
+// elementJSON converts one array element; anything that isn't valid JSON in
+// its unquoted form (NaN, Infinity) stays a string
+func elementJSON(element string, elementType string) interface{} {
+	switch elementType {
+	case "INT2", "INT4", "INT8", "FLOAT4", "FLOAT8", "NUMERIC", "OID":
+		if _, err := strconv.ParseFloat(element, 64); err == nil && json.Valid([]byte(element)) {
+			return json.RawMessage(element)
+		}
+	case "BOOL":
+		if b, ok := parseBool(element); ok {
+			return b
+		}
+	case "JSON", "JSONB":
+		if json.Valid([]byte(element)) {
+			return json.RawMessage(element)
+		}
+	}
+	return element
+}
+
+// parseArray parses a PostgreSQL array literal such as {1,NULL,"a b"} or
+// [0:1]={{1,2},{3,4}} into its elements: a string per value, nil for NULL and
+// a nested slice per sub-array
+func parseArray(literal string) ([]interface{}, error) {
+	// A lower bound other than 1 is written as a dimension decoration first
+	if strings.HasPrefix(literal, "[") {
+		i := strings.Index(literal, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid array dimensions in %q", literal)
+		}
+		literal = literal[i+1:]
+	}
+
+	parser := arrayParser{input: literal}
+	elements, err := parser.array()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos != len(parser.input) {
+		return nil, fmt.Errorf("unexpected %q after array", parser.input[parser.pos:])
+	}
+	return elements, nil
+}
+
+// arrayParser reads a PostgreSQL array literal
+type arrayParser struct {
+	input string
+	pos   int
+}
+
+// array reads one brace-delimited array, starting at its opening brace
+func (p *arrayParser) array() ([]interface{}, error) {
+	if p.pos >= len(p.input) || p.input[p.pos] != '{' {
+		return nil, fmt.Errorf("expected { at offset %d", p.pos)
+	}
+	p.pos++
+	elements := []interface{}{}
+	if p.pos < len(p.input) && p.input[p.pos] == '}' {
+		p.pos++
+		return elements, nil
+	}
+
+	for {
+		var element interface{}
+		var err error
+		switch {
+		case p.pos >= len(p.input):
+			return nil, fmt.Errorf("unterminated array")
+		case p.input[p.pos] == '{':
+			element, err = p.array()
+		case p.input[p.pos] == '"':
+			element, err = p.quoted()
+		default:
+			element = p.unquoted()
+		}
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+
+		if p.pos >= len(p.input) {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return elements, nil
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", p.input[p.pos], p.pos)
+		}
+	}
+}
+
+// quoted reads a double-quoted element, in which a backslash escapes the
+// next character
+func (p *arrayParser) quoted() (interface{}, error) {
+	p.pos++ // Opening quote
+	var value strings.Builder
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch c {
+		case '\\':
+			p.pos++
+			if p.pos >= len(p.input) {
+				return nil, fmt.Errorf("unterminated quoted element")
+			}
+			value.WriteByte(p.input[p.pos])
+		case '"':
+			p.pos++
+			return value.String(), nil
+		default:
+			value.WriteByte(c)
+		}
+		p.pos++
+	}
+	return nil, fmt.Errorf("unterminated quoted element")
+}
+
+// unquoted reads an element up to the next comma or closing brace; an
+// unquoted NULL is the NULL value
+func (p *arrayParser) unquoted() interface{} {
+	start := p.pos
+	for p.pos < len(p.input) && p.input[p.pos] != ',' && p.input[p.pos] != '}' {
+		p.pos++
+	}
+	value := strings.TrimSpace(p.input[start:p.pos])
+	if strings.EqualFold(value, "NULL") {
+		return nil
+	}
+	return value
+}
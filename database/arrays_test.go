@@ -0,0 +1,41 @@
+package database
+
+import "testing"
+
+func TestFormatIntArray(t *testing.T) {
+	tests := []struct {
+		name    string
+		literal string
+		format  string
+		want    string
+	}{
+		{"default", "{1,2,3}", "", "{1,2,3}"},
+		{"text", "{1,2,3}", ArrayFormatText, "{1,2,3}"},
+		{"comma", "{1,2,3}", ArrayFormatComma, "1,2,3"},
+		{"json", "{1,2,3}", ArrayFormatJSON, "[1,2,3]"},
+		{"empty comma", "{}", ArrayFormatComma, ""},
+		{"empty json", "{}", ArrayFormatJSON, "[]"},
+		{"null comma", "{1,NULL,3}", ArrayFormatComma, "1,NULL,3"},
+		{"null json", "{1,NULL,3}", ArrayFormatJSON, "[1,null,3]"},
+		{"nested comma", "{{1,2},{3,4}}", ArrayFormatComma, "1,2,3,4"},
+		{"nested json", "{{1,2},{3,4}}", ArrayFormatJSON, "[[1,2],[3,4]]"},
+		{"negative json", "{-1,0}", ArrayFormatJSON, "[-1,0]"},
+		{"unparsable", "{1,2", ArrayFormatJSON, "{1,2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := QueryOptions{ArrayFormat: tt.format, NullValue: DefaultNullValue}
+			if got := formatValue(tt.literal, "_INT4", options); got != tt.want {
+				t.Errorf("formatValue(%q, _INT4, %q) = %q, want %q", tt.literal, tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatJSONBArray(t *testing.T) {
+	literal := `{"{\"a\": 1}","[1, 2]"}`
+	options := QueryOptions{ArrayFormat: ArrayFormatJSON}
+	if got, want := formatValue(literal, "_JSONB", options), `[{"a":1},[1,2]]`; got != want {
+		t.Errorf("formatValue(%q, _JSONB) = %q, want %q", literal, got, want)
+	}
+}
@@ -1,14 +1,23 @@
 package database
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
-	"gorm.io/driver/mysql"
-	"gorm.io/driver/postgres"
+	"golang.org/x/crypto/ssh"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+
+	"datacollector/logging"
 )
 
 // Config holds database configuration
@@ -20,16 +29,104 @@ type Config struct {
 	Password string
 	Database string
 	SSLMode  string // For PostgreSQL
+
+	// Socket is the path of a Unix domain socket to connect through instead
+	// of Host and Port: the socket file for MySQL, and for PostgreSQL its
+	// directory (the file is then chosen by Port) or the file itself
+	Socket string
+
+	Charset   string // MySQL: connection charset (default "utf8mb4")
+	Collation string // MySQL: connection collation (default: server default for the charset)
+	Loc       string // MySQL: time zone for parsed DATETIME values (default "Local")
+	ParseTime *bool  // MySQL: parse DATE/DATETIME into time values (default true)
+
+	SSLRootCert string // CA certificate used to verify the server; takes precedence over CABundle in every driver
+	SSLCert     string // Client certificate for mutual TLS; turns TLS on for MySQL
+	SSLKey      string // Private key of SSLCert
+
+	// CABundle is a PEM file of CA certificates every driver verifies the
+	// server against, unless SSLRootCert names a more specific one; setting
+	// it turns TLS on for MySQL and, unless SSLMode says otherwise, PostgreSQL
+	CABundle string
+
+	ConnectTimeout time.Duration // Maximum time to establish a connection (0 = driver default)
+
+	// DSNParams are extra driver parameters appended verbatim (escaped) to the
+	// DSN after the ones set above, so they take precedence
+	DSNParams map[string]string
+
+	SSH *SSHConfig // Optional bastion the connection is tunnelled through
+
+	// Proxy is an HTTP CONNECT proxy URL connections are routed through
+	// (subject to NO_PROXY); it is not used together with SSH
+	Proxy string
+
+	// InitSQL statements run on the connection right after it is established,
+	// e.g. SET statements standardizing session settings
+	InitSQL []string
+}
+
+// ErrConnectTimeout is returned when a connection cannot be established within Config.ConnectTimeout
+var ErrConnectTimeout = errors.New("connection timed out")
+
+// ErrQueryTimeout is returned when a query does not finish before its context deadline
+var ErrQueryTimeout = errors.New("query timed out")
+
+// ErrInitSQL is returned when an init_sql statement fails on a new connection
+var ErrInitSQL = errors.New("init_sql failed")
+
+// DefaultNullValue is the text written for NULL values when no sentinel is configured
+const DefaultNullValue = "NULL"
+
+// QueryOptions controls how query results are converted to text
+type QueryOptions struct {
+	NullValue  string // Text used for NULL values (e.g. "NULL", "\\N" or "")
+	BoolFormat string // BoolFormatNumeric (default) or BoolFormatText
+
+	BinaryFormat string // BinaryFormatHex (default), BinaryFormatBase64, BinaryFormatPlaceholder or BinaryFormatRaw
+	ArrayFormat  string // ArrayFormatText (default), ArrayFormatComma or ArrayFormatJSON
+	JSONFormat   string // JSONFormatText (default), JSONFormatCompact or JSONFormatPretty
+
+	Sampler *Sampler // Keeps only a random sample of the rows; nil keeps them all
+
+	Stream RowStream // Receives the rows as they are scanned instead of the result holding them
+}
+
+// RowStream receives a query's rows while they are scanned, so a caller can
+// write them out without the whole result in memory. Begin may be called
+// again when a query is rerun.
+type RowStream interface {
+	Begin(columns []string, columnTypes []string) error
+	Row(row []string) error
 }
 
 // QueryResult represents a query result set
 type QueryResult struct {
-	Columns []string
-	Rows    [][]string
+	Columns     []string
+	ColumnTypes []string // Database type name of each column (e.g. "VARCHAR", "INT4"), when reported by the driver
+	Rows        [][]string
+}
+
+// gormLogLevel maps the collector's log level to GORM's: SQL statements are
+// only traced at debug, and GORM's warnings (e.g. slow queries) are kept at info
+func gormLogLevel() logger.LogLevel {
+	switch logging.CurrentLevel() {
+	case logging.LevelDebug:
+		return logger.Info
+	case logging.LevelError:
+		return logger.Error
+	default:
+		return logger.Warn
+	}
 }
 
 // Connect establishes a connection to the database using GORM
 func Connect(config Config) (*gorm.DB, error) {
+	return ConnectContext(context.Background(), config)
+}
+
+// ConnectContext is like Connect but aborts the connection check when ctx is done
+func ConnectContext(ctx context.Context, config Config) (*gorm.DB, error) {
 	var db *gorm.DB
 	var err error
 
@@ -38,64 +135,180 @@ func Connect(config Config) (*gorm.DB, error) {
 		log.New(log.Writer(), "\r\n", log.LstdFlags),
 		logger.Config{
 			SlowThreshold:             time.Second,
-			LogLevel:                  logger.Warn,
+			LogLevel:                  gormLogLevel(),
 			IgnoreRecordNotFoundError: true,
 			Colorful:                  false,
 		},
 	)
 
-	// Configure database connection based on type
-	switch config.Type {
-	case "mysql":
-		dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=Local",
-			config.User, config.Password, config.Host, config.Port, config.Database)
-		db, err = gorm.Open(mysql.Open(dsn), &gorm.Config{
-			Logger: gormLogger,
-		})
-
-	case "postgres":
-		sslMode := config.SSLMode
-		if sslMode == "" {
-			sslMode = "disable" // Default SSL mode
-		}
-		dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s TimeZone=UTC",
-			config.Host, config.User, config.Password, config.Database, config.Port, sslMode)
-		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{
-			Logger: gormLogger,
-		})
+	if err := ValidateDSNParams(config.DSNParams); err != nil {
+		return nil, err
+	}
 
-	default:
-		return nil, fmt.Errorf("unsupported database type: %s (supported types: mysql, postgres)", config.Type)
+	// Open an SSH tunnel first when the database is only reachable through a bastion
+	var tunnel *ssh.Client
+	var dial DialFunc
+	// A Unix socket is local, so neither the bastion nor the proxy applies
+	if config.SSH != nil && config.Socket == "" {
+		tunnel, err = openSSHTunnel(ctx, *config.SSH, config.ConnectTimeout)
+		if err != nil {
+			return nil, err
+		}
+		dial = tunnelDialer(tunnel)
+	} else if config.Socket == "" {
+		proxyURL, err := proxyFor(config)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL != nil {
+			dial = proxyDialer(proxyURL)
+		}
 	}
 
+	// Build the driver-specific dialector; types whose driver was left out
+	// of this build are refused here with a clear error
+	var dialector gorm.Dialector
+	drv, err := lookupDriver(config.Type)
+	if err == nil {
+		dialector, err = drv.open(config, dial)
+	}
 	if err != nil {
+		if tunnel != nil {
+			tunnel.Close()
+		}
+		return nil, err
+	}
+
+	db, err = gorm.Open(dialector, &gorm.Config{
+		Logger: gormLogger,
+	})
+
+	if err != nil {
+		if tunnel != nil {
+			tunnel.Close()
+		}
+		if ctx.Err() != nil {
+			return nil, connectStopped(ctx, err)
+		}
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w after %v: %v", ErrConnectTimeout, config.ConnectTimeout, err)
+		}
+		if isTLSError(err) {
+			return nil, tlsHandshakeError(config, err)
+		}
 		return nil, fmt.Errorf("error opening database connection: %w", err)
 	}
 
 	// Configure connection pool
 	sqlDB, err := db.DB()
 	if err != nil {
+		if tunnel != nil {
+			tunnel.Close()
+		}
 		return nil, fmt.Errorf("error accessing underlying SQL DB: %w", err)
 	}
+	if tunnel != nil {
+		tunnels.Store(sqlDB, tunnel)
+	}
 
 	// Set connection pool parameters
 	sqlDB.SetMaxOpenConns(10)
 	sqlDB.SetMaxIdleConns(5)
 	sqlDB.SetConnMaxLifetime(time.Minute * 3)
 
-	// Check if connection is working
-	if err := sqlDB.Ping(); err != nil {
+	// Check if connection is working, bounded by the connect timeout when set
+	pingCtx := ctx
+	if config.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		pingCtx, cancel = context.WithTimeout(pingCtx, config.ConnectTimeout)
+		defer cancel()
+	}
+	if err := sqlDB.PingContext(pingCtx); err != nil {
+		sqlDB.Close()
+		closeTunnel(sqlDB)
+		if ctx.Err() != nil {
+			return nil, connectStopped(ctx, err)
+		}
+		if isTimeout(err) {
+			return nil, fmt.Errorf("%w after %v: %v", ErrConnectTimeout, config.ConnectTimeout, err)
+		}
+		if isTLSError(err) {
+			return nil, tlsHandshakeError(config, err)
+		}
 		return nil, fmt.Errorf("error pinging database: %w", err)
 	}
 
+	// Session settings only hold on the connection they ran on, so the pool is
+	// pinned to that single connection (statements run one at a time anyway)
+	if len(config.InitSQL) > 0 {
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+		for i, statement := range config.InitSQL {
+			if err := db.WithContext(ctx).Exec(statement).Error; err != nil {
+				sqlDB.Close()
+				closeTunnel(sqlDB)
+				return nil, fmt.Errorf("%w: statement %d (%s): %v", ErrInitSQL, i+1, strings.TrimSpace(statement), err)
+			}
+		}
+	}
+
+	registerQueryKiller(sqlDB, config, drv)
 	return db, nil
 }
 
-// ExecuteRawQuery executes the given SQL query and returns the result
-func ExecuteRawQuery(db *gorm.DB, query string) (*QueryResult, error) {
+// connectStopped describes err from a connection attempt cut short by ctx
+// ending (a run deadline or a signal), which isn't the connect timeout
+func connectStopped(ctx context.Context, err error) error {
+	return fmt.Errorf("connecting stopped: %w: %v", context.Cause(ctx), err)
+}
+
+// isTimeout reports whether err was caused by a deadline or network timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// WithQueryTimeout bounds ctx by a per-query timeout. ExecuteRawQuery reports
+// reaching this deadline as ErrQueryTimeout; a deadline of ctx itself (such as
+// max_runtime) is reported as that deadline instead.
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeoutCause(ctx, timeout, ErrQueryTimeout)
+}
+
+// deadlineError describes err from a query whose ctx passed its deadline:
+// ErrQueryTimeout when it was the WithQueryTimeout deadline, otherwise the
+// deadline of the caller's context
+func deadlineError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrQueryTimeout) {
+		return fmt.Errorf("query stopped: %w: %v", cause, err)
+	}
+	return fmt.Errorf("%w: %v", ErrQueryTimeout, err)
+}
+
+// ExecuteRawQuery executes the given SQL query and returns the result.
+// The query is cancelled when ctx is done; the deadline of WithQueryTimeout
+// produces ErrQueryTimeout. For drivers that support it (MySQL) a timed-out
+// query is also killed on the server, which the error reports.
+func ExecuteRawQuery(ctx context.Context, db *gorm.DB, query string, options QueryOptions) (*QueryResult, error) {
+	if sqlDB, err := db.DB(); err == nil {
+		if killer, ok := queryKillers.Load(sqlDB); ok {
+			return executeKillable(ctx, db, killer.(*queryKiller), query, options)
+		}
+	}
+	return executeRawQuery(ctx, db, query, options)
+}
+
+// executeRawQuery runs query on db and converts its rows
+func executeRawQuery(ctx context.Context, db *gorm.DB, query string, options QueryOptions) (*QueryResult, error) {
 	// Execute raw query
-	rows, err := db.Raw(query).Rows()
+	rows, err := db.WithContext(ctx).Raw(query).Rows()
 	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, deadlineError(ctx, err)
+		}
 		return nil, fmt.Errorf("error executing query: %w", err)
 	}
 	defer rows.Close()
@@ -106,10 +319,27 @@ func ExecuteRawQuery(db *gorm.DB, query string) (*QueryResult, error) {
 		return nil, fmt.Errorf("error getting column names: %w", err)
 	}
 
+	// Get column types; drivers that can't report them leave the names empty
+	columnTypes := make([]string, len(columns))
+	if types, err := rows.ColumnTypes(); err == nil {
+		for i, columnType := range types {
+			if i < len(columnTypes) {
+				columnTypes[i] = columnType.DatabaseTypeName()
+			}
+		}
+	}
+
 	// Create result set
 	result := &QueryResult{
-		Columns: columns,
-		Rows:    [][]string{},
+		Columns:     columns,
+		ColumnTypes: columnTypes,
+		Rows:        [][]string{},
+	}
+
+	if options.Stream != nil {
+		if err := options.Stream.Begin(columns, columnTypes); err != nil {
+			return nil, err
+		}
 	}
 
 	// Prepare containers for row data
@@ -117,8 +347,19 @@ func ExecuteRawQuery(db *gorm.DB, query string) (*QueryResult, error) {
 	values := make([]interface{}, columnCount)
 	valuePtrs := make([]interface{}, columnCount)
 
-	// Fetch rows
+	// Fetch rows, deciding before scanning a row whether the sample keeps it
+	sampler := options.Sampler
+	if sampler != nil {
+		sampler.begin()
+	}
 	for rows.Next() {
+		slot := len(result.Rows)
+		if sampler != nil {
+			if slot = sampler.slot(slot); slot < 0 {
+				continue
+			}
+		}
+
 		// Initialize with new values for each row
 		for i := range columns {
 			valuePtrs[i] = &values[i]
@@ -133,36 +374,76 @@ func ExecuteRawQuery(db *gorm.DB, query string) (*QueryResult, error) {
 		rowStrings := make([]string, columnCount)
 		for i, val := range values {
 			if val == nil {
-				rowStrings[i] = "NULL"
+				rowStrings[i] = options.NullValue
 			} else {
-				// Handle different types of values
-				switch v := val.(type) {
-				case []byte:
-					rowStrings[i] = string(v)
-				default:
-					rowStrings[i] = fmt.Sprintf("%v", v)
-				}
+				rowStrings[i] = formatValue(val, columnTypes[i], options)
 			}
 		}
 
-		result.Rows = append(result.Rows, rowStrings)
+		if options.Stream != nil {
+			if err := options.Stream.Row(rowStrings); err != nil {
+				return nil, err
+			}
+		} else if slot < len(result.Rows) {
+			result.Rows[slot] = rowStrings
+		} else {
+			result.Rows = append(result.Rows, rowStrings)
+		}
 	}
 
 	if err = rows.Err(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, deadlineError(ctx, err)
+		}
 		return nil, fmt.Errorf("error reading rows: %w", err)
 	}
+	if sampler != nil {
+		sampler.finish(result.Rows)
+	}
 
 	return result, nil
 }
 
-// Close safely closes the database connection
+// Close safely closes the database connection and any SSH tunnel behind it
 func Close(db *gorm.DB) error {
 	if db != nil {
 		sqlDB, err := db.DB()
 		if err != nil {
 			return fmt.Errorf("error accessing SQL DB: %w", err)
 		}
-		return sqlDB.Close()
+		closeErr := sqlDB.Close()
+		forgetQueryKiller(sqlDB)
+		if err := closeTunnel(sqlDB); err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("error closing SSH tunnel: %w", err)
+		}
+		return closeErr
 	}
 	return nil
 }
+
+// IsAuthError reports whether err is the server rejecting the credentials
+func IsAuthError(err error) bool {
+	drv, code, ok := serverError(err)
+	return ok && slices.Contains(drv.authCodes, code)
+}
+
+// IsConnectionDropped reports whether err means the connection was lost
+// (e.g. the server recycled it mid-query) rather than the query failing.
+// Errors returned by the server itself, and cancellations, are not drops.
+func IsConnectionDropped(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrQueryTimeout) {
+		return false
+	}
+	if _, _, ok := serverError(err); ok {
+		return false
+	}
+	if isDriverDropped(err) {
+		return true
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var netErr *net.OpError
+	return errors.As(err, &netErr)
+}
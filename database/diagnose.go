@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Connection failure causes reported by Diagnose
+const (
+	CauseDNS             = "dns"              // The host name does not resolve
+	CauseRefused         = "tcp_refused"      // Nothing listens on the port
+	CauseUnreachable     = "tcp_unreachable"  // The port can't be reached (timeout, no route)
+	CauseTLS             = "tls"              // TLS negotiation failed
+	CauseAuth            = "auth"             // The server rejected the credentials
+	CauseUnknownDatabase = "unknown_database" // The server has no such database
+	CauseUnknown         = "unknown"
+)
+
+// diagnoseTimeout bounds each staged check when no connect timeout is set
+const diagnoseTimeout = 5 * time.Second
+
+// Diagnosis is the classified cause of a connection failure
+type Diagnosis struct {
+	Cause  string // One of the Cause constants
+	Detail string // Human-readable explanation
+}
+
+// DiagnosedError wraps a connection error with its diagnosis
+type DiagnosedError struct {
+	Diagnosis Diagnosis
+	Err       error
+}
+
+// Error implements the error interface
+func (e *DiagnosedError) Error() string {
+	return fmt.Sprintf("%v (diagnosis: %s: %s)", e.Err, e.Diagnosis.Cause, e.Diagnosis.Detail)
+}
+
+// Unwrap exposes the underlying error to errors.Is/As
+func (e *DiagnosedError) Unwrap() error {
+	return e.Err
+}
+
+// Diagnose classifies why connecting with config failed with err. The error
+// itself is inspected first; when it is inconclusive (e.g. a timeout) the
+// host is resolved and its port dialed to narrow the cause down. Those probes
+// are skipped when connections go through an SSH tunnel or proxy, since the
+// host is then resolved and reached from elsewhere.
+func Diagnose(ctx context.Context, config Config, err error) Diagnosis {
+	address := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	if config.Socket != "" {
+		address = config.Socket
+	}
+	if diagnosis, ok := classifyConnectError(err, config, address); ok {
+		return diagnosis
+	}
+
+	// A socket has no name to resolve; only check that something accepts on it
+	if config.Socket != "" {
+		file, socketErr := resolveSocket(config)
+		if errors.Is(socketErr, os.ErrNotExist) {
+			return Diagnosis{CauseRefused, "the socket does not exist (is the server running?)"}
+		}
+		if socketErr != nil {
+			return Diagnosis{CauseUnknown, "not probed: the socket path is not usable"}
+		}
+		conn, dialErr := (&net.Dialer{}).DialContext(ctx, "unix", file)
+		if dialErr != nil {
+			if diagnosis, ok := classifyConnectError(dialErr, config, file); ok {
+				return diagnosis
+			}
+			return Diagnosis{CauseUnreachable, fmt.Sprintf("cannot connect to socket %s: %v", file, dialErr)}
+		}
+		conn.Close()
+		return Diagnosis{CauseUnknown, fmt.Sprintf("socket %s accepts connections; the failure is in the database handshake", file)}
+	}
+
+	if config.SSH != nil {
+		return Diagnosis{CauseUnknown, "not probed: connections go through the SSH tunnel"}
+	}
+	if config.Proxy != "" {
+		return Diagnosis{CauseUnknown, "not probed: connections go through a proxy"}
+	}
+
+	timeout := config.ConnectTimeout
+	if timeout <= 0 {
+		timeout = diagnoseTimeout
+	}
+
+	// Stage 1: does the name resolve?
+	resolveCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if _, resolveErr := net.DefaultResolver.LookupHost(resolveCtx, config.Host); resolveErr != nil {
+		return Diagnosis{CauseDNS, fmt.Sprintf("host %s does not resolve: %v", config.Host, resolveErr)}
+	}
+
+	// Stage 2: does anything accept connections on the port?
+	dialCtx, cancelDial := context.WithTimeout(ctx, timeout)
+	defer cancelDial()
+	conn, dialErr := (&net.Dialer{}).DialContext(dialCtx, "tcp", address)
+	if dialErr != nil {
+		if diagnosis, ok := classifyConnectError(dialErr, config, address); ok {
+			return diagnosis
+		}
+		return Diagnosis{CauseUnreachable, fmt.Sprintf("cannot reach %s: %v", address, dialErr)}
+	}
+	conn.Close()
+	return Diagnosis{CauseUnknown, fmt.Sprintf("%s accepts TCP connections; the failure is in the database handshake", address)}
+}
+
+// classifyConnectError recognizes causes that err alone identifies
+func classifyConnectError(err error, config Config, address string) (Diagnosis, bool) {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && !dnsErr.IsTimeout:
+		return Diagnosis{CauseDNS, fmt.Sprintf("host %s does not resolve: %v", dnsErr.Name, dnsErr.Err)}, true
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return Diagnosis{CauseRefused, fmt.Sprintf("nothing is listening on %s", address)}, true
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return Diagnosis{CauseUnreachable, fmt.Sprintf("no route to %s", address)}, true
+	case IsAuthError(err):
+		return Diagnosis{CauseAuth, fmt.Sprintf("the server rejected the credentials of user %s", config.User)}, true
+	case IsUnknownDatabase(err):
+		return Diagnosis{CauseUnknownDatabase, fmt.Sprintf("database %s does not exist on the server", config.Database)}, true
+	case errors.Is(err, ErrTLSHandshake), isTLSError(err):
+		return Diagnosis{CauseTLS, "TLS negotiation with the server failed"}, true
+	}
+	return Diagnosis{}, false
+}
+
+// IsUnknownDatabase reports whether err says the requested database doesn't exist
+func IsUnknownDatabase(err error) bool {
+	drv, code, ok := serverError(err)
+	return ok && slices.Contains(drv.unknownDatabaseCodes, code)
+}
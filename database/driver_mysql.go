@@ -0,0 +1,168 @@
+//go:build !nomysql
+
+package database
+
+import (
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+func init() {
+	registerDriver("mysql", dbDriver{
+		open: openMySQL,
+		serverCode: func(err error) (string, bool) {
+			var mysqlErr *mysqldriver.MySQLError
+			if errors.As(err, &mysqlErr) {
+				return strconv.Itoa(int(mysqlErr.Number)), true
+			}
+			return "", false
+		},
+		authCodes:            []string{"1045"}, // ER_ACCESS_DENIED_ERROR
+		unknownDatabaseCodes: []string{"1049"}, // ER_BAD_DB_ERROR
+		dropped: func(err error) bool {
+			return errors.Is(err, mysqldriver.ErrInvalidConn)
+		},
+		connectionID: func(conn *gorm.DB) (int64, error) {
+			var id int64
+			err := conn.Raw("SELECT CONNECTION_ID()").Scan(&id).Error
+			return id, err
+		},
+		killQuery: func(db *gorm.DB, id int64) error {
+			// KILL QUERY ends the statement but leaves the connection open
+			return db.Exec(fmt.Sprintf("KILL QUERY %d", id)).Error
+		},
+	})
+}
+
+// openMySQL builds the MySQL DSN for config and its dialector
+func openMySQL(config Config, dial DialFunc) (gorm.Dialector, error) {
+	address := fmt.Sprintf("tcp(%s:%d)", config.Host, config.Port)
+	if config.Socket != "" {
+		if err := checkSocket(config.Socket); err != nil {
+			return nil, err
+		}
+		address = fmt.Sprintf("unix(%s)", config.Socket)
+	}
+	dsn := fmt.Sprintf("%s:%s@%s/%s?%s",
+		config.User, config.Password, address, config.Database, mysqlParams(config))
+	if config.ConnectTimeout > 0 {
+		dsn += fmt.Sprintf("&timeout=%s", config.ConnectTimeout)
+	}
+	if config.CABundle != "" || config.SSLCert != "" {
+		name, err := registerMySQLTLS(config)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+		dsn += "&tls=" + name
+	}
+	dsn += mysqlExtraParams(config.DSNParams)
+	return mysqlDialector(dsn, dial)
+}
+
+// mysqlParams returns the DSN query parameters for charset, collation,
+// parseTime and loc, falling back to utf8mb4, parseTime=True and loc=Local
+func mysqlParams(config Config) string {
+	charset := config.Charset
+	if charset == "" {
+		charset = "utf8mb4"
+	}
+	parseTime := true
+	if config.ParseTime != nil {
+		parseTime = *config.ParseTime
+	}
+	loc := config.Loc
+	if loc == "" {
+		loc = "Local"
+	}
+
+	params := "charset=" + url.QueryEscape(charset)
+	if config.Collation != "" {
+		params += "&collation=" + url.QueryEscape(config.Collation)
+	}
+	if parseTime {
+		params += "&parseTime=True"
+	} else {
+		params += "&parseTime=False"
+	}
+	params += "&loc=" + url.QueryEscape(loc)
+	return params
+}
+
+// mysqlDialector builds the GORM dialector for a MySQL DSN, routing
+// connections through dial when a custom dialer is required
+func mysqlDialector(dsn string, dial DialFunc) (gorm.Dialector, error) {
+	if dial == nil {
+		return mysql.Open(dsn), nil
+	}
+
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing MySQL DSN: %w", err)
+	}
+	cfg.DialFunc = dial
+	connector, err := mysqldriver.NewConnector(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating MySQL connector: %w", err)
+	}
+	return mysql.New(mysql.Config{Conn: sql.OpenDB(connector)}), nil
+}
+
+// mysqlTLSNames maps each combination of CA bundle and client certificate
+// to the name its TLS config was registered under with the MySQL driver, so
+// it is registered only once
+var (
+	mysqlTLSMu    sync.Mutex
+	mysqlTLSNames = make(map[mysqlTLSFiles]string)
+)
+
+// mysqlTLSFiles are the files a MySQL TLS config is built from
+type mysqlTLSFiles struct {
+	rootCert string // CA certificates; "" trusts the system roots
+	cert     string
+	key      string
+}
+
+// registerMySQLTLS registers a TLS config with the MySQL driver that trusts
+// DB_SSL_ROOT_CERT, else the CA bundle, and presents the client certificate
+// when one is set, and returns the name to use as the DSN's tls value
+func registerMySQLTLS(config Config) (string, error) {
+	files := mysqlTLSFiles{rootCert: config.rootCert(), cert: config.SSLCert, key: config.SSLKey}
+
+	mysqlTLSMu.Lock()
+	defer mysqlTLSMu.Unlock()
+
+	if name, ok := mysqlTLSNames[files]; ok {
+		return name, nil
+	}
+	// The driver fills in ServerName from the host when connecting
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if files.rootCert != "" {
+		pool, err := LoadCABundle(files.rootCert)
+		if err != nil {
+			return "", err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if files.cert != "" || files.key != "" {
+		cert, err := LoadClientCertificate(files.cert, files.key)
+		if err != nil {
+			return "", err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	name := fmt.Sprintf("datacollector-tls-%d", len(mysqlTLSNames)+1)
+	if err := mysqldriver.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", fmt.Errorf("error registering TLS configuration with the MySQL driver: %w", err)
+	}
+	mysqlTLSNames[files] = name
+	return name, nil
+}
@@ -0,0 +1,90 @@
+//go:build !nopostgres
+
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func init() {
+	registerDriver("postgres", dbDriver{
+		open: openPostgres,
+		serverCode: func(err error) (string, bool) {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) {
+				return pgErr.Code, true
+			}
+			return "", false
+		},
+		authCodes:            []string{"28P01", "28000"}, // invalid_password, invalid_authorization_specification
+		unknownDatabaseCodes: []string{"3D000"},          // invalid_catalog_name
+	})
+}
+
+// openPostgres builds the PostgreSQL DSN for config and its dialector
+func openPostgres(config Config, dial DialFunc) (gorm.Dialector, error) {
+	sslMode := config.SSLMode
+	if sslMode == "" && config.CABundle != "" {
+		sslMode = "verify-full" // A CA bundle is only useful when it's checked
+	}
+	if sslMode == "" && config.SSLCert != "" {
+		sslMode = "require" // A client certificate is only sent over TLS
+	}
+	if sslMode == "" {
+		sslMode = "disable" // Default SSL mode
+	}
+	host, port := config.Host, config.Port
+	if config.Socket != "" {
+		// libpq semantics: a host starting with '/' is the socket's directory
+		dir, socketPort, _, err := postgresSocket(config.Socket, config.Port)
+		if err != nil {
+			return nil, err
+		}
+		host, port = pgValue(dir), socketPort
+	}
+	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s TimeZone=UTC",
+		host, config.User, config.Password, config.Database, port, sslMode)
+	if err := validateTLSFiles(config); err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+	if rootCert := config.rootCert(); rootCert != "" {
+		dsn += " sslrootcert=" + pgValue(rootCert)
+	}
+	if config.SSLCert != "" {
+		dsn += " sslcert=" + pgValue(config.SSLCert) + " sslkey=" + pgValue(config.SSLKey)
+	}
+	if config.ConnectTimeout > 0 {
+		// connect_timeout is expressed in whole seconds; round up so short timeouts aren't disabled
+		seconds := int((config.ConnectTimeout + time.Second - 1) / time.Second)
+		dsn += fmt.Sprintf(" connect_timeout=%d", seconds)
+	}
+	dsn += postgresExtraParams(config.DSNParams)
+	return postgresDialector(dsn, dial)
+}
+
+// postgresDialector builds the GORM dialector for a PostgreSQL DSN, routing
+// connections through dial when a custom dialer is required
+func postgresDialector(dsn string, dial DialFunc) (gorm.Dialector, error) {
+	if dial == nil {
+		return postgres.Open(dsn), nil
+	}
+
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing PostgreSQL DSN: %w", err)
+	}
+	cfg.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	}
+	return postgres.New(postgres.Config{Conn: stdlib.OpenDB(*cfg)}), nil
+}
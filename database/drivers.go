@@ -0,0 +1,106 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ErrDriverUnavailable is returned for a known database type whose driver was
+// left out of this build
+var ErrDriverUnavailable = errors.New("not available in this build")
+
+// dbDriver connects to one type of database. Each driver registers itself from
+// its own file so optional drivers can be left out with build tags (e.g.
+// -tags nomysql) without touching the rest of the package.
+type dbDriver struct {
+	// open builds the GORM dialector for config, routing connections through
+	// dial when it is non-nil
+	open func(config Config, dial DialFunc) (gorm.Dialector, error)
+
+	// serverCode returns the code of an error sent by the database server
+	// (as text, e.g. "1045" or "28P01"); ok is false for any other error
+	serverCode func(err error) (code string, ok bool)
+
+	authCodes            []string // Server codes for rejected credentials
+	unknownDatabaseCodes []string // Server codes for a database that doesn't exist
+
+	// dropped reports driver-specific errors meaning the connection was lost
+	dropped func(err error) bool
+
+	// connectionID returns the server's id for the connection conn is pinned
+	// to, and killQuery stops the statement running on connection id. Drivers
+	// that leave them nil don't kill timed-out queries on the server.
+	connectionID func(conn *gorm.DB) (int64, error)
+	killQuery    func(db *gorm.DB, id int64) error
+}
+
+// drivers holds the drivers compiled into this build, keyed by database type
+var drivers = make(map[string]dbDriver)
+
+// registerDriver makes a driver available under a database type; called
+// from the init function of each driver's file
+func registerDriver(dbType string, drv dbDriver) {
+	drivers[dbType] = drv
+}
+
+// AvailableTypes returns the database types whose driver is compiled into
+// this build, sorted
+func AvailableTypes() []string {
+	types := make([]string, 0, len(drivers))
+	for dbType := range drivers {
+		types = append(types, dbType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// CheckDriver reports an error when connections to dbType can't be made by
+// this build, either because its driver was left out or the type is unknown
+func CheckDriver(dbType string) error {
+	_, err := lookupDriver(dbType)
+	return err
+}
+
+// lookupDriver returns the registered driver for dbType
+func lookupDriver(dbType string) (dbDriver, error) {
+	if drv, ok := drivers[dbType]; ok {
+		return drv, nil
+	}
+	available := strings.Join(AvailableTypes(), ", ")
+	if available == "" {
+		available = "none"
+	}
+	if _, known := defaultPorts[dbType]; known {
+		return dbDriver{}, fmt.Errorf("driver %s %w (available types: %s)", dbType, ErrDriverUnavailable, available)
+	}
+	return dbDriver{}, fmt.Errorf("unsupported database type: %s (supported types: %s)", dbType, available)
+}
+
+// serverError finds the registered driver that produced err as a server
+// error and returns it with the error's code
+func serverError(err error) (dbDriver, string, bool) {
+	for _, drv := range drivers {
+		if drv.serverCode == nil {
+			continue
+		}
+		if code, ok := drv.serverCode(err); ok {
+			return drv, code, true
+		}
+	}
+	return dbDriver{}, "", false
+}
+
+// isDriverDropped reports whether any registered driver recognizes err as a
+// lost connection
+func isDriverDropped(err error) bool {
+	for _, drv := range drivers {
+		if drv.dropped != nil && drv.dropped(err) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ValidateDSNParams checks that every extra DSN parameter name is made of
+// letters, digits, '_', '.' and '-' only, so it can't break out of its
+// key=value pair (values are escaped for the driver when appended)
+func ValidateDSNParams(params map[string]string) error {
+	for key := range params {
+		if key == "" {
+			return fmt.Errorf("invalid dsn_params key: empty name")
+		}
+		for _, r := range key {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-') {
+				return fmt.Errorf("invalid dsn_params key %q: %q is not allowed", key, r)
+			}
+		}
+	}
+	return nil
+}
+
+// sortedParamKeys returns the parameter names in a stable order
+func sortedParamKeys(params map[string]string) []string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mysqlExtraParams renders params as "&key=value" query parameters
+func mysqlExtraParams(params map[string]string) string {
+	var b strings.Builder
+	for _, key := range sortedParamKeys(params) {
+		b.WriteString("&" + key + "=" + url.QueryEscape(params[key]))
+	}
+	return b.String()
+}
+
+// postgresExtraParams renders params as " key='value'" keyword/value pairs
+func postgresExtraParams(params map[string]string) string {
+	var b strings.Builder
+	for _, key := range sortedParamKeys(params) {
+		b.WriteString(" " + key + "=" + pgValue(params[key]))
+	}
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// ExplainQuery runs the driver-appropriate EXPLAIN for query and renders the
+// plan as plain text. The plan is never executed (no ANALYZE), so the query
+// itself does not run.
+func ExplainQuery(ctx context.Context, db *gorm.DB, dbType, query string) (string, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+
+	var statement string
+	switch dbType {
+	case "mysql", "postgres":
+		// Both accept a bare EXPLAIN prefix; the output shapes differ
+		statement = "EXPLAIN " + query
+	default:
+		return "", fmt.Errorf("EXPLAIN is not supported for database type %q", dbType)
+	}
+
+	result, err := ExecuteRawQuery(ctx, db, statement, QueryOptions{NullValue: DefaultNullValue})
+	if err != nil {
+		return "", err
+	}
+	return formatPlan(result), nil
+}
+
+// formatPlan turns an EXPLAIN result into text. PostgreSQL returns a single
+// "QUERY PLAN" column with one line per row, which is kept as is; tabular
+// plans (MySQL) are written as a tab-separated header and rows.
+func formatPlan(result *QueryResult) string {
+	var b strings.Builder
+	if len(result.Columns) == 1 {
+		for _, row := range result.Rows {
+			b.WriteString(row[0])
+			b.WriteByte('\n')
+		}
+		return b.String()
+	}
+
+	b.WriteString(strings.Join(result.Columns, "\t"))
+	b.WriteByte('\n')
+	for _, row := range result.Rows {
+		b.WriteString(strings.Join(row, "\t"))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"datacollector/logging"
+)
+
+// killTimeout bounds connecting and killing a timed-out query when no
+// connect timeout is configured
+const killTimeout = 10 * time.Second
+
+// queryKiller holds what is needed to open a second connection to the server
+// of a pool and kill a query running on one of its connections
+type queryKiller struct {
+	config Config
+	drv    dbDriver
+}
+
+// queryKillers tracks the killer of each open connection pool whose driver
+// can kill queries server-side, so Close can forget it
+var queryKillers sync.Map // map[*sql.DB]*queryKiller
+
+// registerQueryKiller records how to kill queries on sqlDB's connections, for
+// drivers that support it
+func registerQueryKiller(sqlDB *sql.DB, config Config, drv dbDriver) {
+	if drv.connectionID == nil || drv.killQuery == nil {
+		return
+	}
+	queryKillers.Store(sqlDB, &queryKiller{config: config, drv: drv})
+}
+
+// forgetQueryKiller drops the killer of a pool being closed
+func forgetQueryKiller(sqlDB *sql.DB) {
+	queryKillers.Delete(sqlDB)
+}
+
+// executeKillable runs the query on a single connection whose server-side id
+// is read first. When the query hits its query timeout (WithQueryTimeout),
+// the abandoned statement is killed on the server from a new connection,
+// since cancelling only stops the client from waiting and the server could
+// otherwise keep running it and holding its locks.
+func executeKillable(ctx context.Context, db *gorm.DB, killer *queryKiller, query string, options QueryOptions) (*QueryResult, error) {
+	var result *QueryResult
+	err := db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		id, err := killer.drv.connectionID(conn)
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return deadlineError(ctx, err)
+			}
+			return fmt.Errorf("error reading connection id: %w", err)
+		}
+		result, err = executeRawQuery(ctx, conn, query, options)
+		if errors.Is(err, ErrQueryTimeout) {
+			if killErr := killer.kill(id); killErr != nil {
+				logging.Warnf("Warning: query on %s timed out and could not be killed on server connection %d: %v", killer.config.Host, id, killErr)
+				return fmt.Errorf("%w (killing it on server connection %d failed: %v)", err, id, killErr)
+			}
+			logging.Warnf("Killed timed-out query on %s (server connection %d)", killer.config.Host, id)
+			return fmt.Errorf("%w (killed on server connection %d)", err, id)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// kill opens a separate connection (the pool may be pinned to the one
+// running the query) and kills the statement running on connection id
+func (k *queryKiller) kill(id int64) error {
+	timeout := k.config.ConnectTimeout
+	if timeout <= 0 {
+		timeout = killTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	config := k.config
+	config.InitSQL = nil // Session setup isn't needed to issue the kill
+	db, err := ConnectContext(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer Close(db)
+	return k.drv.killQuery(db.WithContext(ctx), id)
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyFor returns the HTTP CONNECT proxy to reach host:port through, or nil
+// when config.Proxy is unset or NO_PROXY exempts the host
+func proxyFor(config Config) (*url.URL, error) {
+	if config.Proxy == "" {
+		return nil, nil
+	}
+	proxyConfig := httpproxy.Config{
+		HTTPSProxy: config.Proxy,
+		NoProxy:    firstEnv("NO_PROXY", "no_proxy"),
+	}
+	target := &url.URL{Scheme: "https", Host: net.JoinHostPort(config.Host, strconv.Itoa(config.Port))}
+	proxyURL, err := proxyConfig.ProxyFunc()(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", config.Proxy, err)
+	}
+	return proxyURL, nil
+}
+
+// firstEnv returns the first non-empty environment variable among names
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// proxyDialer returns a DialFunc that tunnels each connection through the
+// HTTP CONNECT proxy at proxyURL ("http://" or "https://", with optional
+// user:password for basic authentication)
+func proxyDialer(proxyURL *url.URL) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		proxyAddr := proxyURL.Host
+		if proxyURL.Port() == "" {
+			port := "80"
+			if proxyURL.Scheme == "https" {
+				port = "443"
+			}
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+		}
+
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to proxy %s: %w", proxyAddr, err)
+		}
+		if proxyURL.Scheme == "https" {
+			tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("TLS handshake with proxy %s failed: %w", proxyAddr, err)
+			}
+			conn = tlsConn
+		}
+
+		// Abort the CONNECT exchange if ctx ends first
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		request := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+		if user := proxyURL.User; user != nil {
+			password, _ := user.Password()
+			credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+			request.Header.Set("Proxy-Authorization", "Basic "+credentials)
+		}
+		if err := request.Write(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error sending CONNECT to proxy %s: %w", proxyAddr, err)
+		}
+
+		reader := bufio.NewReader(conn)
+		response, err := http.ReadResponse(reader, request)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error reading CONNECT response from proxy %s: %w", proxyAddr, err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s", proxyAddr, addr, response.Status)
+		}
+		if ctx.Err() != nil {
+			conn.Close()
+			return nil, ctx.Err()
+		}
+
+		// The database may speak first; keep whatever the reader already buffered
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+}
+
+// bufferedConn reads through a bufio.Reader that may hold bytes already
+// received on the underlying connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
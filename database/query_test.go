@@ -0,0 +1,70 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	_ "modernc.org/sqlite"
+)
+
+// endless never finishes on its own, so only its context can stop it
+const endless = "WITH RECURSIVE n(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM n) SELECT count(*) FROM n"
+
+// sqliteDB opens an in-memory SQLite database behind GORM; the dialector only
+// matters for building statements, which raw queries don't use
+func sqliteDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	sqlDB, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestExecuteRawQueryRunDeadline(t *testing.T) {
+	db := sqliteDB(t)
+
+	// A run deadline (max_runtime) with no query_timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := ExecuteRawQuery(ctx, db, endless, QueryOptions{})
+	if err == nil {
+		t.Fatal("ExecuteRawQuery() finished an endless query")
+	}
+	if errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("ExecuteRawQuery() error = %v, want the run deadline, not ErrQueryTimeout", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ExecuteRawQuery() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The run deadline passing first isn't the query timeout either
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	queryCtx, cancelQuery := WithQueryTimeout(ctx, time.Minute)
+	defer cancelQuery()
+	if _, err := ExecuteRawQuery(queryCtx, db, endless, QueryOptions{}); errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("ExecuteRawQuery() error = %v, want the run deadline, not ErrQueryTimeout", err)
+	}
+}
+
+func TestExecuteRawQueryTimeout(t *testing.T) {
+	db := sqliteDB(t)
+
+	queryCtx, cancel := WithQueryTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := ExecuteRawQuery(queryCtx, db, endless, QueryOptions{}); !errors.Is(err, ErrQueryTimeout) {
+		t.Errorf("ExecuteRawQuery() error = %v, want ErrQueryTimeout", err)
+	}
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"sort"
+)
+
+// Sampler keeps a random sample of the rows a query returns, deciding while
+// they are scanned so rows left out are never converted or held in memory.
+// It keeps either each row with a fixed probability or, by reservoir
+// sampling, a uniform sample of exactly N rows (all of them when fewer).
+type Sampler struct {
+	rate float64
+	n    int
+	rng  *rand.Rand
+
+	scanned int   // Rows seen by the current statement
+	total   int   // Rows seen by the statements read to the end
+	order   []int // Scan position of each kept row, for reservoir samples
+}
+
+// NewSampler returns a sampler keeping each row with probability rate, or
+// exactly n rows when n is positive. The same seed and key (e.g. the
+// target) always select the same rows of the same result.
+func NewSampler(rate float64, n int, seed uint64, key string) *Sampler {
+	hash := fnv.New64a()
+	hash.Write([]byte(key))
+	return &Sampler{
+		rate: rate,
+		n:    n,
+		rng:  rand.New(rand.NewPCG(seed, hash.Sum64())),
+	}
+}
+
+// begin starts sampling a new statement's rows
+func (s *Sampler) begin() {
+	s.scanned = 0
+	s.order = s.order[:0]
+}
+
+// slot returns where the next scanned row goes among the kept rows: kept
+// appends it, a lower index replaces that row, and -1 skips it
+func (s *Sampler) slot(kept int) int {
+	position := s.scanned
+	s.scanned++
+	if s.n <= 0 {
+		if s.rng.Float64() < s.rate {
+			return kept
+		}
+		return -1
+	}
+
+	slot := position
+	if position >= s.n {
+		if slot = s.rng.IntN(position + 1); slot >= s.n {
+			return -1
+		}
+	}
+	if slot == len(s.order) {
+		s.order = append(s.order, position)
+	} else {
+		s.order[slot] = position
+	}
+	return slot
+}
+
+// finish completes a statement's sample, putting a reservoir sample back in
+// scan order, which replacements mix up
+func (s *Sampler) finish(rows [][]string) {
+	s.total += s.scanned
+	if s.n > 0 && len(rows) == len(s.order) {
+		sort.Sort(scanOrder{rows: rows, order: s.order})
+	}
+}
+
+// Scanned returns how many rows the queries returned before sampling, over
+// all the statements (e.g. pages) read to the end
+func (s *Sampler) Scanned() int {
+	return s.total
+}
+
+// scanOrder sorts sampled rows by their scan position
+type scanOrder struct {
+	rows  [][]string
+	order []int
+}
+
+func (o scanOrder) Len() int           { return len(o.rows) }
+func (o scanOrder) Less(i, j int) bool { return o.order[i] < o.order[j] }
+func (o scanOrder) Swap(i, j int) {
+	o.rows[i], o.rows[j] = o.rows[j], o.rows[i]
+	o.order[i], o.order[j] = o.order[j], o.order[i]
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pgSocketPrefix is the file name prefix of a PostgreSQL server socket; the
+// server's port follows it, e.g. ".s.PGSQL.5432"
+const pgSocketPrefix = ".s.PGSQL."
+
+// checkSocket verifies that path is a Unix domain socket, so a wrong path is
+// reported as such rather than as a failed connection
+func checkSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("socket %s: %w", path, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("socket %s: not a Unix domain socket", path)
+	}
+	return nil
+}
+
+// postgresSocket resolves a socket setting for PostgreSQL, which connects
+// through the directory holding the socket and picks the file by port. The
+// setting may name that directory or the socket file itself, in which case
+// the port comes from the file name. It returns the directory, the port and
+// the socket file.
+func postgresSocket(path string, port int) (string, int, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("socket %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		name := filepath.Base(path)
+		filePort, err := strconv.Atoi(strings.TrimPrefix(name, pgSocketPrefix))
+		if !strings.HasPrefix(name, pgSocketPrefix) || err != nil {
+			return "", 0, "", fmt.Errorf("socket %s: expected a directory or a %s<port> socket file", path, pgSocketPrefix)
+		}
+		if err := checkSocket(path); err != nil {
+			return "", 0, "", err
+		}
+		return filepath.Dir(path), filePort, path, nil
+	}
+	file := filepath.Join(path, pgSocketPrefix+strconv.Itoa(port))
+	if err := checkSocket(file); err != nil {
+		return "", 0, "", err
+	}
+	return path, port, file, nil
+}
+
+// resolveSocket returns the socket file a socket config connects through
+func resolveSocket(config Config) (string, error) {
+	if config.Type != "postgres" {
+		return config.Socket, checkSocket(config.Socket)
+	}
+	_, _, file, err := postgresSocket(config.Socket, config.Port)
+	return file, err
+}
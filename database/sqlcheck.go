@@ -0,0 +1,306 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrWriteQuery is returned when a query is rejected by the read-only check
+var ErrWriteQuery = errors.New("query is not read-only")
+
+// readOnlyKeywords are the leading keywords accepted in read-only mode
+var readOnlyKeywords = map[string]bool{
+	"SELECT":  true,
+	"SHOW":    true,
+	"EXPLAIN": true,
+	"WITH":    true,
+}
+
+// writeKeywords are the data-modifying statements a WITH query can end in
+// or, in PostgreSQL, hold in its common table expressions
+var writeKeywords = map[string]bool{
+	"INSERT": true,
+	"UPDATE": true,
+	"DELETE": true,
+	"MERGE":  true,
+}
+
+// CheckReadOnly returns ErrWriteQuery unless the query's first keyword, after
+// leading whitespace, comments and opening parentheses, is SELECT, SHOW,
+// EXPLAIN or WITH. A WITH query is also rejected when it holds INSERT,
+// UPDATE, DELETE or MERGE outside literals and comments (FOR UPDATE locking
+// aside), and EXPLAIN ANALYZE is rejected because it runs the statement.
+// Literals and comments are lexed both the MySQL and the PostgreSQL way, so a
+// keyword hidden from one dialect is still found.
+func CheckReadOnly(query string) error {
+	keyword := LeadingKeyword(query)
+	if keyword == "" {
+		return fmt.Errorf("%w: query is empty", ErrWriteQuery)
+	}
+	if !readOnlyKeywords[keyword] {
+		return fmt.Errorf("%w: leading keyword %s is not one of SELECT, SHOW, EXPLAIN, WITH (set allow_writes to permit it)", ErrWriteQuery, keyword)
+	}
+
+	for _, mysql := range []bool{true, false} {
+		words := codeWords(query, mysql)
+		for i, word := range words {
+			switch {
+			case keyword == "EXPLAIN" && (word == "ANALYZE" || word == "ANALYSE"):
+				return fmt.Errorf("%w: EXPLAIN %s runs the statement it explains (set allow_writes to permit it)", ErrWriteQuery, word)
+			case keyword == "WITH" && writeKeywords[word]:
+				if word == "UPDATE" && i > 0 && (words[i-1] == "FOR" || words[i-1] == "KEY") {
+					continue // FOR UPDATE and FOR NO KEY UPDATE only lock the rows read
+				}
+				return fmt.Errorf("%w: WITH query contains %s (set allow_writes to permit it)", ErrWriteQuery, word)
+			}
+		}
+	}
+	return nil
+}
+
+// codeWords returns the words of query in upper case, leaving out string
+// literals, quoted identifiers and comments as the MySQL (mysql set) or the
+// PostgreSQL lexer sees them
+func codeWords(query string, mysql bool) []string {
+	var words []string
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--") && (!mysql || i+2 == len(query) || isSpaceByte(query[i+2])):
+			i = skipLine(query, i)
+		case c == '#' && mysql:
+			i = skipLine(query, i)
+		case c == '/' && strings.HasPrefix(query[i:], "/*") && !(mysql && strings.HasPrefix(query[i:], "/*!")):
+			i = skipBlockComment(query, i, !mysql)
+		case c == '\'':
+			backslash := mysql || (i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') && !isIdentByte(query, i-2))
+			i = skipQuoted(query, i, '\'', backslash)
+		case c == '"':
+			i = skipQuoted(query, i, '"', mysql)
+		case c == '`' && mysql:
+			i = skipQuoted(query, i, '`', false)
+		case c == '$' && !mysql && !isIdentByte(query, i-1):
+			i = skipDollarQuoted(query, i)
+		case isIdentByte(query, i):
+			start := i
+			for i < len(query) && isIdentByte(query, i) {
+				i++
+			}
+			words = append(words, strings.ToUpper(query[start:i]))
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+// LeadingKeyword returns the first SQL keyword of query in upper case,
+// skipping whitespace, "--" and "#" line comments, "/* */" block comments
+// and opening parentheses
+func LeadingKeyword(query string) string {
+	rest := skipIgnorable(query)
+	end := strings.IndexFunc(rest, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '_'
+	})
+	if end == -1 {
+		end = len(rest)
+	}
+	return strings.ToUpper(rest[:end])
+}
+
+// skipIgnorable strips leading whitespace, comments and parentheses from query
+func skipIgnorable(query string) string {
+	rest := query
+	for {
+		trimmed := strings.TrimLeftFunc(rest, func(r rune) bool {
+			return unicode.IsSpace(r) || r == '('
+		})
+
+		switch {
+		case strings.HasPrefix(trimmed, "--"), strings.HasPrefix(trimmed, "#"):
+			newline := strings.IndexByte(trimmed, '\n')
+			if newline == -1 {
+				return ""
+			}
+			rest = trimmed[newline+1:]
+		case strings.HasPrefix(trimmed, "/*"):
+			closing := strings.Index(trimmed[2:], "*/")
+			if closing == -1 {
+				return ""
+			}
+			rest = trimmed[2+closing+2:]
+		default:
+			return trimmed
+		}
+	}
+}
+
+// ErrMultipleStatements is returned when a query holds more than one statement
+var ErrMultipleStatements = errors.New("query contains multiple statements")
+
+// CheckSingleStatement returns ErrMultipleStatements, quoting the first extra
+// statement, when query splits into more than one statement for dbType.
+// Trailing semicolons and comments don't count as statements.
+func CheckSingleStatement(query string, dbType string) error {
+	statements := SplitStatements(query, dbType)
+	if len(statements) > 1 {
+		return fmt.Errorf("%w: statement 2 of %d is %q (set allow_multi_statements to permit it)",
+			ErrMultipleStatements, len(statements), abbreviate(statements[1], 80))
+	}
+	return nil
+}
+
+// SplitStatements splits query on the semicolons that end statements,
+// skipping those inside string literals, quoted identifiers and comments as
+// dbType ("mysql" or "postgres") lexes them. Statements are returned trimmed;
+// empty ones and ones holding only comments are dropped.
+//
+// Where the dialects differ the rules of dbType apply: MySQL strings honour
+// backslash escapes, "#" starts a comment and "--" only does when followed
+// by whitespace, while "/*! */" executable comments are scanned as code.
+// PostgreSQL has E” escape strings, $tag$ dollar quoting and nested block
+// comments.
+func SplitStatements(query string, dbType string) []string {
+	mysql := dbType != "postgres"
+	var statements []string
+	start := 0
+	code := false // Whether the current statement has anything but whitespace and comments
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ';':
+			if code {
+				statements = append(statements, strings.TrimSpace(query[start:i]))
+			}
+			start, code = i+1, false
+			i++
+		case c == '-' && strings.HasPrefix(query[i:], "--") && (!mysql || i+2 == len(query) || isSpaceByte(query[i+2])):
+			i = skipLine(query, i)
+		case c == '#' && mysql:
+			i = skipLine(query, i)
+		case c == '/' && strings.HasPrefix(query[i:], "/*") && !(mysql && strings.HasPrefix(query[i:], "/*!")):
+			i = skipBlockComment(query, i, !mysql)
+		case isSpaceByte(c):
+			i++
+		default:
+			code = true
+			switch {
+			case c == '\'':
+				backslash := mysql || (i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') && !isIdentByte(query, i-2))
+				i = skipQuoted(query, i, '\'', backslash)
+			case c == '"':
+				i = skipQuoted(query, i, '"', mysql)
+			case c == '`' && mysql:
+				i = skipQuoted(query, i, '`', false)
+			case c == '$' && !mysql:
+				i = skipDollarQuoted(query, i)
+			default:
+				i++
+			}
+		}
+	}
+	if code {
+		statements = append(statements, strings.TrimSpace(query[start:]))
+	}
+	return statements
+}
+
+// skipQuoted returns the index just past the literal opened by quote at
+// start. A doubled quote is an escaped quote; with backslash, so is \<quote>.
+func skipQuoted(query string, start int, quote byte, backslash bool) int {
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if backslash {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// skipLine returns the index of the newline ending the comment at start
+func skipLine(query string, start int) int {
+	if newline := strings.IndexByte(query[start:], '\n'); newline != -1 {
+		return start + newline
+	}
+	return len(query)
+}
+
+// skipBlockComment returns the index just past the comment opened at start;
+// with nested, inner /* */ pairs must be closed too (PostgreSQL)
+func skipBlockComment(query string, start int, nested bool) int {
+	depth := 0
+	for i := start; i+1 < len(query); i++ {
+		switch {
+		case query[i] == '/' && query[i+1] == '*':
+			if depth == 0 || nested {
+				depth++
+			}
+			i++
+		case query[i] == '*' && query[i+1] == '/':
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(query)
+}
+
+// skipDollarQuoted returns the index just past the PostgreSQL $tag$...$tag$
+// string opened at start, or start+1 when the "$" opens no such string
+func skipDollarQuoted(query string, start int) int {
+	if isIdentByte(query, start-1) {
+		return start + 1 // Part of an identifier such as a$b
+	}
+	for i := start + 1; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '$':
+			tag := query[start : i+1]
+			if end := strings.Index(query[i+1:], tag); end != -1 {
+				return i + 1 + end + len(tag)
+			}
+			return len(query)
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80 || (i > start+1 && c >= '0' && c <= '9'):
+		default:
+			return start + 1 // e.g. a $1 parameter
+		}
+	}
+	return start + 1
+}
+
+// isIdentByte reports whether query[i] exists and can be part of an identifier
+func isIdentByte(query string, i int) bool {
+	if i < 0 || i >= len(query) {
+		return false
+	}
+	c := query[i]
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// isSpaceByte reports whether c is ASCII whitespace or a control character,
+// which MySQL requires after "--" for it to start a comment
+func isSpaceByte(c byte) bool {
+	return c <= ' '
+}
+
+// abbreviate shortens s to at most n bytes, marking the cut with "..."
+func abbreviate(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckReadOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		readOnly bool
+	}{
+		{"select", "SELECT * FROM t", true},
+		{"lower case", "select 1", true},
+		{"leading whitespace", " \n\t  SELECT 1", true},
+		{"line comment", "-- report\nSELECT 1", true},
+		{"hash comment", "# report\nSELECT 1", true},
+		{"block comment", "/* report */ SELECT 1", true},
+		{"comments and parentheses", "  /* a */ -- b\n ((SELECT 1) UNION (SELECT 2))", true},
+		{"show", "SHOW TABLES", true},
+		{"explain", "EXPLAIN SELECT * FROM t", true},
+		{"explain of a delete", "EXPLAIN DELETE FROM t", true},
+		{"cte select", "WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"cte for update", "WITH x AS (SELECT id FROM t) SELECT * FROM t JOIN x USING (id) FOR UPDATE", true},
+		{"cte for no key update", "WITH x AS (SELECT 1) SELECT * FROM t FOR NO KEY UPDATE", true},
+		{"cte keyword in string", "WITH x AS (SELECT 'delete me' AS note) SELECT * FROM x", true},
+		{"cte keyword in identifier", `WITH x AS (SELECT 1 AS "update") SELECT "update", updated_at FROM x`, true},
+		{"cte keyword in comment", "WITH x AS (SELECT 1) /* no DELETE here */ SELECT * FROM x", true},
+
+		{"empty", "", false},
+		{"only comments", "-- nothing\n/* at all */", false},
+		{"delete", "DELETE FROM t", false},
+		{"update behind comment", "/* SELECT */ UPDATE t SET a = 1", false},
+		{"insert behind whitespace", "\n\n   INSERT INTO t VALUES (1)", false},
+		{"drop", "DROP TABLE t", false},
+		{"cte delete", "WITH x AS (SELECT id FROM t) DELETE FROM t WHERE id IN (SELECT id FROM x)", false},
+		{"cte update", "with x as (select 1) update t set a = 1", false},
+		{"cte insert", "WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x", false},
+		{"cte merge", "WITH x AS (SELECT 1) MERGE INTO t USING x ON true WHEN MATCHED THEN DELETE", false},
+		{"data-modifying cte", "WITH gone AS (DELETE FROM t RETURNING *) SELECT * FROM gone", false},
+		{"nested cte delete", "WITH a AS (SELECT 1), b AS (WITH c AS (SELECT 1) DELETE FROM t RETURNING *) SELECT 1", false},
+		{"mysql dash dash is not a comment", "WITH x AS (SELECT 1--1) DELETE FROM t", false},
+		{"postgres hash is not a comment", "WITH x AS (SELECT 1 # 1) DELETE FROM t", false},
+		{"postgres backslash ends a string", `WITH x AS (SELECT 'a\') DELETE FROM t --')`, false},
+		{"explain analyze", "EXPLAIN ANALYZE DELETE FROM t", false},
+		{"explain analyse", "explain analyse delete from t", false},
+		{"explain analyze select", "EXPLAIN ANALYZE SELECT 1", false},
+		{"explain options analyze", "EXPLAIN (VERBOSE, ANALYZE) UPDATE t SET a = 1", false},
+		{"explain comment before analyze", "EXPLAIN /* plan */ ANALYZE DELETE FROM t", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckReadOnly(tt.query)
+			if tt.readOnly && err != nil {
+				t.Errorf("CheckReadOnly(%q) = %v, want nil", tt.query, err)
+			}
+			if !tt.readOnly && !errors.Is(err, ErrWriteQuery) {
+				t.Errorf("CheckReadOnly(%q) = %v, want ErrWriteQuery", tt.query, err)
+			}
+		})
+	}
+}
+
+func TestLeadingKeyword(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"select 1", "SELECT"},
+		{"   \t\nWITH x AS (SELECT 1) SELECT 1", "WITH"},
+		{"-- a\n# b\n/* c */ show tables", "SHOW"},
+		{"(((SELECT 1)))", "SELECT"},
+		{"/* unterminated", ""},
+		{"-- only a comment", ""},
+	}
+	for _, tt := range tests {
+		if got := LeadingKeyword(tt.query); got != tt.want {
+			t.Errorf("LeadingKeyword(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
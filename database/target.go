@@ -0,0 +1,173 @@
+package database
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Target is a parsed entry from the workload's targets list
+type Target struct {
+	Type string // Database type, inferred or the fallback
+	Host string
+	Port int // 0 when the target doesn't specify one
+
+	Socket string // Unix socket path for "unix://" targets, which use it instead of Host and Port
+
+	Database string // Database named by the target ("db1/sales"); "" uses DB_NAME
+}
+
+// schemeTypes maps URL schemes accepted in targets to database types
+var schemeTypes = map[string]string{
+	"mysql":      "mysql",
+	"postgres":   "postgres",
+	"postgresql": "postgres",
+}
+
+// socketSchemes maps URL schemes of Unix socket targets to database types;
+// "" means the fallback type
+var socketSchemes = map[string]string{
+	"unix":            "",
+	"mysql+unix":      "mysql",
+	"postgres+unix":   "postgres",
+	"postgresql+unix": "postgres",
+}
+
+// wellKnownPorts maps default server ports to the database type they imply
+var wellKnownPorts = map[int]string{
+	3306: "mysql",
+	5432: "postgres",
+}
+
+// ParseTarget parses a target such as "db1", "db1:5432", "postgres://db1:5432"
+// or "unix:///var/run/mysqld/mysqld.sock". Host targets may end in
+// "/<database>" (e.g. "db1:5432/sales") to name their own database. The
+// database type comes from the scheme if present, otherwise from a well-known
+// port, otherwise fallbackType. Unknown schemes are an error.
+func ParseTarget(target string, fallbackType string) (Target, error) {
+	parsed := Target{Type: fallbackType, Host: target}
+
+	// Scheme form: <type>://host[:port], or [<type>+]unix:///path
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return Target{}, fmt.Errorf("invalid target %q: %w", target, err)
+		}
+		if dbType, ok := socketSchemes[strings.ToLower(u.Scheme)]; ok {
+			if u.Host != "" || u.Path == "" {
+				return Target{}, fmt.Errorf("invalid target %q: expected an absolute socket path, e.g. %s:///var/run/mysqld/mysqld.sock", target, u.Scheme)
+			}
+			if dbType != "" {
+				parsed.Type = dbType
+			}
+			parsed.Host = "localhost"
+			parsed.Socket = u.Path
+			return parsed, nil
+		}
+		dbType, ok := schemeTypes[strings.ToLower(u.Scheme)]
+		if !ok {
+			return Target{}, fmt.Errorf("invalid target %q: unknown scheme %q (supported: mysql, postgres, postgresql, unix, mysql+unix, postgres+unix)", target, u.Scheme)
+		}
+		if u.Hostname() == "" {
+			return Target{}, fmt.Errorf("invalid target %q: missing host", target)
+		}
+		parsed.Type = dbType
+		parsed.Host = u.Hostname()
+		if parsed.Database, err = targetDatabase(target, strings.TrimPrefix(u.Path, "/"), u.Path != ""); err != nil {
+			return Target{}, err
+		}
+		if u.Port() != "" {
+			port, err := strconv.Atoi(u.Port())
+			if err != nil {
+				return Target{}, fmt.Errorf("invalid target %q: bad port %q", target, u.Port())
+			}
+			parsed.Port = port
+		}
+		return parsed, nil
+	}
+
+	// An optional database after the first '/'
+	address, database, hasDatabase := strings.Cut(target, "/")
+	var err error
+	if parsed.Database, err = targetDatabase(target, database, hasDatabase); err != nil {
+		return Target{}, err
+	}
+	parsed.Host = address
+
+	// host:port form; a bare host (or bare IPv6 address) is left untouched
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return parsed, nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return Target{}, fmt.Errorf("invalid target %q: bad port %q", target, portStr)
+	}
+	parsed.Host = host
+	parsed.Port = port
+	if dbType, ok := wellKnownPorts[port]; ok {
+		parsed.Type = dbType
+	}
+	return parsed, nil
+}
+
+// targetDatabase checks the database part of a target, which must be a
+// single non-empty name when the target has one
+func targetDatabase(target string, database string, present bool) (string, error) {
+	if present && (database == "" || strings.Contains(database, "/")) {
+		return "", fmt.Errorf("invalid target %q: expected a single database name after the host, e.g. db1/sales", target)
+	}
+	return database, nil
+}
+
+// candidateSeparator separates the hosts of a target with failover candidates
+const candidateSeparator = ","
+
+// SplitCandidates splits a target entry such as "db1,db1-replica:3306" into
+// its candidate hosts, in order of preference. A plain target yields itself.
+func SplitCandidates(target string) []string {
+	var candidates []string
+	for _, candidate := range strings.Split(target, candidateSeparator) {
+		if candidate = strings.TrimSpace(candidate); candidate != "" {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// MatchesTarget reports whether any pattern (a host name or glob) matches the
+// target entry as a whole or one of its failover candidates
+func MatchesTarget(target string, patterns []string) (bool, error) {
+	names := append([]string{target}, SplitCandidates(target)...)
+	for _, pattern := range patterns {
+		for _, name := range names {
+			matched, err := path.Match(pattern, name)
+			if err != nil {
+				return false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// defaultPorts maps database types to their standard server port. Types
+// without a driver yet are listed so adding one doesn't need another table.
+var defaultPorts = map[string]int{
+	"mysql":      3306,
+	"postgres":   5432,
+	"sqlserver":  1433,
+	"oracle":     1521,
+	"clickhouse": 9000,
+}
+
+// DefaultPort returns the standard server port for a database type, or 0 if
+// unknown so the caller can report it
+func DefaultPort(dbType string) int {
+	return defaultPorts[dbType]
+}
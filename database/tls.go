@@ -0,0 +1,153 @@
+package database
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrTLSHandshake is returned when the server and client fail to negotiate TLS
+var ErrTLSHandshake = errors.New("TLS negotiation failed")
+
+// rootCert returns the CA file the server is verified against, the same for
+// every driver: SSLRootCert when set, else CABundle, else "" for the system
+// roots
+func (c Config) rootCert() string {
+	if c.SSLRootCert != "" {
+		return c.SSLRootCert
+	}
+	return c.CABundle
+}
+
+// validateTLSFiles checks that every configured certificate/key file exists
+// so a typo is reported before any connection attempt
+func validateTLSFiles(config Config) error {
+	files := []struct {
+		setting string
+		path    string
+	}{
+		{"sslrootcert", config.SSLRootCert},
+		{"ca_bundle", config.CABundle},
+		{"sslcert", config.SSLCert},
+		{"sslkey", config.SSLKey},
+	}
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			return fmt.Errorf("%s file %s: %w", file.setting, file.path, err)
+		}
+	}
+	if (config.SSLCert == "") != (config.SSLKey == "") {
+		return errors.New("sslcert and sslkey must be set together")
+	}
+	return nil
+}
+
+// LoadCABundle reads a PEM file of CA certificates into a pool. Text around
+// the PEM blocks is ignored, but every block must be a certificate that
+// parses, and there must be at least one.
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ca_bundle %s: %w", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	count := 0
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		count++
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("ca_bundle %s: PEM block %d is a %s, not a CERTIFICATE", path, count, block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("ca_bundle %s: certificate %d: %w", path, count, err)
+		}
+		pool.AddCert(cert)
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("ca_bundle %s: no PEM certificates found", path)
+	}
+	return pool, nil
+}
+
+// LoadClientCertificate loads a PEM client certificate and its private key,
+// checking that they belong together
+func LoadClientCertificate(certFile string, keyFile string) (tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, errors.New("sslcert and sslkey must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("client certificate %s with key %s: %w", certFile, keyFile, err)
+	}
+	return cert, nil
+}
+
+// isClientCertRejected reports whether err is the server refusing the
+// client certificate during the TLS handshake
+func isClientCertRejected(err error) bool {
+	var alertErr tls.AlertError
+	if errors.As(err, &alertErr) {
+		switch alertErr {
+		case 42, 43, 44, 45, 46, 48, 116: // bad, unsupported, revoked, expired, unknown certificate; unknown CA; certificate required
+			return true
+		}
+	}
+
+	// Not every driver keeps the alert as a value
+	message := err.Error()
+	for _, alert := range []string{"bad certificate", "unsupported certificate", "certificate revoked", "certificate expired",
+		"certificate unknown", "unknown certificate authority", "certificate required"} {
+		if strings.Contains(message, "tls: "+alert) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsHandshakeError wraps a failed TLS negotiation in ErrTLSHandshake, saying
+// so when it was the client certificate the server refused
+func tlsHandshakeError(config Config, err error) error {
+	if config.SSLCert != "" && isClientCertRejected(err) {
+		return fmt.Errorf("%w: the server rejected client certificate %s: %v", ErrTLSHandshake, config.SSLCert, err)
+	}
+	return fmt.Errorf("%w: %v", ErrTLSHandshake, err)
+}
+
+// isTLSError reports whether err was caused by a failed TLS handshake or
+// certificate verification
+func isTLSError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &verifyErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return true
+	}
+
+	// Some drivers flatten the underlying error into text
+	message := err.Error()
+	return strings.Contains(message, "tls: ") || strings.Contains(message, "x509: ") ||
+		strings.Contains(message, "server refused TLS connection")
+}
+
+// pgValue quotes a value for a PostgreSQL key=value connection string
+func pgValue(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+	return "'" + escaped + "'"
+}
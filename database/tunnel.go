@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHConfig describes a bastion host used to reach a database
+type SSHConfig struct {
+	Host                  string
+	Port                  int // Defaults to 22
+	User                  string
+	KeyFile               string // Path to the private key used to authenticate
+	KeyPassphrase         string // Optional passphrase for an encrypted key
+	KnownHostsFile        string // Defaults to ~/.ssh/known_hosts
+	InsecureIgnoreHostKey bool   // Skip host key verification (testing only)
+}
+
+// DialFunc dials a network address, as used by the drivers' custom dialers
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// tunnels tracks the SSH client backing each open connection pool so Close can tear it down
+var tunnels sync.Map // map[*sql.DB]*ssh.Client
+
+// openSSHTunnel connects to the bastion described by config. Cancelling ctx
+// aborts the connection and the SSH handshake; timeout bounds both too.
+func openSSHTunnel(ctx context.Context, config SSHConfig, timeout time.Duration) (*ssh.Client, error) {
+	keyData, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading SSH key %s: %w", config.KeyFile, err)
+	}
+
+	var signer ssh.Signer
+	if config.KeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(config.KeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyData)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SSH key %s: %w", config.KeyFile, err)
+	}
+
+	hostKeyCallback, err := sshHostKeyCallback(config)
+	if err != nil {
+		return nil, err
+	}
+
+	port := config.Port
+	if port == 0 {
+		port = 22
+	}
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(port))
+
+	// The timeout bounds dialing and the handshake together; its expiry is
+	// a connect timeout, while ctx ending is the run being stopped
+	connectCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		connectCtx, cancel = context.WithTimeoutCause(ctx, timeout, ErrConnectTimeout)
+		defer cancel()
+	}
+	tunnelError := func(err error) error {
+		if ctx.Err() != nil {
+			return fmt.Errorf("error connecting to SSH bastion %s: %w", addr, context.Cause(ctx))
+		}
+		if timeout > 0 && (errors.Is(context.Cause(connectCtx), ErrConnectTimeout) || isTimeout(err)) {
+			return fmt.Errorf("%w after %v: error connecting to SSH bastion %s: %v", ErrConnectTimeout, timeout, addr, err)
+		}
+		return fmt.Errorf("error connecting to SSH bastion %s: %w", addr, err)
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(connectCtx, "tcp", addr)
+	if err != nil {
+		return nil, tunnelError(err)
+	}
+
+	// The handshake takes no context, so a hung bastion is only interrupted
+	// by closing the connection under it
+	stop := context.AfterFunc(connectCtx, func() { conn.Close() })
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            config.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if !stop() {
+		if err == nil {
+			sshConn.Close()
+		}
+		return nil, tunnelError(context.Cause(connectCtx))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, tunnelError(err)
+	}
+
+	return ssh.NewClient(sshConn, channels, requests), nil
+}
+
+// sshHostKeyCallback returns the host key verification to use for the bastion
+func sshHostKeyCallback(config SSHConfig) (ssh.HostKeyCallback, error) {
+	if config.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	knownHostsFile := config.KnownHostsFile
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("error locating known_hosts: %w", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading known hosts from %s: %w", knownHostsFile, err)
+	}
+	return callback, nil
+}
+
+// tunnelDialer returns a DialFunc that opens connections through the SSH client
+func tunnelDialer(client *ssh.Client) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return client.DialContext(ctx, network, addr)
+	}
+}
+
+// closeTunnel closes the SSH tunnel registered for sqlDB, if any
+func closeTunnel(sqlDB *sql.DB) error {
+	value, ok := tunnels.LoadAndDelete(sqlDB)
+	if !ok {
+		return nil
+	}
+	return value.(*ssh.Client).Close()
+}
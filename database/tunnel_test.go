@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// silentBastion accepts connections and never answers, like a hung bastion
+func silentBastion(t *testing.T) (string, int) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	return host, portNumber
+}
+
+func testKeyFile(t *testing.T) string {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenSSHTunnelHonoursContext(t *testing.T) {
+	host, port := silentBastion(t)
+	config := SSHConfig{Host: host, Port: port, User: "test", KeyFile: testKeyFile(t), InsecureIgnoreHostKey: true}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := openSSHTunnel(ctx, config, 0)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrConnectTimeout) {
+		t.Fatalf("openSSHTunnel() error = %v, want context.DeadlineExceeded, not a connect timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("openSSHTunnel() took %v after the context ended", elapsed)
+	}
+}
+
+func TestOpenSSHTunnelTimeout(t *testing.T) {
+	host, port := silentBastion(t)
+	config := SSHConfig{Host: host, Port: port, User: "test", KeyFile: testKeyFile(t), InsecureIgnoreHostKey: true}
+
+	start := time.Now()
+	_, err := openSSHTunnel(context.Background(), config, 100*time.Millisecond)
+	if !errors.Is(err, ErrConnectTimeout) {
+		t.Fatalf("openSSHTunnel() error = %v, want ErrConnectTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("openSSHTunnel() took %v with a 100ms timeout", elapsed)
+	}
+}
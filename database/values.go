@@ -0,0 +1,203 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Representations of boolean values (BOOL columns and single-bit BIT values)
+const (
+	BoolFormatNumeric = "numeric" // "0" / "1" (default)
+	BoolFormatText    = "text"    // "false" / "true"
+)
+
+// ValidateBoolFormat checks a configured boolean representation
+func ValidateBoolFormat(format string) error {
+	switch format {
+	case "", BoolFormatNumeric, BoolFormatText:
+		return nil
+	default:
+		return fmt.Errorf("invalid bool_format %q (expected %q or %q)", format, BoolFormatNumeric, BoolFormatText)
+	}
+}
+
+// Representations of binary values (BLOB, BINARY, VARBINARY and BYTEA columns)
+const (
+	BinaryFormatHex         = "hex"         // Lowercase hexadecimal (default)
+	BinaryFormatBase64      = "base64"      // Standard base64 with padding
+	BinaryFormatPlaceholder = "placeholder" // "<BLOB:n bytes>"
+	BinaryFormatRaw         = "raw"         // The bytes as they are, control bytes included
+)
+
+// ValidateBinaryFormat checks a configured binary representation
+func ValidateBinaryFormat(format string) error {
+	switch format {
+	case "", BinaryFormatHex, BinaryFormatBase64, BinaryFormatPlaceholder, BinaryFormatRaw:
+		return nil
+	default:
+		return fmt.Errorf("invalid binary_format %q (expected %q, %q, %q or %q)", format,
+			BinaryFormatHex, BinaryFormatBase64, BinaryFormatPlaceholder, BinaryFormatRaw)
+	}
+}
+
+// Representations of JSON values (JSON and JSONB columns)
+const (
+	JSONFormatText    = "text"    // As the database writes it (default)
+	JSONFormatCompact = "compact" // Without insignificant whitespace
+	JSONFormatPretty  = "pretty"  // Indented by two spaces, over several lines
+)
+
+// ValidateJSONFormat checks a configured JSON representation
+func ValidateJSONFormat(format string) error {
+	switch format {
+	case "", JSONFormatText, JSONFormatCompact, JSONFormatPretty:
+		return nil
+	default:
+		return fmt.Errorf("invalid json_format %q (expected %q, %q or %q)", format,
+			JSONFormatText, JSONFormatCompact, JSONFormatPretty)
+	}
+}
+
+// formatValue converts a scanned, non-NULL value to text using the column's
+// database type name where the driver's own representation is unreadable
+func formatValue(value interface{}, columnType string, options QueryOptions) string {
+	switch v := value.(type) {
+	case bool:
+		return formatBool(v, options.BoolFormat)
+	case []byte:
+		if isBitType(columnType) {
+			return formatBits(v, options.BoolFormat)
+		}
+		if isBinaryType(columnType) {
+			return formatBinary(v, options.BinaryFormat)
+		}
+		if isJSONType(columnType) {
+			return formatJSON(v, options.JSONFormat)
+		}
+		return string(v)
+	case string:
+		if isArrayType(columnType) {
+			return formatArray(v, columnType, options)
+		}
+		if isJSONType(columnType) {
+			return formatJSON([]byte(v), options.JSONFormat)
+		}
+		if isBoolType(columnType) {
+			if b, ok := parseBool(v); ok {
+				return formatBool(b, options.BoolFormat)
+			}
+		}
+		if isBitType(columnType) && (v == "0" || v == "1") {
+			return formatBool(v == "1", options.BoolFormat)
+		}
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// formatBool renders b in the configured representation
+func formatBool(b bool, format string) string {
+	if format == BoolFormatText {
+		if b {
+			return "true"
+		}
+		return "false"
+	}
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+// formatBits renders a MySQL BIT value, which arrives as big-endian bytes. A
+// single byte holding 0 or 1 is treated as a boolean (drivers don't report
+// the declared BIT width); anything wider becomes its unsigned integer value.
+func formatBits(bits []byte, format string) string {
+	if len(bits) == 1 && bits[0] <= 1 {
+		return formatBool(bits[0] == 1, format)
+	}
+	return new(big.Int).SetBytes(bits).String()
+}
+
+// formatBinary renders the bytes of a binary column in the configured
+// representation, so raw control bytes never end up in the output by default
+func formatBinary(data []byte, format string) string {
+	switch format {
+	case BinaryFormatBase64:
+		return base64.StdEncoding.EncodeToString(data)
+	case BinaryFormatPlaceholder:
+		return fmt.Sprintf("<BLOB:%d bytes>", len(data))
+	case BinaryFormatRaw:
+		return string(data)
+	default:
+		return hex.EncodeToString(data)
+	}
+}
+
+// formatJSON renders a JSON document in the configured representation;
+// documents that aren't valid JSON are left as they are
+func formatJSON(data []byte, format string) string {
+	var formatted bytes.Buffer
+	var err error
+	switch format {
+	case JSONFormatCompact:
+		err = json.Compact(&formatted, data)
+	case JSONFormatPretty:
+		err = json.Indent(&formatted, data, "", "  ")
+	default:
+		return string(data)
+	}
+	if err != nil {
+		return string(data)
+	}
+	return formatted.String()
+}
+
+// parseBool accepts the textual forms drivers use for booleans
+func parseBool(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "1", "t", "true":
+		return true, true
+	case "0", "f", "false":
+		return false, true
+	}
+	return false, false
+}
+
+// isBitType reports whether a database type name denotes a BIT column
+func isBitType(columnType string) bool {
+	return strings.EqualFold(columnType, "BIT")
+}
+
+// isBoolType reports whether a database type name denotes a boolean column
+func isBoolType(columnType string) bool {
+	switch strings.ToUpper(columnType) {
+	case "BOOL", "BOOLEAN":
+		return true
+	}
+	return false
+}
+
+// isJSONType reports whether a database type name denotes a JSON column
+func isJSONType(columnType string) bool {
+	switch strings.ToUpper(columnType) {
+	case "JSON", "JSONB":
+		return true
+	}
+	return false
+}
+
+// isBinaryType reports whether a database type name denotes a binary column
+func isBinaryType(columnType string) bool {
+	switch strings.ToUpper(columnType) {
+	case "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "BYTEA":
+		return true
+	}
+	return false
+}
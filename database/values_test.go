@@ -0,0 +1,119 @@
+package database
+
+import "testing"
+
+func TestFormatBits(t *testing.T) {
+	tests := []struct {
+		name   string
+		bits   []byte
+		format string
+		want   string
+	}{
+		{"BIT(1) zero", []byte{0}, BoolFormatNumeric, "0"},
+		{"BIT(1) one", []byte{1}, BoolFormatNumeric, "1"},
+		{"BIT(1) default format", []byte{1}, "", "1"},
+		{"BIT(1) text zero", []byte{0}, BoolFormatText, "false"},
+		{"BIT(1) text one", []byte{1}, BoolFormatText, "true"},
+		{"BIT(8)", []byte{0xff}, BoolFormatText, "255"},
+		{"BIT(8) of two", []byte{2}, BoolFormatNumeric, "2"},
+		{"BIT(16)", []byte{0x01, 0x00}, BoolFormatText, "256"},
+		{"BIT(16) of one", []byte{0x00, 0x01}, BoolFormatNumeric, "1"},
+		{"BIT(64)", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, BoolFormatNumeric, "18446744073709551615"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatBits(tt.bits, tt.format); got != tt.want {
+				t.Errorf("formatBits(%v, %q) = %q, want %q", tt.bits, tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatValueBit(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  interface{}
+		format string
+		want   string
+	}{
+		{"bytes", []byte{1}, BoolFormatText, "true"},
+		{"wide bytes", []byte{0x0a}, BoolFormatText, "10"},
+		{"text zero", "0", BoolFormatText, "false"},
+		{"text one", "1", BoolFormatNumeric, "1"},
+		{"text wide", "10", BoolFormatText, "10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatValue(tt.value, "BIT", QueryOptions{BoolFormat: tt.format})
+			if got != tt.want {
+				t.Errorf("formatValue(%v, BIT) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatBinary(t *testing.T) {
+	data := []byte{0x00, 0xde, 0xad, 0xbe, 0xef, '\n'}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"", "00deadbeef0a"},
+		{BinaryFormatHex, "00deadbeef0a"},
+		{BinaryFormatBase64, "AN6tvu8K"},
+		{BinaryFormatPlaceholder, "<BLOB:6 bytes>"},
+		{BinaryFormatRaw, "\x00\xde\xad\xbe\xef\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if got := formatBinary(data, tt.format); got != tt.want {
+				t.Errorf("formatBinary(%q) = %q, want %q", tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatValueBinary(t *testing.T) {
+	for _, columnType := range []string{"BLOB", "VARBINARY", "bytea"} {
+		got := formatValue([]byte{0xca, 0xfe}, columnType, QueryOptions{BinaryFormat: BinaryFormatBase64})
+		if got != "yv4=" {
+			t.Errorf("formatValue(%s) = %q, want %q", columnType, got, "yv4=")
+		}
+	}
+	if got := formatValue([]byte("plain"), "VARCHAR", QueryOptions{BinaryFormat: BinaryFormatHex}); got != "plain" {
+		t.Errorf("formatValue(VARCHAR) = %q, want the text unchanged", got)
+	}
+}
+
+func TestFormatJSONB(t *testing.T) {
+	document := `{"b": [1, 2], "a": {"c": "<x>"}}`
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"", document},
+		{JSONFormatText, document},
+		{JSONFormatCompact, `{"b":[1,2],"a":{"c":"<x>"}}`},
+		{JSONFormatPretty, "{\n  \"b\": [\n    1,\n    2\n  ],\n  \"a\": {\n    \"c\": \"<x>\"\n  }\n}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			options := QueryOptions{JSONFormat: tt.format}
+			// pgx hands JSONB over as text, the MySQL driver as bytes
+			if got := formatValue(document, "JSONB", options); got != tt.want {
+				t.Errorf("formatValue(JSONB, %q) = %q, want %q", tt.format, got, tt.want)
+			}
+			if got := formatValue([]byte(document), "JSON", options); got != tt.want {
+				t.Errorf("formatValue([]byte, JSON, %q) = %q, want %q", tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatJSONInvalid(t *testing.T) {
+	for _, format := range []string{JSONFormatCompact, JSONFormatPretty} {
+		if got := formatJSON([]byte("{not json"), format); got != "{not json" {
+			t.Errorf("formatJSON(invalid, %q) = %q, want it unchanged", format, got)
+		}
+	}
+}
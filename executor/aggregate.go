@@ -0,0 +1,212 @@
+package executor
+
+import (
+	"datacollector/csv"
+	"datacollector/database"
+	"datacollector/logging"
+	"datacollector/models"
+	"fmt"
+	"os"
+)
+
+// ResultWriter takes the aggregated results one target at a time, in target
+// order, while later targets are still queried; output.SharedCSVWriter is one
+type ResultWriter interface {
+	Write(result *database.QueryResult) error
+}
+
+// aggregator combines target results, holding rows in memory until
+// spillThreshold is exceeded and streaming them to a temporary CSV after that
+type aggregator struct {
+	spillThreshold int    // 0 keeps everything in memory
+	spillDir       string // Directory for the spill file (the output directory, so it can be renamed)
+	dirMode        os.FileMode
+	flushRows      int // Rows between flushes of the spill file, as models.WriteOptions.FlushRows
+
+	// unionColumns buffers every result until finish, then aligns all rows
+	// to the union of the targets' columns, filling gaps with nullValue
+	unionColumns bool
+	nullValue    string
+	pending      []*database.QueryResult
+
+	// dedupe, when set, drops rows repeating an earlier row's key columns
+	dedupe *deduper
+
+	// pipe, when set, receives every result instead of the aggregate keeping
+	// its rows; it is never combined with unionColumns or dedupe
+	pipe ResultWriter
+
+	columns     []string
+	columnTypes []string
+	hasResults  bool
+	rows        [][]string
+	rowCount    int
+
+	spillFile   *os.File
+	spillWriter csv.Writer
+}
+
+// add merges one target's result into the aggregate
+func (a *aggregator) add(result *database.QueryResult) error {
+	if result == nil {
+		return nil
+	}
+	if a.unionColumns {
+		a.pending = append(a.pending, result)
+		return nil
+	}
+	if !a.hasResults && len(result.Columns) > 0 {
+		a.columns = result.Columns // Get columns from the first result
+		a.columnTypes = result.ColumnTypes
+		a.hasResults = true
+	}
+	rows := result.Rows
+	if a.dedupe != nil {
+		var err error
+		if rows, err = a.dedupe.filter(result.Columns, rows, a.rows); err != nil {
+			return err
+		}
+	}
+	a.rowCount += len(rows)
+
+	// Pipelined output writes the rows right away and nothing is kept
+	if a.pipe != nil {
+		if err := a.pipe.Write(result); err != nil {
+			return fmt.Errorf("error writing pipelined output: %w", err)
+		}
+		return nil
+	}
+
+	// Once spilled, every further row goes straight to disk
+	if a.spillWriter != nil {
+		return a.writeSpill(rows)
+	}
+
+	a.rows = append(a.rows, rows...)
+	// Keeping the last duplicate rewrites earlier rows, so they must stay in memory
+	if a.spillThreshold > 0 && len(a.rows) > a.spillThreshold && (a.dedupe == nil || !a.dedupe.keepLast) {
+		return a.startSpill()
+	}
+	return nil
+}
+
+// startSpill moves the rows held so far into a new spill file
+func (a *aggregator) startSpill() error {
+	if a.spillDir != "" {
+		if err := os.MkdirAll(a.spillDir, a.dirMode); err != nil {
+			return fmt.Errorf("error creating spill directory: %w", err)
+		}
+	}
+	file, err := os.CreateTemp(a.spillDir, ".datacollector-spill-*.csv.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating spill file: %w", err)
+	}
+	logging.Infof("Aggregated rows exceeded spill_threshold (%d); spilling to %s", a.spillThreshold, file.Name())
+
+	a.spillFile = file
+	a.spillWriter = csv.NewWriter(file, models.WriteOptions{FlushRows: a.flushRows})
+	if len(a.columns) > 0 {
+		if err := a.spillWriter.Write(a.columns); err != nil {
+			return fmt.Errorf("error writing headers to spill file: %w", err)
+		}
+	}
+
+	rows := a.rows
+	a.rows = nil
+	return a.writeSpill(rows)
+}
+
+// writeSpill appends rows to the spill file
+func (a *aggregator) writeSpill(rows [][]string) error {
+	if err := a.spillWriter.WriteAll(rows); err != nil {
+		return fmt.Errorf("error writing to spill file: %w", err)
+	}
+	return nil
+}
+
+// finish closes the spill file, if any, and returns its path
+func (a *aggregator) finish() (string, error) {
+	if a.unionColumns {
+		if err := a.addUnion(); err != nil {
+			return "", err
+		}
+	}
+	if a.dedupe != nil && a.dedupe.duplicates > 0 {
+		logging.Infof("Dedupe on %v (keep %s) dropped %d duplicate row(s)", a.dedupe.keys, a.dedupe.keep, a.dedupe.duplicates)
+	}
+	if a.spillFile == nil {
+		return "", nil
+	}
+	a.spillWriter.Flush()
+	if err := a.spillWriter.Error(); err != nil {
+		a.spillFile.Close()
+		return "", fmt.Errorf("error flushing spill file: %w", err)
+	}
+	if err := a.spillFile.Close(); err != nil {
+		return "", fmt.Errorf("error closing spill file: %w", err)
+	}
+	return a.spillFile.Name(), nil
+}
+
+// discard removes the spill file after a failure
+func (a *aggregator) discard() {
+	if a.spillFile != nil {
+		a.spillFile.Close()
+		os.Remove(a.spillFile.Name())
+	}
+}
+
+// addUnion aggregates the buffered results under the union of their columns,
+// in order of first appearance, reindexing each result's rows by column name
+func (a *aggregator) addUnion() error {
+	a.unionColumns = false // Results are aggregated normally from here on
+
+	var columns, columnTypes []string
+	seen := make(map[string]bool)
+	for _, result := range a.pending {
+		for i, column := range result.Columns {
+			if seen[column] {
+				continue
+			}
+			seen[column] = true
+			columns = append(columns, column)
+			columnType := ""
+			if i < len(result.ColumnTypes) {
+				columnType = result.ColumnTypes[i]
+			}
+			columnTypes = append(columnTypes, columnType)
+		}
+	}
+
+	pending := a.pending
+	a.pending = nil
+	for _, result := range pending {
+		if err := a.add(alignResult(result, columns, columnTypes, a.nullValue)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alignResult returns result with its rows rearranged to match columns, using
+// nullValue for columns the result doesn't have
+func alignResult(result *database.QueryResult, columns []string, columnTypes []string, nullValue string) *database.QueryResult {
+	index := columnIndex(result.Columns)
+	aligned := &database.QueryResult{
+		Columns:     columns,
+		ColumnTypes: columnTypes,
+		Rows:        make([][]string, len(result.Rows)),
+	}
+	for r, row := range result.Rows {
+		alignedRow := make([]string, len(columns))
+		for i, column := range columns {
+			if j, ok := index[column]; ok && j < len(row) {
+				alignedRow[i] = row[j]
+			} else {
+				alignedRow[i] = nullValue
+			}
+		}
+		aligned.Rows[r] = alignedRow
+	}
+	return aligned
+}
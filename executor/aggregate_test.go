@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"datacollector/csv"
+)
+
+func TestAggregatorSpill(t *testing.T) {
+	memory := &aggregator{}
+	spilled := &aggregator{spillThreshold: 5, spillDir: t.TempDir()}
+	for _, host := range []string{"a", "b", "c", "d"} {
+		for _, agg := range []*aggregator{memory, spilled} {
+			if err := agg.add(hostRows(host, 3)); err != nil {
+				t.Fatalf("add %s: %v", host, err)
+			}
+		}
+	}
+
+	if path, err := memory.finish(); err != nil || path != "" {
+		t.Fatalf("in-memory finish() = %q, %v, want no spill file", path, err)
+	}
+	if spilled.rows != nil {
+		t.Errorf("spilled aggregate still holds %d rows in memory", len(spilled.rows))
+	}
+	path, err := spilled.finish()
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if path == "" {
+		t.Fatal("finish() returned no spill file past the threshold")
+	}
+	if spilled.rowCount != memory.rowCount || spilled.rowCount != 12 {
+		t.Errorf("rowCount = %d spilled, %d in memory, want 12", spilled.rowCount, memory.rowCount)
+	}
+
+	records, err := csv.ReadCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append([][]string{memory.columns}, memory.rows...)
+	if fmt.Sprint(records) != fmt.Sprint(want) {
+		t.Errorf("spill file = %v\nwant the in-memory aggregate %v", records, want)
+	}
+
+	spilled.discard()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("spill file still exists after discard: %v", err)
+	}
+}
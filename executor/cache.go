@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"datacollector/database"
+	"datacollector/logging"
+	"datacollector/models"
+	"datacollector/state"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCacheDir is where cached results are kept when cache_dir isn't set
+const DefaultCacheDir = ".cache"
+
+// resultCache returns the workload's result cache, or nil when cache_ttl is unset
+func resultCache(workload *models.Workload) *state.ResultCache {
+	if workload.CacheTTL.Duration <= 0 {
+		return nil
+	}
+	dir := workload.CacheDir
+	if dir == "" {
+		dir = DefaultCacheDir
+	}
+	return &state.ResultCache{Dir: dir, TTL: workload.CacheTTL.Duration}
+}
+
+// resultCacheKey identifies a target's raw result: anything that changes
+// the rows fetched or how their values are rendered is part of it, so
+// editing the query invalidates the entry
+func resultCacheKey(host string, workload *models.Workload, dbConfig database.Config) string {
+	return strings.Join([]string{
+		host,
+		dbConfig.User,
+		dbConfig.Database,
+		workloadQuery(workload),
+		workload.NullSentinel(),
+		workload.BoolFormat,
+		workload.BinaryFormat,
+		workload.ArrayFormat,
+		workload.JSONFormat,
+		sampleKey(workload),
+	}, "\x00")
+}
+
+// sampleKey describes the sampling settings, so a cached sample is only
+// reused for the same sample
+func sampleKey(workload *models.Workload) string {
+	if !workload.Samples() {
+		return ""
+	}
+	seed := "random"
+	if workload.SampleSeed != nil {
+		seed = strconv.FormatUint(*workload.SampleSeed, 10)
+	}
+	return fmt.Sprintf("sample_rate=%v sample_n=%d sample_seed=%s", workload.SampleRate, workload.SampleN, seed)
+}
+
+// cachedResult returns the cached result for a target when there is a fresh
+// one, unless -no-cache asked to bypass it
+func cachedResult(cache *state.ResultCache, key string, host string, workload *models.Workload) (*database.QueryResult, bool) {
+	if cache == nil || workload.NoCache {
+		return nil, false
+	}
+	result, storedAt, ok, err := cache.Get(key)
+	if err != nil {
+		logging.Warnf("Warning: ignoring cached result for %s: %v", host, err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	logging.Infof("Using cached result for %s from %s (cache_ttl %v); the database is not queried",
+		host, storedAt.Format(time.RFC3339), cache.TTL)
+	return result, true
+}
+
+// storeResult caches a freshly fetched result; a failure only costs a cache miss later
+func storeResult(cache *state.ResultCache, key string, host string, result *database.QueryResult) {
+	if cache == nil {
+		return
+	}
+	if err := cache.Put(key, result); err != nil {
+		logging.Warnf("Warning: failed to cache the result of %s: %v", host, err)
+	}
+}
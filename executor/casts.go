@@ -0,0 +1,187 @@
+package executor
+
+import (
+	"datacollector/database"
+	"datacollector/logging"
+	"datacollector/models"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types column_casts can convert a column to
+const (
+	CastInt   = "int"
+	CastFloat = "float"
+	CastBool  = "bool"
+	CastDate  = "date"
+)
+
+// Ways of handling a value that can't be cast
+const (
+	CastModeStrict  = "strict"  // The target fails (default)
+	CastModeLenient = "lenient" // The value becomes NULL and a warning is logged
+)
+
+// castFunc converts one non-NULL value, reporting whether it could
+type castFunc func(value string, boolFormat string) (string, bool)
+
+// casts maps each cast type to its conversion and the column type reported for it
+var casts = map[string]struct {
+	fn         castFunc
+	columnType string
+}{
+	CastInt:   {castInt, "BIGINT"},
+	CastFloat: {castFloat, "DOUBLE"},
+	CastBool:  {castBool, "BOOLEAN"},
+	CastDate:  {castDate, "DATE"},
+}
+
+// dateLayouts are the input forms a date cast accepts, tried in order
+var dateLayouts = []string{
+	"2006-01-02",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006/01/02",
+	"20060102",
+}
+
+// ValidateColumnCasts checks the column_casts types and cast_mode
+func ValidateColumnCasts(columnCasts map[string]string, mode string) error {
+	for column, castType := range columnCasts {
+		if _, ok := casts[castType]; !ok {
+			return fmt.Errorf("column %q: unknown cast type %q (supported: %s, %s, %s, %s)",
+				column, castType, CastInt, CastFloat, CastBool, CastDate)
+		}
+	}
+	if mode != "" && mode != CastModeStrict && mode != CastModeLenient {
+		return fmt.Errorf("invalid cast_mode %q (supported: %s, %s)", mode, CastModeStrict, CastModeLenient)
+	}
+	return nil
+}
+
+// castColumns converts the values of the column_casts columns, replacing
+// result's rows with converted copies, and sets the columns' types. Values that can't be
+// converted fail the target in strict mode; in lenient mode they are set to
+// NULL and counted in a warning. A cast for a missing column is a warning,
+// unless quiet.
+func castColumns(host string, result *database.QueryResult, workload *models.Workload, quiet bool) error {
+	index := columnIndex(result.Columns)
+	nullValue := workload.NullSentinel()
+
+	byPosition := make(map[int]string, len(workload.ColumnCasts))
+	for column, castType := range workload.ColumnCasts {
+		i, ok := index[column]
+		if !ok {
+			if !quiet {
+				logging.Warnf("Warning: column_casts reference column %q not in result", column)
+			}
+			continue
+		}
+		byPosition[i] = castType
+	}
+	if len(byPosition) == 0 {
+		return nil
+	}
+
+	columnTypes := make([]string, len(result.Columns))
+	copy(columnTypes, result.ColumnTypes)
+	for i, castType := range byPosition {
+		columnTypes[i] = casts[castType].columnType
+	}
+
+	failed := 0
+	var first string
+	rows := make([][]string, len(result.Rows))
+	for r, row := range result.Rows {
+		newRow := make([]string, len(row))
+		copy(newRow, row)
+		for i, castType := range byPosition {
+			if i >= len(newRow) || newRow[i] == nullValue {
+				continue
+			}
+			cast, ok := casts[castType].fn(newRow[i], workload.BoolFormat)
+			if !ok {
+				failed++
+				if first == "" {
+					first = fmt.Sprintf("row %d, column %q: %q is not a valid %s", r+1, result.Columns[i], newRow[i], castType)
+				}
+				if workload.CastMode != CastModeLenient {
+					continue
+				}
+				cast = nullValue
+			}
+			newRow[i] = cast
+		}
+		rows[r] = newRow
+	}
+
+	if failed > 0 {
+		if workload.CastMode != CastModeLenient {
+			return fmt.Errorf("%d value(s) could not be cast (first: %s)", failed, first)
+		}
+		logging.Warnf("Warning: %d value(s) on %s could not be cast and were set to NULL (first: %s)", failed, host, first)
+	}
+	result.Rows = rows
+	result.ColumnTypes = columnTypes
+	return nil
+}
+
+// castInt accepts integers and decimal numbers, dropping any fraction
+// (truncating toward zero)
+func castInt(value string, _ string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return strconv.FormatInt(n, 10), true
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) || f >= math.MaxInt64 || f < math.MinInt64 {
+		return "", false
+	}
+	return strconv.FormatInt(int64(f), 10), true
+}
+
+// castFloat accepts any number and writes it without an exponent
+func castFloat(value string, _ string) (string, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", false
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), true
+}
+
+// castBool accepts the usual spellings of true and false and writes them
+// in the configured bool_format
+func castBool(value string, boolFormat string) (string, bool) {
+	var b bool
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "t", "true", "y", "yes", "on":
+		b = true
+	case "0", "f", "false", "n", "no", "off":
+		b = false
+	default:
+		return "", false
+	}
+	if boolFormat == database.BoolFormatText {
+		return strconv.FormatBool(b), true
+	}
+	if b {
+		return "1", true
+	}
+	return "0", true
+}
+
+// castDate accepts ISO-style dates and timestamps and keeps the date (as
+// written, without converting time zones)
+func castDate(value string, _ string) (string, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format("2006-01-02"), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,226 @@
+package executor
+
+import (
+	"context"
+	"datacollector/database"
+	"datacollector/logging"
+	"datacollector/models"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chunkPlaceholder is replaced by one chunk's condition when present in the query
+const chunkPlaceholder = models.ChunkPlaceholder
+
+// Layouts accepted for date chunk bounds; boundaries are written back in the
+// layout of chunks.min
+var chunkDateLayouts = []string{"2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05", time.RFC3339Nano}
+
+// chunkTimeLayout writes the boundaries of timestamp chunks
+const chunkTimeLayout = "2006-01-02 15:04:05.999999"
+
+// ValidateChunks checks the chunks settings, returning the condition of every
+// chunk when they are valid
+func ValidateChunks(chunks *models.Chunks) ([]string, error) {
+	if strings.TrimSpace(chunks.Column) == "" {
+		return nil, errors.New("missing column")
+	}
+	if chunks.Count < 2 {
+		return nil, fmt.Errorf("count must be at least 2, not %d", chunks.Count)
+	}
+	if chunks.Min == "" || chunks.Max == "" {
+		return nil, errors.New("missing min or max")
+	}
+
+	boundaries, err := chunkBoundaries(string(chunks.Min), string(chunks.Max), chunks.Count)
+	if err != nil {
+		return nil, err
+	}
+	if len(boundaries) == 0 {
+		return nil, fmt.Errorf("the range from %s to %s is too narrow to split", chunks.Min, chunks.Max)
+	}
+	return chunkConditions(chunks.Column, boundaries), nil
+}
+
+// chunkBoundaries splits [min, max] into count ranges of the same size and
+// returns the SQL literals of the count-1 inner boundaries. Ranges too narrow
+// to split that far (e.g. 3 integers in 5 chunks) yield fewer boundaries.
+func chunkBoundaries(min string, max string, count int) ([]string, error) {
+	// Whole numbers split exactly, without float rounding
+	lowInt, lowErr := strconv.ParseInt(min, 10, 64)
+	highInt, highErr := strconv.ParseInt(max, 10, 64)
+	if lowErr == nil && highErr == nil {
+		if highInt <= lowInt {
+			return nil, fmt.Errorf("max %s must be greater than min %s", max, min)
+		}
+		return distinctBoundaries(splitInts(lowInt, highInt, count, func(value int64) string {
+			return strconv.FormatInt(value, 10)
+		})), nil
+	}
+
+	lowFloat, lowErr := strconv.ParseFloat(min, 64)
+	highFloat, highErr := strconv.ParseFloat(max, 64)
+	if lowErr == nil && highErr == nil {
+		if highFloat <= lowFloat {
+			return nil, fmt.Errorf("max %s must be greater than min %s", max, min)
+		}
+		boundaries := make([]string, count-1)
+		for i := range boundaries {
+			value := lowFloat + (highFloat-lowFloat)*float64(i+1)/float64(count)
+			boundaries[i] = strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		return distinctBoundaries(boundaries), nil
+	}
+
+	lowTime, layout, lowErr := parseChunkDate(min)
+	highTime, _, highErr := parseChunkDate(max)
+	if lowErr != nil || highErr != nil {
+		return nil, fmt.Errorf("min %q and max %q must both be numbers or both be dates (YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)", min, max)
+	}
+	if !highTime.After(lowTime) {
+		return nil, fmt.Errorf("max %s must be later than min %s", max, min)
+	}
+
+	// Dates split on whole days, so the boundaries compare cleanly with DATE columns
+	if layout == chunkDateLayouts[0] {
+		day := int64(24 * time.Hour)
+		lowDay, highDay := lowTime.UnixNano()/day, highTime.UnixNano()/day
+		return distinctBoundaries(splitInts(lowDay, highDay, count, func(value int64) string {
+			return sqlLiteral(time.Unix(0, value*day).UTC().Format(layout))
+		})), nil
+	}
+	low, high := lowTime.UnixNano(), highTime.UnixNano()
+	return distinctBoundaries(splitInts(low, high, count, func(value int64) string {
+		return sqlLiteral(time.Unix(0, value).In(lowTime.Location()).Format(chunkTimeLayout))
+	})), nil
+}
+
+// splitInts returns the inner boundaries splitting [low, high] into count
+// ranges as equal as whole numbers allow, formatted by format. A boundary at
+// low would leave the first chunk only the values below the range, so it is
+// left out.
+func splitInts(low int64, high int64, count int, format func(int64) string) []string {
+	span := uint64(high - low) // Exact even when high-low overflows int64
+	var boundaries []string
+	for i := 1; i < count; i++ {
+		// (span+1)*i/count over the span+1 values, in 128 bits so nothing overflows
+		hi, lo := bits.Mul64(span, uint64(i))
+		lo, carry := bits.Add64(lo, uint64(i), 0)
+		offset, _ := bits.Div64(hi+carry, lo, uint64(count))
+		if offset > 0 {
+			boundaries = append(boundaries, format(low+int64(offset)))
+		}
+	}
+	return boundaries
+}
+
+// distinctBoundaries drops repeated boundaries, which would make empty chunks
+func distinctBoundaries(boundaries []string) []string {
+	distinct := boundaries[:0]
+	for i, boundary := range boundaries {
+		if i == 0 || boundary != boundaries[i-1] {
+			distinct = append(distinct, boundary)
+		}
+	}
+	return distinct
+}
+
+// parseChunkDate parses a date chunk bound, returning the layout it matched
+func parseChunkDate(value string) (time.Time, string, error) {
+	var err error
+	for _, layout := range chunkDateLayouts {
+		var parsed time.Time
+		if parsed, err = time.Parse(layout, value); err == nil {
+			return parsed, layout, nil
+		}
+	}
+	return time.Time{}, "", err
+}
+
+// chunkConditions returns the condition selecting each chunk. Ranges are
+// half-open, so a boundary value belongs to exactly one chunk; the first
+// chunk is unbounded below and takes NULL, the last is unbounded above.
+func chunkConditions(column string, boundaries []string) []string {
+	conditions := make([]string, 0, len(boundaries)+1)
+	conditions = append(conditions, fmt.Sprintf("(%s < %s OR %s IS NULL)", column, boundaries[0], column))
+	for i := 1; i < len(boundaries); i++ {
+		conditions = append(conditions, fmt.Sprintf("(%s >= %s AND %s < %s)", column, boundaries[i-1], column, boundaries[i]))
+	}
+	return append(conditions, fmt.Sprintf("(%s >= %s)", column, boundaries[len(boundaries)-1]))
+}
+
+// applyChunk restricts query to one chunk. A query containing {{chunk}} gets
+// the condition substituted; otherwise it is wrapped as
+// SELECT * FROM (<query>) WHERE <condition>.
+func applyChunk(query string, condition string) string {
+	if strings.Contains(query, chunkPlaceholder) {
+		return strings.ReplaceAll(query, chunkPlaceholder, condition)
+	}
+	return "SELECT * FROM (" + trimStatement(query) + ") AS chunk_source WHERE " + condition
+}
+
+// fetchChunks runs query once per chunk condition, up to workers at a time,
+// and concatenates the results in chunk order. execute is given the index of
+// the worker running the statement, so each worker can keep its own
+// connection. The first failing chunk cancels the others.
+func fetchChunks(ctx context.Context, host string, query string, conditions []string, workers int,
+	execute func(ctx context.Context, worker int, chunk int, statement string) (*database.QueryResult, error)) (*database.QueryResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]*database.QueryResult, len(conditions))
+	var firstErr error
+	var errOnce sync.Once
+	chunks := make(chan int)
+	var wg sync.WaitGroup
+	for worker := range min(workers, len(conditions)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				started := time.Now()
+				result, err := execute(ctx, worker, chunk, applyChunk(query, conditions[chunk]))
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("chunk %d of %d %s: %w", chunk+1, len(conditions), conditions[chunk], err)
+						cancel()
+					})
+					continue
+				}
+				results[chunk] = result
+				logging.Debugf("Fetched chunk %d of %d from %s: %d rows in %v", chunk+1, len(conditions), host,
+					len(result.Rows), time.Since(started).Round(time.Millisecond))
+			}
+		}()
+	}
+dispatch:
+	for chunk := range conditions {
+		select {
+		case chunks <- chunk:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(chunks)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	combined := results[0]
+	for chunk, result := range results[1:] {
+		if len(result.Columns) != len(combined.Columns) {
+			return nil, fmt.Errorf("chunk %d of %d returned %d columns, the first chunk %d", chunk+2, len(conditions), len(result.Columns), len(combined.Columns))
+		}
+		combined.Rows = append(combined.Rows, result.Rows...)
+	}
+	return combined, nil
+}
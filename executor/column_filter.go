@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"datacollector/database"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// columnFilterRegexPrefix marks a column_filter written as a regular expression
+const columnFilterRegexPrefix = "re:"
+
+// CompileColumnFilter parses a column_filter: "re:<expr>" is a regular
+// expression that must match part of a column name (anchor it with ^ and $
+// for whole names), anything else is a comma-separated list of glob patterns
+// (path.Match syntax) of which one must match the whole name. Matching is
+// case-sensitive.
+func CompileColumnFilter(pattern string) (func(string) bool, error) {
+	if expr, ok := strings.CutPrefix(pattern, columnFilterRegexPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid column_filter regular expression: %w", err)
+		}
+		return re.MatchString, nil
+	}
+
+	var globs []string
+	for _, glob := range strings.Split(pattern, ",") {
+		if glob = strings.TrimSpace(glob); glob == "" {
+			continue
+		}
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid column_filter pattern %q: %w", glob, err)
+		}
+		globs = append(globs, glob)
+	}
+	if len(globs) == 0 {
+		return nil, fmt.Errorf("column_filter %q has no patterns", pattern)
+	}
+	return func(column string) bool {
+		for _, glob := range globs {
+			if matched, _ := path.Match(glob, column); matched {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// filterColumns keeps only the columns of result whose names match, with
+// their types and row values. Matching nothing is an error, since an output
+// without columns is almost certainly a mistyped pattern.
+func filterColumns(result *database.QueryResult, pattern string) error {
+	match, err := CompileColumnFilter(pattern)
+	if err != nil {
+		return err
+	}
+
+	var keep []int
+	for i, column := range result.Columns {
+		if match(column) {
+			keep = append(keep, i)
+		}
+	}
+	if len(keep) == 0 {
+		return fmt.Errorf("column_filter %q matches none of the columns %v", pattern, result.Columns)
+	}
+	if len(keep) == len(result.Columns) {
+		return nil
+	}
+	pickColumns(result, keep)
+	return nil
+}
+
+// pickColumns narrows result down to the columns at the keep positions, in
+// that order, with their types and row values
+func pickColumns(result *database.QueryResult, keep []int) {
+	pick := func(values []string) []string {
+		picked := make([]string, len(keep))
+		for j, i := range keep {
+			if i < len(values) {
+				picked[j] = values[i]
+			}
+		}
+		return picked
+	}
+	result.Columns = pick(result.Columns)
+	result.ColumnTypes = pick(result.ColumnTypes)
+	rows := make([][]string, len(result.Rows))
+	for i, row := range result.Rows {
+		rows[i] = pick(row)
+	}
+	result.Rows = rows
+}
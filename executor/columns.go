@@ -0,0 +1,307 @@
+package executor
+
+import (
+	"datacollector/csv"
+	"datacollector/database"
+	"datacollector/logging"
+	"datacollector/models"
+	"datacollector/transform"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// processResult applies the workload's column-level settings to one target's
+// result before it is aggregated or written. Column names in the workload
+// refer to the query's own column names (after disambiguate_columns);
+// aliasing is applied last. With quiet set the warnings about the result's
+// columns (rather than its values) are left out, for the later batches of a
+// streamed result that already reported them.
+func processResult(host string, result *database.QueryResult, workload *models.Workload, quiet bool) (*database.QueryResult, error) {
+	processed := *result
+
+	// Make repeated names (e.g. "id" from both sides of a join) unique first,
+	// so every later setting and every sink sees distinct columns
+	if columns, renamed := csv.UniqueHeaders(processed.Columns); len(renamed) > 0 {
+		if workload.DisambiguateColumns {
+			logging.Debugf("Renamed duplicate columns on %s: %s", host, strings.Join(renamed, ", "))
+			processed.Columns = columns
+		} else if !quiet {
+			logging.Warnf("Warning: %s returned duplicate column names; JSON and HTTP output keep only one of each and SQLite tables fail (set disambiguate_columns to rename them: %s)",
+				host, strings.Join(renamed, ", "))
+		}
+	}
+
+	if len(workload.ColumnTransforms) > 0 {
+		rows, err := transformColumns(processed.Columns, processed.Rows, workload.ColumnTransforms, workload.NullSentinel(), quiet)
+		if err != nil {
+			return nil, fmt.Errorf("column transforms on %s: %w", host, err)
+		}
+		processed.Rows = rows
+	}
+
+	if len(workload.ColumnCasts) > 0 {
+		if err := castColumns(host, &processed, workload, quiet); err != nil {
+			return nil, fmt.Errorf("column casts on %s: %w", host, err)
+		}
+	}
+
+	if len(workload.ComputedColumns) > 0 {
+		if err := addComputedColumns(host, &processed, workload); err != nil {
+			return nil, fmt.Errorf("computed columns on %s: %w", host, err)
+		}
+	}
+
+	if len(workload.NullDefaults) > 0 {
+		processed.Rows = defaultNulls(processed.Columns, processed.Rows, workload.NullDefaults, workload.NullSentinel(), quiet)
+	}
+
+	if workload.ColumnFilter != "" {
+		if err := filterColumns(&processed, workload.ColumnFilter); err != nil {
+			return nil, fmt.Errorf("column filter on %s: %w", host, err)
+		}
+	}
+
+	if len(workload.ColumnsOrder) > 0 {
+		if err := orderColumns(&processed, workload.ColumnsOrder, workload.DropUnorderedColumns, workload.StrictColumnsOrder, quiet); err != nil {
+			return nil, fmt.Errorf("columns order on %s: %w", host, err)
+		}
+	}
+
+	// The PII safety net: denied columns never reach a sink, whatever the
+	// query or the settings above selected. Their aliases no longer apply.
+	aliases := workload.ColumnAliases
+	if len(workload.DenyColumns) > 0 {
+		dropped, err := denyColumns(&processed, workload)
+		if err != nil {
+			return nil, fmt.Errorf("deny columns on %s: %w", host, err)
+		}
+		if len(dropped) > 0 {
+			logging.Debugf("Dropped denied columns on %s: %s", host, strings.Join(dropped, ", "))
+			aliases = make(map[string]string, len(workload.ColumnAliases))
+			for column, alias := range workload.ColumnAliases {
+				aliases[column] = alias
+			}
+			for _, column := range dropped {
+				delete(aliases, column)
+			}
+		}
+	}
+
+	if len(aliases) > 0 {
+		columns, err := aliasColumns(processed.Columns, aliases, workload.StrictAliases, quiet)
+		if err != nil {
+			return nil, fmt.Errorf("column aliases on %s: %w", host, err)
+		}
+		processed.Columns = columns
+	}
+
+	if workload.QueryNameColumn != "" || workload.CollectedAtColumn != "" {
+		if err := addMetadataColumns(&processed, workload); err != nil {
+			return nil, fmt.Errorf("metadata columns on %s: %w", host, err)
+		}
+	}
+
+	if len(workload.StaticColumns) > 0 {
+		if err := addStaticColumns(&processed, workload.StaticColumns); err != nil {
+			return nil, fmt.Errorf("static columns on %s: %w", host, err)
+		}
+	}
+
+	return &processed, nil
+}
+
+// addStaticColumns appends the static_columns to result, sorted by name so
+// the header is the same for every target, with their constant values on
+// every row
+func addStaticColumns(result *database.QueryResult, static map[string]string) error {
+	names := make([]string, 0, len(static))
+	for name := range static {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	existing := columnIndex(result.Columns)
+	values := make([]string, len(names))
+	types := make([]string, len(names))
+	for i, name := range names {
+		if _, ok := existing[name]; ok {
+			return fmt.Errorf("column %q is already in the query result", name)
+		}
+		values[i] = static[name]
+		types[i] = "TEXT"
+	}
+
+	columnTypes := result.ColumnTypes
+	if len(columnTypes) < len(result.Columns) {
+		columnTypes = append(append([]string(nil), columnTypes...), make([]string, len(result.Columns)-len(columnTypes))...)
+	}
+	result.ColumnTypes = append(append([]string(nil), columnTypes...), types...)
+	result.Columns = append(append([]string(nil), result.Columns...), names...)
+
+	rows := make([][]string, len(result.Rows))
+	for i, row := range result.Rows {
+		rows[i] = append(append(make([]string, 0, len(row)+len(values)), row...), values...)
+	}
+	result.Rows = rows
+	return nil
+}
+
+// addMetadataColumns prepends the query_name_column and collected_at_column
+// columns, in that order, to result. Every row gets the same query label and
+// run start time (RFC 3339, UTC), so the header stays stable across targets.
+func addMetadataColumns(result *database.QueryResult, workload *models.Workload) error {
+	var names, types, values []string
+	if workload.QueryNameColumn != "" {
+		names = append(names, workload.QueryNameColumn)
+		types = append(types, "TEXT")
+		values = append(values, workload.QueryName)
+	}
+	if workload.CollectedAtColumn != "" {
+		names = append(names, workload.CollectedAtColumn)
+		types = append(types, "TIMESTAMP")
+		values = append(values, workload.CollectedAt.UTC().Format(time.RFC3339))
+	}
+
+	existing := columnIndex(result.Columns)
+	for _, name := range names {
+		if _, ok := existing[name]; ok {
+			return fmt.Errorf("column %q is already in the query result", name)
+		}
+	}
+
+	columnTypes := result.ColumnTypes
+	if len(columnTypes) < len(result.Columns) {
+		columnTypes = append(append([]string(nil), columnTypes...), make([]string, len(result.Columns)-len(columnTypes))...)
+	}
+	result.Columns = append(append([]string(nil), names...), result.Columns...)
+	result.ColumnTypes = append(types, columnTypes...)
+
+	rows := make([][]string, len(result.Rows))
+	for i, row := range result.Rows {
+		rows[i] = append(append(make([]string, 0, len(values)+len(row)), values...), row...)
+	}
+	result.Rows = rows
+	return nil
+}
+
+// aliasColumns returns a copy of columns with names remapped through aliases.
+// Unmapped columns pass through; an alias for a missing column is an error
+// in strict mode and a warning (unless quiet) otherwise.
+func aliasColumns(columns []string, aliases map[string]string, strict bool, quiet bool) ([]string, error) {
+	present := make(map[string]bool, len(columns))
+	renamed := make([]string, len(columns))
+	for i, column := range columns {
+		present[column] = true
+		if alias, ok := aliases[column]; ok {
+			renamed[i] = alias
+		} else {
+			renamed[i] = column
+		}
+	}
+
+	var missing []string
+	for original := range aliases {
+		if !present[original] {
+			missing = append(missing, original)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing) // Deterministic messages
+		if strict {
+			return nil, fmt.Errorf("aliased column(s) not in result: %v", missing)
+		}
+		if !quiet {
+			logging.Warnf("Warning: column_aliases reference column(s) not in result: %v", missing)
+		}
+	}
+
+	return renamed, nil
+}
+
+// transformColumns applies the named transforms to the configured columns of
+// every row. NULL values are left as the sentinel. Rows are copied, not modified.
+// A transform for a missing column is a warning, unless quiet.
+func transformColumns(columns []string, rows [][]string, transforms map[string][]string, nullValue string, quiet bool) ([][]string, error) {
+	index := columnIndex(columns)
+
+	// Resolve each configured column to its position and transform chain
+	byPosition := make(map[int]transform.Func, len(transforms))
+	for column, names := range transforms {
+		i, ok := index[column]
+		if !ok {
+			if !quiet {
+				logging.Warnf("Warning: column_transforms reference column %q not in result", column)
+			}
+			continue
+		}
+		fn, err := transform.Chain(names)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %w", column, err)
+		}
+		byPosition[i] = fn
+	}
+	if len(byPosition) == 0 {
+		return rows, nil
+	}
+
+	transformed := make([][]string, len(rows))
+	for r, row := range rows {
+		newRow := make([]string, len(row))
+		copy(newRow, row)
+		for i, fn := range byPosition {
+			if i < len(newRow) && newRow[i] != nullValue {
+				newRow[i] = fn(newRow[i])
+			}
+		}
+		transformed[r] = newRow
+	}
+	return transformed, nil
+}
+
+// defaultNulls replaces the NULL values of the null_defaults columns with
+// their defaults; other columns keep the sentinel. Rows are copied, not
+// modified. A default for a missing column is a warning, unless quiet.
+func defaultNulls(columns []string, rows [][]string, defaults map[string]string, nullValue string, quiet bool) [][]string {
+	index := columnIndex(columns)
+
+	byPosition := make(map[int]string, len(defaults))
+	for column, value := range defaults {
+		i, ok := index[column]
+		if !ok {
+			if !quiet {
+				logging.Warnf("Warning: null_defaults reference column %q not in result", column)
+			}
+			continue
+		}
+		byPosition[i] = value
+	}
+	if len(byPosition) == 0 {
+		return rows
+	}
+
+	defaulted := make([][]string, len(rows))
+	for r, row := range rows {
+		newRow := make([]string, len(row))
+		copy(newRow, row)
+		for i, value := range byPosition {
+			if i < len(newRow) && newRow[i] == nullValue {
+				newRow[i] = value
+			}
+		}
+		defaulted[r] = newRow
+	}
+	return defaulted
+}
+
+// columnIndex maps each column name to its first position
+func columnIndex(columns []string) map[string]int {
+	index := make(map[string]int, len(columns))
+	for i, column := range columns {
+		if _, exists := index[column]; !exists {
+			index[column] = i
+		}
+	}
+	return index
+}
@@ -0,0 +1,64 @@
+package executor
+
+import (
+	"datacollector/database"
+	"datacollector/logging"
+	"fmt"
+)
+
+// orderColumns moves the columns named in order to the front of result, in
+// that order, followed by the unlisted columns in their query order, or
+// without them when dropUnlisted is set. A listed column the result lacks is
+// an error when strict and a warning (unless quiet) otherwise.
+func orderColumns(result *database.QueryResult, order []string, dropUnlisted bool, strict bool, quiet bool) error {
+	index := columnIndex(result.Columns)
+
+	var positions []int
+	var missing []string
+	listed := make(map[int]bool, len(order))
+	for _, column := range order {
+		i, ok := index[column]
+		if !ok {
+			missing = append(missing, column)
+			continue
+		}
+		positions = append(positions, i)
+		listed[i] = true
+	}
+	if len(missing) > 0 {
+		if strict {
+			return fmt.Errorf("ordered column(s) not in result: %v", missing)
+		}
+		if !quiet {
+			logging.Warnf("Warning: columns_order references column(s) not in result: %v", missing)
+		}
+	}
+	if !dropUnlisted {
+		for i := range result.Columns {
+			if !listed[i] {
+				positions = append(positions, i)
+			}
+		}
+	}
+	if len(positions) == 0 {
+		return fmt.Errorf("columns_order leaves none of the columns %v", result.Columns)
+	}
+
+	pick := func(values []string) []string {
+		picked := make([]string, len(positions))
+		for j, i := range positions {
+			if i < len(values) {
+				picked[j] = values[i]
+			}
+		}
+		return picked
+	}
+	result.Columns = pick(result.Columns)
+	result.ColumnTypes = pick(result.ColumnTypes)
+	rows := make([][]string, len(result.Rows))
+	for i, row := range result.Rows {
+		rows[i] = pick(row)
+	}
+	result.Rows = rows
+	return nil
+}
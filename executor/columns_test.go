@@ -0,0 +1,84 @@
+package executor
+
+import (
+	"fmt"
+	"testing"
+
+	"datacollector/database"
+	"datacollector/models"
+)
+
+// selfJoin is the result of SELECT * FROM employees e JOIN employees m ON e.manager_id = m.id
+func selfJoin() *database.QueryResult {
+	return &database.QueryResult{
+		Columns:     []string{"id", "name", "manager_id", "id", "name", "manager_id"},
+		ColumnTypes: []string{"INT", "TEXT", "INT", "INT", "TEXT", "INT"},
+		Rows:        [][]string{{"2", "bo", "1", "1", "al", "NULL"}},
+	}
+}
+
+func TestDisambiguateSelfJoin(t *testing.T) {
+	workload := &models.Workload{
+		DisambiguateColumns: true,
+		ColumnAliases:       map[string]string{"name_2": "manager_name"},
+	}
+	processed, err := processResult("db1", selfJoin(), workload, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"id", "name", "manager_id", "id_2", "manager_name", "manager_id_2"}
+	if fmt.Sprint(processed.Columns) != fmt.Sprint(want) {
+		t.Errorf("Columns = %v, want %v", processed.Columns, want)
+	}
+	if fmt.Sprint(processed.Rows) != fmt.Sprint(selfJoin().Rows) {
+		t.Errorf("Rows = %v, want them unchanged", processed.Rows)
+	}
+
+	// Without the setting the duplicates are kept, with a warning
+	processed, err = processResult("db1", selfJoin(), &models.Workload{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(processed.Columns) != fmt.Sprint(selfJoin().Columns) {
+		t.Errorf("Columns = %v, want the duplicates kept", processed.Columns)
+	}
+}
+
+func TestNullDefaults(t *testing.T) {
+	result := &database.QueryResult{
+		Columns:     []string{"id", "country", "discount", "note"},
+		ColumnTypes: []string{"INT", "TEXT", "DECIMAL", "TEXT"},
+		Rows: [][]string{
+			{"1", "NULL", "NULL", "NULL"},
+			{"2", "PT", "0.1", "NULL"},
+			{"NULL", "NULL", "0.2", "x"},
+		},
+	}
+	workload := &models.Workload{
+		NullDefaults: map[string]string{"country": "unknown", "discount": "0", "missing": "?"},
+	}
+	processed, err := processResult("db1", result, workload, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// id and note have no default, so they keep the sentinel
+	want := [][]string{
+		{"1", "unknown", "0", "NULL"},
+		{"2", "PT", "0.1", "NULL"},
+		{"NULL", "unknown", "0.2", "x"},
+	}
+	if fmt.Sprint(processed.Rows) != fmt.Sprint(want) {
+		t.Errorf("Rows = %v, want %v", processed.Rows, want)
+	}
+	if result.Rows[0][1] != "NULL" {
+		t.Errorf("processResult modified the original rows: %v", result.Rows)
+	}
+}
+
+func TestNullDefaultsCustomSentinel(t *testing.T) {
+	rows := defaultNulls([]string{"a", "b"}, [][]string{{"", "NULL"}}, map[string]string{"a": "0", "b": "0"}, "", false)
+	// Only the configured sentinel counts as NULL; the text "NULL" is data
+	if want := [][]string{{"0", "NULL"}}; fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("defaultNulls() = %v, want %v", rows, want)
+	}
+}
@@ -0,0 +1,249 @@
+package executor
+
+import (
+	"datacollector/database"
+	"datacollector/logging"
+	"datacollector/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/ast"
+	"github.com/expr-lang/expr/file"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Ways of handling a computed column expression that fails on a row
+const (
+	ComputedErrorsFail = "fail" // The target fails (default)
+	ComputedErrorsSkip = "skip" // The row is dropped and a warning is logged
+)
+
+// envVariable is the expr-lang name of the whole row, used for columns that
+// aren't valid identifiers: $env["order-id"]
+const envVariable = "$env"
+
+// computedProgram is a compiled computed column expression and the
+// variables it reads
+type computedProgram struct {
+	program   *vm.Program
+	variables []string
+}
+
+var computedPrograms sync.Map // map[string]*computedProgram, keyed by expression
+
+// compileComputed compiles an expression once and caches it for every target.
+// Expressions only see the row they are given and expr-lang's built-in
+// functions, which have no file, network or process access.
+func compileComputed(expression string) (*computedProgram, error) {
+	if cached, ok := computedPrograms.Load(expression); ok {
+		return cached.(*computedProgram), nil
+	}
+	program, err := expr.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	compiled := &computedProgram{program: program, variables: expressionVariables(program.Node())}
+	computedPrograms.Store(expression, compiled)
+	return compiled, nil
+}
+
+// variableCollector gathers the identifiers an expression reads, leaving out
+// the names bound by let and the functions it calls
+type variableCollector struct {
+	identifiers []*ast.IdentifierNode
+	bound       map[string]bool
+}
+
+func (c *variableCollector) Visit(node *ast.Node) {
+	switch n := (*node).(type) {
+	case *ast.IdentifierNode:
+		c.identifiers = append(c.identifiers, n)
+	case *ast.VariableDeclaratorNode:
+		c.bound[n.Name] = true
+	case *ast.CallNode:
+		if callee, ok := n.Callee.(*ast.IdentifierNode); ok {
+			c.bound[callee.Value] = true
+		}
+	}
+}
+
+// expressionVariables returns the sorted, distinct row variables of an
+// expression's tree
+func expressionVariables(node ast.Node) []string {
+	collector := &variableCollector{bound: map[string]bool{envVariable: true}}
+	ast.Walk(&node, collector)
+
+	seen := make(map[string]bool)
+	var variables []string
+	for _, identifier := range collector.identifiers {
+		if name := identifier.Value; !collector.bound[name] && !seen[name] {
+			seen[name] = true
+			variables = append(variables, name)
+		}
+	}
+	sort.Strings(variables)
+	return variables
+}
+
+// ValidateComputedColumns checks the computed column names, compiles their
+// expressions and checks computed_column_errors
+func ValidateComputedColumns(columns []models.ComputedColumn, mode string) error {
+	names := make(map[string]bool, len(columns))
+	for i, column := range columns {
+		if column.Name == "" {
+			return fmt.Errorf("entry %d: missing name", i+1)
+		}
+		if names[column.Name] {
+			return fmt.Errorf("column %q is listed more than once", column.Name)
+		}
+		names[column.Name] = true
+		if strings.TrimSpace(column.Expr) == "" {
+			return fmt.Errorf("column %q: missing expr", column.Name)
+		}
+		if _, err := compileComputed(column.Expr); err != nil {
+			return fmt.Errorf("column %q: %w", column.Name, err)
+		}
+	}
+	if mode != "" && mode != ComputedErrorsFail && mode != ComputedErrorsSkip {
+		return fmt.Errorf("invalid computed_column_errors %q (supported: %s, %s)", mode, ComputedErrorsFail, ComputedErrorsSkip)
+	}
+	return nil
+}
+
+// addComputedColumns appends the computed_columns to result, in the order
+// listed. Each expression sees the row's columns as strings (NULL as nil)
+// and the computed columns before it. A failing expression fails the target,
+// or with computed_column_errors "skip" drops the row with a warning.
+func addComputedColumns(host string, result *database.QueryResult, workload *models.Workload) error {
+	nullValue := workload.NullSentinel()
+	available := columnIndex(result.Columns)
+	programs := make([]*computedProgram, len(workload.ComputedColumns))
+	for i, column := range workload.ComputedColumns {
+		if _, exists := available[column.Name]; exists {
+			return fmt.Errorf("column %q is already in the query result", column.Name)
+		}
+		program, err := compileComputed(column.Expr)
+		if err != nil {
+			return fmt.Errorf("column %q: %w", column.Name, err)
+		}
+		var missing []string
+		for _, variable := range program.variables {
+			if _, ok := available[variable]; !ok {
+				missing = append(missing, variable)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("column %q: expression references unknown column(s) %s", column.Name, strings.Join(missing, ", "))
+		}
+		programs[i] = program
+		available[column.Name] = len(available)
+	}
+
+	skipped := 0
+	var first string
+	rows := make([][]string, 0, len(result.Rows))
+	for r, row := range result.Rows {
+		env := make(map[string]any, len(row)+len(programs))
+		for i, column := range result.Columns {
+			if _, exists := env[column]; exists || i >= len(row) {
+				continue
+			}
+			if row[i] == nullValue {
+				env[column] = nil
+			} else {
+				env[column] = row[i]
+			}
+		}
+
+		newRow := append(make([]string, 0, len(row)+len(programs)), row...)
+		var rowErr error
+		for i, column := range workload.ComputedColumns {
+			value, err := expr.Run(programs[i].program, env)
+			if err != nil {
+				rowErr = fmt.Errorf("row %d, column %q: %w", r+1, column.Name, runtimeError(err))
+				break
+			}
+			env[column.Name] = value
+			newRow = append(newRow, formatComputed(value, nullValue, workload.BoolFormat))
+		}
+		if rowErr != nil {
+			if workload.ComputedColumnErrors != ComputedErrorsSkip {
+				return rowErr
+			}
+			skipped++
+			if first == "" {
+				first = rowErr.Error()
+			}
+			continue
+		}
+		rows = append(rows, newRow)
+	}
+	if skipped > 0 {
+		logging.Warnf("Warning: %d row(s) on %s were skipped because a computed column failed (first: %s)", skipped, host, first)
+	}
+
+	columnTypes := result.ColumnTypes
+	if len(columnTypes) < len(result.Columns) {
+		columnTypes = append(append([]string(nil), columnTypes...), make([]string, len(result.Columns)-len(columnTypes))...)
+	}
+	result.ColumnTypes = append([]string(nil), columnTypes...)
+	result.Columns = append([]string(nil), result.Columns...)
+	for _, column := range workload.ComputedColumns {
+		result.Columns = append(result.Columns, column.Name)
+		result.ColumnTypes = append(result.ColumnTypes, "TEXT")
+	}
+	result.Rows = rows
+	return nil
+}
+
+// runtimeError drops the source snippet expr-lang adds to its errors, which
+// spans several lines, keeping warnings and target errors on one line
+func runtimeError(err error) error {
+	var exprErr *file.Error
+	if errors.As(err, &exprErr) {
+		return fmt.Errorf("%s (%d:%d)", exprErr.Message, exprErr.Line, exprErr.Column+1)
+	}
+	return err
+}
+
+// formatComputed writes an expression's result the way the drivers write
+// query values: nil as the NULL text, booleans in bool_format, numbers
+// without an exponent, times as RFC 3339 and lists or maps as JSON
+func formatComputed(value any, nullValue string, boolFormat string) string {
+	switch v := value.(type) {
+	case nil:
+		return nullValue
+	case string:
+		return v
+	case bool:
+		if boolFormat == database.BoolFormatText {
+			return strconv.FormatBool(v)
+		}
+		if v {
+			return "1"
+		}
+		return "0"
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	case []any, map[string]any:
+		if encoded, err := json.Marshal(v); err == nil {
+			return string(encoded)
+		}
+	}
+	return fmt.Sprint(value)
+}
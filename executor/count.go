@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"datacollector/database"
+	"datacollector/models"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Columns of the host,row_count result each target yields under count_only
+const (
+	CountHostColumn = "host"
+	CountColumn     = "row_count"
+)
+
+// countQuery wraps query so the server only returns its number of rows. The
+// query goes on lines of its own so a trailing "--" comment can't swallow
+// the closing parenthesis.
+func countQuery(query string) string {
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")
+	return "SELECT COUNT(*) AS " + CountColumn + " FROM (\n" + query + "\n) AS count_only"
+}
+
+// countResult turns the single-row result of a countQuery into the
+// host,row_count row written for the target, returning the count as well
+func countResult(host string, result *database.QueryResult) (*database.QueryResult, int, error) {
+	if len(result.Rows) != 1 || len(result.Rows[0]) != 1 {
+		return nil, 0, fmt.Errorf("%w on %s: count_only expected a single count, got %d row(s)", ErrQueryFailed, host, len(result.Rows))
+	}
+	count, err := strconv.Atoi(result.Rows[0][0])
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w on %s: count_only got a non-numeric count %q", ErrQueryFailed, host, result.Rows[0][0])
+	}
+	return &database.QueryResult{
+		Columns:     []string{CountHostColumn, CountColumn},
+		ColumnTypes: []string{"TEXT", "BIGINT"},
+		Rows:        [][]string{{host, strconv.Itoa(count)}},
+	}, count, nil
+}
+
+// workloadQuery returns the query sent to every target: the workload's own,
+// or its count_only wrapper
+func workloadQuery(workload *models.Workload) string {
+	if workload.CountOnly {
+		return countQuery(workload.Query)
+	}
+	return workload.Query
+}
@@ -0,0 +1,92 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Values for Workload.DedupeKeep
+const (
+	DedupeKeepFirst = "first"
+	DedupeKeepLast  = "last"
+)
+
+// dedupeKeySeparator joins key values; it can't occur in text from the drivers
+const dedupeKeySeparator = "\x00"
+
+// deduper drops aggregated rows whose key columns repeat an earlier row's
+type deduper struct {
+	keys     []string // Output column names forming the key
+	keep     string   // DedupeKeepFirst or DedupeKeepLast
+	keepLast bool
+
+	seen       map[string]int // Key -> index of the kept row in the aggregate
+	duplicates int
+}
+
+// newDeduper builds a deduper for the key columns, given by their query
+// column names and mapped through aliases to the aggregated output names
+func newDeduper(keys []string, keep string, aliases map[string]string) *deduper {
+	if keep == "" {
+		keep = DedupeKeepFirst
+	}
+	outputKeys := make([]string, len(keys))
+	for i, key := range keys {
+		if alias, ok := aliases[key]; ok {
+			outputKeys[i] = alias
+		} else {
+			outputKeys[i] = key
+		}
+	}
+	return &deduper{
+		keys:     outputKeys,
+		keep:     keep,
+		keepLast: keep == DedupeKeepLast,
+		seen:     make(map[string]int),
+	}
+}
+
+// filter returns the rows to append to aggregated, in order. With keepLast a
+// duplicate replaces the row kept so far at its original position (in
+// aggregated or among the returned rows) instead of being dropped.
+func (d *deduper) filter(columns []string, rows [][]string, aggregated [][]string) ([][]string, error) {
+	index := columnIndex(columns)
+	positions := make([]int, len(d.keys))
+	for i, key := range d.keys {
+		position, ok := index[key]
+		if !ok {
+			return nil, fmt.Errorf("dedupe key column %q not in result", key)
+		}
+		positions[i] = position
+	}
+
+	base := len(aggregated)
+	var kept [][]string
+	values := make([]string, len(positions))
+	for _, row := range rows {
+		for i, position := range positions {
+			if position < len(row) {
+				values[i] = row[position]
+			} else {
+				values[i] = ""
+			}
+		}
+		key := strings.Join(values, dedupeKeySeparator)
+
+		at, duplicate := d.seen[key]
+		if !duplicate {
+			d.seen[key] = base + len(kept)
+			kept = append(kept, row)
+			continue
+		}
+		d.duplicates++
+		if d.keepLast {
+			if at >= base {
+				kept[at-base] = row
+			} else {
+				aggregated[at] = row
+			}
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,51 @@
+package executor
+
+import (
+	"datacollector/database"
+	"datacollector/models"
+	"fmt"
+	"strings"
+)
+
+// deniedColumns returns the set of deny_columns, lower-cased
+func deniedColumns(columns []string) map[string]bool {
+	denied := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		denied[strings.ToLower(strings.TrimSpace(column))] = true
+	}
+	return denied
+}
+
+// IsDeniedColumn reports whether name is one of the deny_columns, ignoring case
+func IsDeniedColumn(name string, denyColumns []string) bool {
+	return deniedColumns(denyColumns)[strings.ToLower(name)]
+}
+
+// denyColumns drops the deny_columns from result, whichever the query
+// selected them by: a column is denied when its query name or its
+// column_aliases output name matches, ignoring case. With strict set a
+// denied column fails the target instead. It returns the columns dropped.
+func denyColumns(result *database.QueryResult, workload *models.Workload) ([]string, error) {
+	denied := deniedColumns(workload.DenyColumns)
+
+	var keep []int
+	var dropped []string
+	for i, column := range result.Columns {
+		if denied[strings.ToLower(column)] || denied[strings.ToLower(workload.OutputColumn(column))] {
+			dropped = append(dropped, column)
+			continue
+		}
+		keep = append(keep, i)
+	}
+	if len(dropped) == 0 {
+		return nil, nil
+	}
+	if workload.StrictDenyColumns {
+		return nil, fmt.Errorf("result contains denied column(s) %s (strict_deny_columns)", strings.Join(dropped, ", "))
+	}
+	if len(keep) == 0 {
+		return nil, fmt.Errorf("deny_columns removes every column of the result (%s)", strings.Join(dropped, ", "))
+	}
+	pickColumns(result, keep)
+	return dropped, nil
+}
@@ -0,0 +1,67 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"datacollector/database"
+	"datacollector/models"
+	"datacollector/output"
+)
+
+// customers has a column denied by its query name and one denied by its alias
+func customers() *database.QueryResult {
+	return &database.QueryResult{
+		Columns:     []string{"id", "Email", "ssn_raw", "city"},
+		ColumnTypes: []string{"INT", "TEXT", "TEXT", "TEXT"},
+		Rows:        [][]string{{"1", "al@example.com", "123-45-6789", "Lisbon"}},
+	}
+}
+
+func TestDenyColumnsAbsentFromOutput(t *testing.T) {
+	workload := &models.Workload{
+		OutputDir:     t.TempDir(),
+		OutputFile:    "customers",
+		DenyColumns:   []string{"email", "SSN"},
+		ColumnAliases: map[string]string{"ssn_raw": "ssn"},
+	}
+	processed, err := processResult("db1", customers(), workload, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formats := []string{models.OutputFormatCSV, models.OutputFormatTSV, models.OutputFormatJSON}
+	sink := output.NewFormatsSink(workload.WriteOptions(), formats)
+	if err := sink.Write(processed); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	files := sink.Files()
+	if len(files) != len(formats) {
+		t.Fatalf("Files() = %v, want one per format", files)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, denied := range []string{"Email", "al@example.com", "ssn", "123-45-6789"} {
+			if strings.Contains(string(data), denied) {
+				t.Errorf("%s contains denied %q:\n%s", filepath.Base(file), denied, data)
+			}
+		}
+		for _, kept := range []string{"city", "Lisbon"} {
+			if !strings.Contains(string(data), kept) {
+				t.Errorf("%s lacks %q:\n%s", filepath.Base(file), kept, data)
+			}
+		}
+	}
+}
+
+func TestStrictDenyColumns(t *testing.T) {
+	workload := &models.Workload{DenyColumns: []string{"email"}, StrictDenyColumns: true}
+	if _, err := processResult("db1", customers(), workload, false); err == nil {
+		t.Fatal("processResult() kept going with a denied column under strict_deny_columns")
+	}
+}
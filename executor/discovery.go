@@ -0,0 +1,75 @@
+package executor
+
+import (
+	"context"
+	"datacollector/database"
+	"datacollector/logging"
+	"datacollector/models"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxDiscoveredTargets caps discovery when max_targets isn't set
+const DefaultMaxDiscoveredTargets = 1000
+
+// ErrNoTargetsDiscovered is returned when the discovery query yields no hosts
+var ErrNoTargetsDiscovered = errors.New("discovery query returned no targets")
+
+// DiscoverTargets runs the discovery query on its bootstrap host and returns
+// the first column of every row as a target, in row order. Empty and NULL
+// values and repeated hosts are skipped, and the list is cut at the
+// configured maximum with a warning.
+func DiscoverTargets(ctx context.Context, discovery *models.Discovery, queryTimeout models.Duration, dbConfig database.Config) ([]string, error) {
+	if discovery.Database != "" {
+		dbConfig.Database = discovery.Database
+	}
+
+	db, _, servedBy, err := connectCandidates(ctx, discovery.Host, dbConfig)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	defer database.Close(db)
+
+	queryCtx := ctx
+	if queryTimeout.Duration > 0 {
+		var cancel context.CancelFunc
+		queryCtx, cancel = database.WithQueryTimeout(ctx, queryTimeout.Duration)
+		defer cancel()
+	}
+	logging.Debugf("Executing discovery query on %s: %s", servedBy, discovery.Query)
+	nullValue := "NULL"
+	result, err := database.ExecuteRawQuery(queryCtx, db, discovery.Query, database.QueryOptions{NullValue: nullValue})
+	if err != nil {
+		return nil, fmt.Errorf("discovery query on %s failed: %w", servedBy, err)
+	}
+
+	limit := discovery.MaxTargets
+	if limit <= 0 {
+		limit = DefaultMaxDiscoveredTargets
+	}
+	var targets []string
+	seen := make(map[string]bool)
+	for _, row := range result.Rows {
+		if len(row) == 0 {
+			continue
+		}
+		host := strings.TrimSpace(row[0])
+		if host == "" || host == nullValue || seen[host] {
+			continue
+		}
+		if len(targets) == limit {
+			logging.Warnf("Warning: discovery returned more than %d targets; using the first %d (raise discovery.max_targets to keep more)",
+				limit, limit)
+			break
+		}
+		seen[host] = true
+		targets = append(targets, host)
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%w on %s", ErrNoTargetsDiscovered, servedBy)
+	}
+	logging.Infof("Discovered %d target(s) on %s: %v", len(targets), servedBy, targets)
+	return targets, nil
+}
@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"context"
+	"datacollector/database"
+	"datacollector/models"
+	"errors"
+	"fmt"
+)
+
+// ErrConnectFailed wraps errors raised while connecting to a target
+var ErrConnectFailed = errors.New("failed to connect")
+
+// ErrQueryFailed wraps errors raised while running the query on a target
+var ErrQueryFailed = errors.New("query execution failed")
+
+// ErrWorkerPanic wraps a panic recovered while processing a target
+var ErrWorkerPanic = errors.New("worker panicked")
+
+// ErrRowExpectation marks a target whose row count violated min_rows or expect_rows
+var ErrRowExpectation = errors.New("row count expectation not met")
+
+// Error categories reported by TargetError.Category
+const (
+	CategoryAuth           = "auth"
+	CategoryConnectTimeout = "connect_timeout"
+	CategoryConnection     = "connection"
+	CategoryTLS            = "tls"
+	CategoryQueryTimeout   = "query_timeout"
+	CategoryQuery          = "query"
+	CategoryRejected       = "rejected_query"
+	CategoryCancelled      = "cancelled"
+	CategoryRowCount       = "row_count"
+	CategoryInitSQL        = "init_sql"
+	CategoryPanic          = "panic"
+	CategoryOther          = "other"
+)
+
+// TargetError records the failure of a single target
+type TargetError struct {
+	Host string
+	Err  error
+}
+
+// Error implements the error interface
+func (e TargetError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap exposes the underlying error to errors.Is/As
+func (e TargetError) Unwrap() error {
+	return e.Err
+}
+
+// Diagnosis returns the classified cause of a connection failure, e.g.
+// database.CauseDNS, or "" when the target failed for another reason
+func (e TargetError) Diagnosis() string {
+	var diagnosed *database.DiagnosedError
+	if errors.As(e.Err, &diagnosed) {
+		return diagnosed.Diagnosis.Cause
+	}
+	return ""
+}
+
+// Category classifies the failure so automation can tell e.g. auth
+// problems from timeouts from SQL errors
+func (e TargetError) Category() string {
+	switch {
+	case database.IsAuthError(e.Err):
+		return CategoryAuth
+	case errors.Is(e.Err, database.ErrConnectTimeout):
+		return CategoryConnectTimeout
+	case errors.Is(e.Err, database.ErrTLSHandshake):
+		return CategoryTLS
+	case errors.Is(e.Err, database.ErrQueryTimeout):
+		return CategoryQueryTimeout
+	case errors.Is(e.Err, database.ErrWriteQuery), errors.Is(e.Err, database.ErrMultipleStatements):
+		return CategoryRejected
+	case errors.Is(e.Err, ErrRowExpectation):
+		return CategoryRowCount
+	case errors.Is(e.Err, context.Canceled), errors.Is(e.Err, context.DeadlineExceeded):
+		return CategoryCancelled
+	case errors.Is(e.Err, ErrWorkerPanic):
+		return CategoryPanic
+	case errors.Is(e.Err, database.ErrInitSQL):
+		return CategoryInitSQL
+	case errors.Is(e.Err, ErrConnectFailed):
+		return CategoryConnection
+	case errors.Is(e.Err, ErrQueryFailed):
+		return CategoryQuery
+	default:
+		return CategoryOther
+	}
+}
+
+// String formats the error with its host and category for logs
+func (e TargetError) String() string {
+	return fmt.Sprintf("[%s] %s: %v", e.Category(), e.Host, e.Err)
+}
+
+// checkRowCount returns an ErrRowExpectation error when rows violates the
+// workload's min_rows or expect_rows settings
+func checkRowCount(host string, rows int, workload *models.Workload) error {
+	if workload.ExpectRows != nil && rows != *workload.ExpectRows {
+		return fmt.Errorf("%w on %s: got %d row(s), expected exactly %d", ErrRowExpectation, host, rows, *workload.ExpectRows)
+	}
+	if workload.MinRows > 0 && rows < workload.MinRows {
+		return fmt.Errorf("%w on %s: got %d row(s), expected at least %d", ErrRowExpectation, host, rows, workload.MinRows)
+	}
+	return nil
+}
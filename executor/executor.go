@@ -2,120 +2,726 @@
 package executor
 
 import (
+	"context"
 	"datacollector/database"
+	"datacollector/logging"
 	"datacollector/models"
+	"datacollector/output"
+	"errors"
 	"fmt"
-	"log"
+	"os"
+	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
 )
 
 // ExecutionResult represents the aggregated results of parallel query execution
 type ExecutionResult struct {
-	Rows       [][]string
-	Columns    []string
-	ErrorCount int
-	HasResults bool
+	Rows        [][]string
+	Columns     []string
+	ColumnTypes []string
+	ErrorCount  int
+	Errors      []TargetError // One entry per failed target, in target order
+	// Warnings lists targets whose result was kept despite a soft failure,
+	// such as a row count expectation with row_check "warn", in target order
+	Warnings []TargetError
+	// SuccessCount is the number of targets whose result was aggregated
+	SuccessCount int
+	HasResults   bool
+
+	// RowCount is the total number of aggregated rows, including spilled ones
+	RowCount int
+	// SpillPath is a temporary CSV (header + rows) holding the aggregate when it
+	// exceeded SpillThreshold; Rows is empty in that case
+	SpillPath string
+
+	// Err is set when the run was aborted, by FailFast or by an aggregation failure
+	Err error
+
+	// Incomplete counts targets that were never dispatched or were aborted by cancellation
+	Incomplete int
+	// Truncated is set when the caller's context deadline ended the run early
+	Truncated bool
+
+	// Watermarks holds the new highest watermark value per successful host
+	Watermarks map[string]string
+
+	// ServedBy maps each successful target to the candidate host that served
+	// it, which differs from the target's first host after a failover
+	ServedBy map[string]string
+
+	// TargetFiles maps each host to its per-target output file (only with PerTargetOutput)
+	TargetFiles map[string]string
+	// TargetBytes maps each host to the size of its per-target output file
+	TargetBytes map[string]int64
+
+	// TargetRows maps each successful target to the number of rows it returned
+	TargetRows map[string]int
+	// TargetTimings maps each successful target to its duration and data size
+	TargetTimings map[string]TargetTiming
+	// EmptyTargets lists the successful targets that returned no rows, in
+	// target order, so data outages stand out from connection problems
+	EmptyTargets []string
+
+	// Groups reports each worker pool when target groups are configured: the
+	// ungrouped targets (Name "") first, then the groups in workload order
+	Groups []GroupStats
 }
 
-// QueryTargets executes the provided query on all target hosts in parallel
-// and returns the aggregated results
-func QueryTargets(
-	workload *models.Workload,
-	dbConfig database.Config,
-	dbType string,
-	dbPort int,
-	dbUser string,
-	dbPass string,
-	dbName string,
-	dbSSLMode string,
-) ExecutionResult {
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, workload.Workers) // Limit concurrency
-	resultsChan := make(chan *database.QueryResult, len(workload.Targets))
-	errChan := make(chan error, len(workload.Targets))
-
-	for _, targetHost := range workload.Targets {
-		wg.Add(1)
-		semaphore <- struct{}{} // Acquire semaphore slot
-
-		go func(host string) {
-			defer wg.Done()
-			defer func() { <-semaphore }() // Release semaphore slot
-
-			log.Printf("Worker starting for target: %s", host)
-
-			// Configure database connection for this specific target
-			targetDbConfig := database.Config{
-				Type:     dbType,
-				Host:     host, // Use the target host from the loop
-				Port:     dbPort,
-				User:     dbUser,
-				Password: dbPass,
-				Database: dbName,
-				SSLMode:  dbSSLMode,
+// Aggregate returns the aggregated rows as a single QueryResult for output sinks.
+// When the aggregate was spilled, Rows is empty and SpillPath holds the data.
+func (r ExecutionResult) Aggregate() *database.QueryResult {
+	return &database.QueryResult{
+		Columns:     r.Columns,
+		ColumnTypes: r.ColumnTypes,
+		Rows:        r.Rows,
+	}
+}
+
+// SanitizeHost turns a host string into a deterministic, filesystem-safe name
+// by replacing every character outside [A-Za-z0-9._-] with an underscore
+func SanitizeHost(host string) string {
+	var b strings.Builder
+	for _, r := range host {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// queryTargetFunc runs one target's query for QueryTargets; tests replace it
+// to fake targets without a database
+var queryTargetFunc = queryTarget
+
+// queryTarget connects to a single target and runs the workload query on it,
+// returning the candidate host that served it. With a stream the rows are
+// handed to it as they are scanned and the result holds only the columns.
+// Connection and query timeouts are reported as distinct errors.
+func queryTarget(ctx context.Context, host string, workload *models.Workload, dbConfig database.Config, stream *targetStream) (*database.QueryResult, string, error) {
+	// Refuse to run anything but read-only statements unless writes are allowed
+	if !workload.AllowWrites {
+		if err := database.CheckReadOnly(workload.Query); err != nil {
+			return nil, "", fmt.Errorf("refusing to run query on %s: %w", host, err)
+		}
+	}
+
+	// A fresh cached result spares the database entirely; a streamed one is never kept
+	cache := resultCache(workload)
+	if stream != nil {
+		cache = nil
+	}
+	cacheKey := resultCacheKey(host, workload, dbConfig)
+	if result, ok := cachedResult(cache, cacheKey, host, workload); ok {
+		return result, host, nil
+	}
+
+	// Connect to the first reachable candidate host of the target
+	db, target, servedBy, err := connectCandidates(ctx, host, dbConfig)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { database.Close(db) }() // Ensure the (possibly replaced) connection is closed
+
+	// Only a single statement may run, unless multiple are explicitly allowed;
+	// the dialect decides how strings and comments hide semicolons
+	if !workload.AllowMultiStatements {
+		if err := database.CheckSingleStatement(workload.Query, target.Type); err != nil {
+			return nil, servedBy, fmt.Errorf("refusing to run query on %s: %w", host, err)
+		}
+	} else if !workload.AllowWrites {
+		for _, statement := range database.SplitStatements(workload.Query, target.Type) {
+			if err := database.CheckReadOnly(statement); err != nil {
+				return nil, servedBy, fmt.Errorf("refusing to run query on %s: %w", host, err)
 			}
+		}
+	}
 
-			// Connect to database
-			db, err := database.Connect(targetDbConfig)
-			if err != nil {
-				errChan <- fmt.Errorf("failed to connect to database %s on %s: %w", dbName, host, err)
-				return
+	// Only fetch rows past the last watermark when incremental collection is on
+	query := workloadQuery(workload)
+	if watermark := workload.Watermark; watermark != nil {
+		last, ok := workload.WatermarkValues[host]
+		if !ok && watermark.Initial != "" {
+			last, ok = watermark.Initial, true
+		}
+		if ok {
+			query = applyWatermark(query, watermark.Column, last)
+		}
+	}
+
+	// Save the plan first; it is best effort and never fails the target
+	if workload.CaptureExplain {
+		captureExplain(ctx, db, host, target.Type, query, workload)
+	}
+
+	queryOptions := database.QueryOptions{
+		NullValue:  workload.NullSentinel(),
+		BoolFormat: workload.BoolFormat,
+
+		BinaryFormat: workload.BinaryFormat,
+		ArrayFormat:  workload.ArrayFormat,
+		JSONFormat:   workload.JSONFormat,
+	}
+	var seed uint64
+	if workload.SampleSeed != nil {
+		seed = *workload.SampleSeed
+	}
+	if workload.Samples() {
+		queryOptions.Sampler = database.NewSampler(workload.SampleRate, workload.SampleN, seed, host)
+	}
+	if stream != nil {
+		queryOptions.Stream = stream
+	}
+
+	// executeOn runs one statement on *conn under its own query timeout (so
+	// each page or chunk is bounded separately), rerunning it on a fresh
+	// connection if the connection dropped mid-query; rows from the failed
+	// attempt are never returned, so nothing is duplicated
+	executeOn := func(ctx context.Context, conn **gorm.DB, statement string, options database.QueryOptions) (*database.QueryResult, error) {
+		queryCtx := ctx
+		if workload.QueryTimeout.Duration > 0 {
+			var cancel context.CancelFunc
+			queryCtx, cancel = database.WithQueryTimeout(queryCtx, workload.QueryTimeout.Duration)
+			defer cancel()
+		}
+
+		result, err := database.ExecuteRawQuery(queryCtx, *conn, statement, options)
+		for attempt := 1; err != nil && attempt <= workload.Retries && database.IsConnectionDropped(err) && queryCtx.Err() == nil; attempt++ {
+			logging.Warnf("Connection to %s dropped during query (%v); retrying on a fresh connection (%d of %d)",
+				servedBy, err, attempt, workload.Retries)
+			database.Close(*conn)
+			*conn = nil
+			fresh, _, connErr := connectTarget(ctx, servedBy, dbConfig)
+			if connErr != nil {
+				return nil, fmt.Errorf("reconnecting after a dropped connection: %w", connErr)
+			}
+			*conn = fresh
+			result, err = database.ExecuteRawQuery(queryCtx, *conn, statement, options)
+		}
+		if err != nil {
+			// The run ending (max_runtime or a signal) isn't the query's timeout
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("query on %s stopped: %w", servedBy, context.Cause(ctx))
+			}
+			if errors.Is(err, database.ErrQueryTimeout) {
+				return nil, fmt.Errorf("query timeout on %s (limit %v): %w", servedBy, workload.QueryTimeout.Duration, err)
 			}
-			defer database.Close(db) // Ensure connection is closed
+			return nil, fmt.Errorf("%w on %s: %w", ErrQueryFailed, servedBy, err)
+		}
+		return result, nil
+	}
+	execute := func(statement string) (*database.QueryResult, error) {
+		return executeOn(ctx, &db, statement, queryOptions)
+	}
 
-			// Execute query
-			log.Printf("Executing query on %s: %s", host, workload.Query)
-			result, err := database.ExecuteRawQuery(db, workload.Query)
+	// Split the query into ranges queried side by side; the first chunk worker
+	// reuses the target's connection and each other one opens its own
+	if chunks := workload.Chunks; chunks != nil {
+		conditions, err := ValidateChunks(chunks)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid chunks: %w", err)
+		}
+		workers := min(workload.Workers.Resolve(len(conditions)), len(conditions))
+		conns := make([]*gorm.DB, workers)
+		conns[0] = db
+		defer func() {
+			for _, conn := range conns[1:] {
+				database.Close(conn)
+			}
+		}()
+		samplers := make([]*database.Sampler, len(conditions))
+		logging.Debugf("Executing query on %s in %d chunks of %s with %d workers: %s", servedBy, len(conditions), chunks.Column, workers, query)
+		result, err := fetchChunks(ctx, servedBy, query, conditions, workers, func(ctx context.Context, worker int, chunk int, statement string) (*database.QueryResult, error) {
+			if conns[worker] == nil {
+				conn, _, err := connectTarget(ctx, servedBy, dbConfig)
+				if err != nil {
+					return nil, err
+				}
+				conns[worker] = conn
+			}
+			options := queryOptions
+			if options.Sampler != nil {
+				// Each chunk is sampled on its own, reproducibly given the seed
+				options.Sampler = database.NewSampler(workload.SampleRate, workload.SampleN, seed, fmt.Sprintf("%s#%d", host, chunk))
+				samplers[chunk] = options.Sampler
+			}
+			return executeOn(ctx, &conns[worker], statement, options)
+		})
+		db = conns[0] // A dropped connection may have been replaced; the deferred close takes the new one
+		if err != nil {
+			return nil, "", err
+		}
+		if queryOptions.Sampler != nil {
+			scanned := 0
+			for _, sampler := range samplers {
+				scanned += sampler.Scanned()
+			}
+			logging.Infof("Sampled %d of %d rows on %s", len(result.Rows), scanned, servedBy)
+		}
+		logging.Infof("Fetched %d chunks of %s from %s: %d rows", len(conditions), chunks.Column, servedBy, len(result.Rows))
+		storeResult(cache, cacheKey, host, result)
+		return result, servedBy, nil
+	}
+
+	// Fetch large results page by page when the query allows it; a count is
+	// a single row anyway
+	if workload.PageSize > 0 && !workload.CountOnly {
+		if reason := unpageableReason(query); reason != "" {
+			logging.Warnf("Warning: page_size ignored on %s: %s; running the query in one shot", servedBy, reason)
+		} else {
+			logging.Debugf("Executing query on %s in pages of %d rows: %s", servedBy, workload.PageSize, query)
+			result, err := fetchPages(servedBy, query, workload.PageSize, execute)
 			if err != nil {
-				errChan <- fmt.Errorf("query execution failed on %s: %w", host, err)
-				return
+				return nil, "", err
 			}
+			logSample(servedBy, result, queryOptions.Sampler)
+			storeResult(cache, cacheKey, host, result)
+			return result, servedBy, nil
+		}
+	}
+
+	// Execute query
+	logging.Debugf("Executing query on %s: %s", servedBy, query)
+	result, err := execute(query)
+	if err != nil {
+		return nil, "", err
+	}
+	logSample(servedBy, result, queryOptions.Sampler)
+	storeResult(cache, cacheKey, host, result)
 
-			log.Printf("Query executed successfully on %s. Retrieved %d rows.", host, len(result.Rows))
-			resultsChan <- result // Send successful result
+	return result, servedBy, nil
+}
 
-		}(targetHost) // Pass targetHost to the goroutine
+// logSample reports how many of a target's rows its sample kept
+func logSample(host string, result *database.QueryResult, sampler *database.Sampler) {
+	if sampler != nil {
+		logging.Infof("Sampled %d of %d rows on %s", len(result.Rows), sampler.Scanned(), host)
 	}
+}
 
-	// Wait for all goroutines to finish
-	wg.Wait()
-	close(resultsChan)
-	close(errChan)
+// QueryTargets executes the provided query on all target hosts in parallel
+// and returns the aggregated results. Cancelling ctx stops dispatching new
+// targets and aborts in-flight connections and queries.
+func QueryTargets(ctx context.Context, workload *models.Workload, dbConfig database.Config) ExecutionResult {
+	return QueryTargetsTo(ctx, workload, dbConfig, nil)
+}
+
+// QueryTargetsTo is QueryTargets writing the aggregate to pipe as it grows:
+// each target's processed result is handed to pipe once every earlier target
+// has finished, so the output is the same as a buffered write in the same
+// order. The returned result then holds no rows. A write error aborts the run
+// like an aggregation failure. A nil pipe buffers the aggregate as usual.
+func QueryTargetsTo(ctx context.Context, workload *models.Workload, dbConfig database.Config, pipe ResultWriter) ExecutionResult {
+	// Derive a run context so fail_fast can abort every worker at once
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+
+	// Each target group dispatches under its own limit; the rest share workers
+	pools := newWorkerPools(workload)
+	results := newTargetResults(workload.Targets)
+	errChan := make(chan TargetError, len(workload.Targets))
+
+	// Record the first error so fail_fast can return it
+	var firstErrOnce sync.Once
+	var firstErr error
+	reportError := func(host string, err error) {
+		errChan <- TargetError{Host: host, Err: err}
+		if workload.FailFast {
+			firstErrOnce.Do(func() {
+				firstErr = fmt.Errorf("fail_fast aborted the run: %w", err)
+				logging.Errorf("fail_fast: aborting run after error: %v", err)
+				cancelRun()
+			})
+		}
+	}
+
+	// Per-target files are written by their own goroutines so they never hold a worker slot
+	var writeWg sync.WaitGroup
+	var filesMu sync.Mutex
+	targetFiles := make(map[string]string)
+	targetBytes := make(map[string]int64)
+
+	// New watermark values, recorded per host as results arrive
+	var watermarksMu sync.Mutex
+	watermarks := make(map[string]string)
+
+	// Soft failures kept as warnings (row_check "warn")
+	var warningsMu sync.Mutex
+	var warnings []TargetError
 
-	// --- Aggregation and Output ---
-	var allRows [][]string
-	var columns []string
-	hasResults := false
-
-	// Collect results
-	for result := range resultsChan {
-		if result != nil {
-			if !hasResults && len(result.Columns) > 0 {
-				columns = result.Columns // Get columns from the first result
-				hasResults = true
+	// The candidate host that actually served each successful target
+	var servedByMu sync.Mutex
+	servedByHost := make(map[string]string)
+
+	// Rows each successful target contributed, to tell empty results apart
+	var targetRowsMu sync.Mutex
+	targetRows := make(map[string]int)
+	targetTimings := make(map[string]TargetTiming)
+
+	// Targets skipped or aborted because the run was cancelled
+	var incomplete atomic.Int32
+	var succeeded atomic.Int32
+	var streamedRows atomic.Int64 // Rows written by streamed targets, which bypass the aggregate
+
+	// --- Aggregation ---
+	// Results are merged in target order as soon as all earlier targets have
+	// finished, so the spill threshold still bounds memory use
+	agg := &aggregator{
+		spillThreshold: workload.SpillThreshold,
+		spillDir:       workload.OutputDir,
+		dirMode:        workload.WriteOptions().DirPerm(),
+		flushRows:      workload.FlushRows,
+		unionColumns:   workload.UnionColumns,
+		nullValue:      workload.NullSentinel(),
+		pipe:           pipe,
+	}
+	if len(workload.DedupeKeys) > 0 {
+		agg.dedupe = newDeduper(workload.DedupeKeys, workload.DedupeKeep, workload.ColumnAliases)
+	}
+	var aggErr error
+	aggregated := make(chan struct{})
+	go func() {
+		defer close(aggregated)
+		for range results.signal {
+			for _, result := range results.release() {
+				if aggErr == nil {
+					aggErr = agg.add(result)
+				}
 			}
-			if len(result.Rows) > 0 {
-				allRows = append(allRows, result.Rows...)
+		}
+		// Targets never dispatched are marked finished by close; take the rest
+		for _, result := range results.release() {
+			if aggErr == nil {
+				aggErr = agg.add(result)
 			}
 		}
+	}()
+
+	var dispatchWg sync.WaitGroup
+	for _, pool := range pools {
+		dispatchWg.Add(1)
+		go func(pool *workerPool) {
+			defer dispatchWg.Done()
+			start := time.Now()
+			var wg sync.WaitGroup
+			defer func() {
+				wg.Wait()
+				pool.elapsed = time.Since(start)
+			}()
+
+		dispatch:
+			for i, targetHost := range pool.targets {
+				// Acquire semaphore slot, unless the run has been cancelled meanwhile
+				select {
+				case pool.semaphore <- struct{}{}:
+				case <-runCtx.Done():
+					incomplete.Add(int32(len(pool.targets) - i))
+					break dispatch
+				}
+				if runCtx.Err() != nil {
+					<-pool.semaphore
+					incomplete.Add(int32(len(pool.targets) - i))
+					break dispatch
+				}
+
+				// Only the first wave starts at once; later targets inherit the spread
+				var jitter time.Duration
+				if i < pool.workers {
+					jitter = workload.StartJitter.Duration
+				}
+
+				wg.Add(1)
+				go func(host string) {
+					defer wg.Done()
+					defer func() { <-pool.semaphore }() // Release semaphore slot
+					defer results.finish(host)          // Let later targets' results through, even on failure
+					defer func() {
+						// A panicking driver fails only this target, not the whole run
+						if recovered := recover(); recovered != nil {
+							logging.Debugf("Panic on %s: %v\n%s", host, recovered, debug.Stack())
+							reportError(host, fmt.Errorf("%w on %s: %v", ErrWorkerPanic, host, recovered))
+						}
+					}()
+
+					if err := sleepJitter(runCtx, jitter); err != nil {
+						incomplete.Add(1)
+						reportError(host, fmt.Errorf("start on %s cancelled: %w", host, err))
+						return
+					}
+					logging.Debugf("Worker starting for target: %s", host)
+
+					// Metrics cover every outcome, a panic included
+					started := time.Now()
+					var targetRowCount int
+					var targetSucceeded bool
+					defer func() { recordTargetMetrics(host, time.Since(started), targetRowCount, targetSucceeded) }()
+
+					// A streamed target writes its file while it is scanned; any
+					// failure below leaves no partial file behind
+					var stream *targetStream
+					if workload.StreamPerTarget {
+						stream = newTargetStream(host, workload)
+						defer func() {
+							if !targetSucceeded {
+								stream.abort()
+							}
+						}()
+					}
+
+					result, servedBy, err := queryTargetFunc(runCtx, host, workload, dbConfig, stream)
+					if err != nil {
+						if runCtx.Err() != nil {
+							incomplete.Add(1)
+						}
+						reportError(host, err)
+						return
+					}
+
+					// Under count_only the target's rows are the count it returned
+					rows := len(result.Rows)
+					if stream != nil {
+						rows = stream.scanned
+					}
+					if workload.CountOnly {
+						if result, rows, err = countResult(host, result); err != nil {
+							reportError(host, err)
+							return
+						}
+					}
+					fetchedBytes := rowBytes(result.Rows)
+					if stream != nil {
+						fetchedBytes = stream.scannedBytes
+					}
+
+					// Flag suspicious row counts as a failure, or only a warning
+					if err := checkRowCount(host, rows, workload); err != nil {
+						if workload.RowCheck != models.RowCheckWarn {
+							reportError(host, err)
+							return
+						}
+						logging.Warnf("Warning: %v", err)
+						warningsMu.Lock()
+						warnings = append(warnings, TargetError{Host: host, Err: err})
+						warningsMu.Unlock()
+					}
+					servedByMu.Lock()
+					servedByHost[host] = servedBy
+					servedByMu.Unlock()
+
+					// A streamed target's last batch is written now, so its file is complete
+					var streamedPath string
+					if stream != nil {
+						path, size, err := stream.finish()
+						if err != nil {
+							reportError(host, fmt.Errorf("streaming output for %s: %w", host, err))
+							return
+						}
+						streamedPath = path
+						if path != "" {
+							filesMu.Lock()
+							targetFiles[host] = path
+							targetBytes[host] = size
+							filesMu.Unlock()
+							logging.Infof("Per-target output for %s streamed to %s (%d rows, %d bytes)", host, path, stream.written, size)
+						}
+					}
+
+					// Track the highest watermark value this target returned
+					if workload.Watermark != nil {
+						value, ok := maxColumnValue(result.Columns, result.Rows, workload.Watermark.Column, workload.NullSentinel())
+						if stream != nil {
+							value, ok = stream.watermark, stream.marked
+						}
+						if ok {
+							watermarksMu.Lock()
+							watermarks[host] = value
+							watermarksMu.Unlock()
+						}
+					}
+
+					// Apply column-level settings before the result is aggregated or
+					// written; a stream applied them batch by batch
+					if stream == nil {
+						result, err = processResult(host, result, workload, false)
+						if err != nil {
+							reportError(host, err)
+							return
+						}
+					}
+
+					switch {
+					case workload.CountOnly:
+						logging.Infof("Query executed successfully on %s. Counted %d rows.", host, rows)
+					case stream != nil:
+						logging.Infof("Query executed successfully on %s. Streamed %d rows.", host, stream.written)
+					default:
+						logging.Infof("Query executed successfully on %s. Retrieved %d rows.", host, len(result.Rows))
+					}
+					if stream == nil {
+						results.store(host, result) // A streamed target's rows are only in its file
+					}
+					targetRowsMu.Lock()
+					targetRows[host] = rows
+					targetTimings[host] = TargetTiming{Elapsed: time.Since(started), Bytes: fetchedBytes}
+					targetRowsMu.Unlock()
+					succeeded.Add(1)
+					pool.succeeded.Add(1)
+					pool.rows.Add(int64(rows))
+					targetRowCount, targetSucceeded = rows, true
+					if stream != nil {
+						streamedRows.Add(int64(stream.written))
+						if streamedPath == "" {
+							logging.Debugf("No per-target output for %s: no rows (write_empty is false)", host)
+						}
+						return
+					}
+
+					if workload.PerTargetOutput && len(result.Rows) == 0 && !workload.WritesEmpty() {
+						logging.Debugf("No per-target output for %s: no rows (write_empty is false)", host)
+					} else if workload.PerTargetOutput {
+						writeWg.Add(1)
+						go func() {
+							defer writeWg.Done()
+							defer func() {
+								if recovered := recover(); recovered != nil {
+									logging.Warnf("Warning: failed to write per-target output for %s: panic: %v", host, recovered)
+								}
+							}()
+							options := workload.WriteOptions()
+							options.Filename = fmt.Sprintf("%s_%s", workload.OutputFile, SanitizeHost(host))
+							sink := output.NewCSVSink(options)
+							if err := sink.Write(result); err != nil {
+								logging.Warnf("Warning: failed to write per-target output for %s: %v", host, err)
+								return
+							}
+							path := sink.Files()[0]
+							filesMu.Lock()
+							targetFiles[host] = path
+							if info, err := os.Stat(path); err == nil {
+								targetBytes[host] = info.Size()
+							}
+							filesMu.Unlock()
+							logging.Infof("Per-target output for %s written to %s", host, path)
+						}()
+					}
+
+				}(targetHost) // Pass targetHost to the goroutine
+			}
+		}(pool)
 	}
 
-	// Collect and log errors
-	errorCount := 0
-	for err := range errChan {
-		log.Printf("Error during processing: %v", err)
-		errorCount++
+	// Wait for every pool's goroutines to finish
+	dispatchWg.Wait()
+	results.close()
+	close(errChan)
+	<-aggregated
+
+	// Finalize the spill file, if the aggregate grew past the threshold
+	spillPath, err := agg.finish()
+	if err != nil && aggErr == nil {
+		aggErr = err
+	}
+	if aggErr != nil {
+		agg.discard()
+		spillPath = ""
+		if firstErr == nil {
+			firstErr = fmt.Errorf("aggregation failed: %w", aggErr)
+		}
+	}
+
+	// Collect and log errors, keeping the host that produced each one
+	var targetErrors []TargetError
+	for targetErr := range errChan {
+		targetErrors = append(targetErrors, targetErr)
+	}
+	results.sortByTarget(targetErrors)
+	results.sortByTarget(warnings)
+	for _, targetErr := range targetErrors {
+		logging.Errorf("Error during processing: %v", targetErr)
 	}
+	errorCount := len(targetErrors)
 
 	if errorCount > 0 {
-		log.Printf("Warning: Encountered %d error(s) during parallel execution.", errorCount)
+		logging.Warnf("Warning: Encountered %d error(s) during parallel execution.", errorCount)
+	}
+
+	// Wait for any per-target files still being written
+	writeWg.Wait()
+
+	// Report targets cut short by the caller's deadline
+	truncated := errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if truncated {
+		logging.Warnf("Warning: run truncated by deadline; %d of %d target(s) did not complete.",
+			incomplete.Load(), len(workload.Targets))
 	}
 
 	// Return the aggregated results
+	// Targets that ran fine but returned nothing, in target order
+	var emptyTargets []string
+	for _, target := range workload.Targets {
+		if rows, ok := targetRows[target]; ok && rows == 0 {
+			emptyTargets = append(emptyTargets, target)
+		}
+	}
+	if len(emptyTargets) > 0 {
+		logging.Infof("%d target(s) returned no rows: %s", len(emptyTargets), strings.Join(emptyTargets, ", "))
+	}
+
+	// Per-pool throughput, only meaningful when target groups split the run
+	var groups []GroupStats
+	if len(workload.TargetGroups) > 0 {
+		failedHosts := make(map[string]bool, len(targetErrors))
+		for _, targetErr := range targetErrors {
+			failedHosts[targetErr.Host] = true
+		}
+		for _, pool := range pools {
+			failed := 0
+			for _, target := range pool.targets {
+				if failedHosts[target] {
+					failed++
+				}
+			}
+			groups = append(groups, pool.stats(failed))
+		}
+	}
+
+	// Streamed targets only wrote their own files, so the totals come from them
+	rowCount, hasResults := agg.rowCount, agg.hasResults
+	if workload.StreamPerTarget {
+		rowCount, hasResults = int(streamedRows.Load()), succeeded.Load() > 0
+	}
+
 	return ExecutionResult{
-		Rows:       allRows,
-		Columns:    columns,
-		ErrorCount: errorCount,
-		HasResults: hasResults,
+		Err:           firstErr,
+		Incomplete:    int(incomplete.Load()),
+		Truncated:     truncated,
+		Rows:          agg.rows,
+		RowCount:      rowCount,
+		SpillPath:     spillPath,
+		Columns:       agg.columns,
+		ColumnTypes:   agg.columnTypes,
+		ErrorCount:    errorCount,
+		Errors:        targetErrors,
+		SuccessCount:  int(succeeded.Load()),
+		Warnings:      warnings,
+		HasResults:    hasResults,
+		TargetFiles:   targetFiles,
+		TargetBytes:   targetBytes,
+		ServedBy:      servedByHost,
+		Watermarks:    watermarks,
+		Groups:        groups,
+		TargetRows:    targetRows,
+		TargetTimings: targetTimings,
+		EmptyTargets:  emptyTargets,
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,81 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"datacollector/database"
+	"datacollector/models"
+)
+
+// fakeTargets makes QueryTargets call query instead of connecting to the
+// targets, until the test ends
+func fakeTargets(t *testing.T, query func(host string) (*database.QueryResult, error)) {
+	t.Helper()
+	previous := queryTargetFunc
+	queryTargetFunc = func(ctx context.Context, host string, workload *models.Workload, dbConfig database.Config, stream *targetStream) (*database.QueryResult, string, error) {
+		result, err := query(host)
+		if err != nil {
+			return nil, "", err
+		}
+		// A streamed target receives its rows like a driver would hand them over
+		if stream != nil {
+			if err := stream.Begin(result.Columns, result.ColumnTypes); err != nil {
+				return nil, "", err
+			}
+			for _, row := range result.Rows {
+				if err := stream.Row(row); err != nil {
+					return nil, "", err
+				}
+			}
+			return &database.QueryResult{Columns: result.Columns, ColumnTypes: result.ColumnTypes}, host, nil
+		}
+		return result, host, nil
+	}
+	t.Cleanup(func() { queryTargetFunc = previous })
+}
+
+// hostRows returns a result of n rows naming host and numbering the rows
+func hostRows(host string, n int) *database.QueryResult {
+	result := &database.QueryResult{Columns: []string{"host", "n"}, ColumnTypes: []string{"TEXT", "INT"}}
+	for i := 0; i < n; i++ {
+		result.Rows = append(result.Rows, []string{host, fmt.Sprint(i)})
+	}
+	return result
+}
+
+func TestQueryTargetsRecoversPanic(t *testing.T) {
+	fakeTargets(t, func(host string) (*database.QueryResult, error) {
+		if host == "boom" {
+			panic("driver bug")
+		}
+		return hostRows(host, 2), nil
+	})
+
+	workload := &models.Workload{Query: "SELECT 1", Targets: []string{"a", "boom", "c"}, Workers: 3}
+	result := QueryTargets(context.Background(), workload, database.Config{})
+
+	if result.Err != nil {
+		t.Fatalf("Err = %v, want the run to go on", result.Err)
+	}
+	if result.ErrorCount != 1 || len(result.Errors) != 1 {
+		t.Fatalf("ErrorCount = %d, Errors = %v, want only the panicking target", result.ErrorCount, result.Errors)
+	}
+	targetErr := result.Errors[0]
+	if targetErr.Host != "boom" || !errors.Is(targetErr.Err, ErrWorkerPanic) {
+		t.Errorf("error = %s: %v, want ErrWorkerPanic on boom", targetErr.Host, targetErr.Err)
+	}
+	if targetErr.Category() != CategoryPanic {
+		t.Errorf("category = %q, want %q", targetErr.Category(), CategoryPanic)
+	}
+
+	if result.SuccessCount != 2 || result.RowCount != 4 {
+		t.Fatalf("SuccessCount = %d, RowCount = %d, want 2 targets and 4 rows", result.SuccessCount, result.RowCount)
+	}
+	want := [][]string{{"a", "0"}, {"a", "1"}, {"c", "0"}, {"c", "1"}}
+	if fmt.Sprint(result.Rows) != fmt.Sprint(want) {
+		t.Errorf("Rows = %v, want %v", result.Rows, want)
+	}
+}
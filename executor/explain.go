@@ -0,0 +1,45 @@
+package executor
+
+import (
+	"context"
+	"datacollector/database"
+	"datacollector/logging"
+	"datacollector/models"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gorm.io/gorm"
+)
+
+// captureExplain saves the execution plan of query on host to a sidecar file
+// named <outfile>_<host>.explain.txt. Failures are only logged: the plan is
+// informational and must never fail the collection itself.
+func captureExplain(ctx context.Context, db *gorm.DB, host, dbType, query string, workload *models.Workload) {
+	// A separate time budget, so a slow EXPLAIN can't eat into the query's
+	if workload.QueryTimeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = database.WithQueryTimeout(ctx, workload.QueryTimeout.Duration)
+		defer cancel()
+	}
+
+	plan, err := database.ExplainQuery(ctx, db, dbType, query)
+	if err != nil {
+		logging.Warnf("Warning: could not capture EXPLAIN on %s: %v", host, err)
+		return
+	}
+
+	options := workload.WriteOptions()
+	if options.Directory != "" {
+		if err := os.MkdirAll(options.Directory, options.DirPerm()); err != nil {
+			logging.Warnf("Warning: could not write EXPLAIN for %s: %v", host, err)
+			return
+		}
+	}
+	path := filepath.Join(options.Directory, fmt.Sprintf("%s_%s.explain.txt", options.Filename, SanitizeHost(host)))
+	if err := os.WriteFile(path, []byte(plan), options.FilePerm()); err != nil {
+		logging.Warnf("Warning: could not write EXPLAIN for %s: %v", host, err)
+		return
+	}
+	logging.Infof("Execution plan for %s written to %s", host, path)
+}
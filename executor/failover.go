@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"context"
+	"datacollector/database"
+	"datacollector/logging"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// connectCandidates connects to the candidate hosts of a target entry in
+// order, returning the first connection that succeeds together with the
+// parsed target and the candidate that served it. Only connection failures
+// fail over; init_sql errors and, once connected, query errors are reported
+// as usual.
+func connectCandidates(ctx context.Context, entry string, dbConfig database.Config) (*gorm.DB, database.Target, string, error) {
+	candidates := database.SplitCandidates(entry)
+	if len(candidates) == 0 {
+		return nil, database.Target{}, "", fmt.Errorf("invalid target %q: no hosts", entry)
+	}
+
+	var lastErr error
+	for i, candidate := range candidates {
+		if i > 0 {
+			if ctx.Err() != nil {
+				break // The run was cancelled; don't try the remaining candidates
+			}
+			logging.Warnf("Failing over target %s to candidate %s", entry, candidate)
+		}
+
+		db, target, err := connectTarget(ctx, candidate, dbConfig)
+		if err == nil {
+			return db, target, candidate, nil
+		}
+		if len(candidates) > 1 {
+			logging.Warnf("Warning: candidate %s of target %s is unavailable: %v", candidate, entry, err)
+		}
+		lastErr = err
+		if errors.Is(err, database.ErrInitSQL) {
+			break // The server was reachable; another candidate would reject it too
+		}
+	}
+
+	if len(candidates) > 1 {
+		return nil, database.Target{}, "", fmt.Errorf("all %d candidates of %s failed, last error: %w", len(candidates), entry, lastErr)
+	}
+	return nil, database.Target{}, "", lastErr
+}
+
+// connectTarget connects to a single candidate host.
+// Connect timeouts and TLS failures are reported as distinct errors, and
+// connection failures carry a database.Diagnosis of their cause.
+func connectTarget(ctx context.Context, host string, dbConfig database.Config) (*gorm.DB, database.Target, error) {
+	// Resolve the database type, host and port from the target entry
+	target, err := database.ParseTarget(host, dbConfig.Type)
+	if err != nil {
+		return nil, database.Target{}, err
+	}
+
+	// Configure database connection for this specific target
+	targetDbConfig := dbConfig
+	targetDbConfig.Type = target.Type
+	targetDbConfig.Host = target.Host
+	if target.Socket != "" {
+		targetDbConfig.Socket = target.Socket
+	}
+	if target.Database != "" {
+		targetDbConfig.Database = target.Database
+	}
+	if target.Port != 0 {
+		targetDbConfig.Port = target.Port
+	} else if target.Type != dbConfig.Type {
+		// A scheme picked a different driver than DB_TYPE, so DB_PORT doesn't apply
+		targetDbConfig.Port = database.DefaultPort(target.Type)
+	}
+
+	// Connect to database
+	db, err := database.ConnectContext(ctx, targetDbConfig)
+	if err != nil {
+		if errors.Is(err, database.ErrInitSQL) {
+			return nil, target, fmt.Errorf("session setup on %s failed: %w", host, err)
+		}
+
+		// Work out why so the reported error points at the likely fix
+		if ctx.Err() == nil {
+			err = &database.DiagnosedError{Diagnosis: database.Diagnose(ctx, targetDbConfig, err), Err: err}
+		}
+		if errors.Is(err, database.ErrConnectTimeout) {
+			return nil, target, fmt.Errorf("connect timeout on %s (limit %v): %w", host, targetDbConfig.ConnectTimeout, err)
+		}
+		if errors.Is(err, database.ErrTLSHandshake) {
+			return nil, target, fmt.Errorf("TLS negotiation with %s failed (check sslmode and certificates): %w", host, err)
+		}
+		return nil, target, fmt.Errorf("%w to database %s on %s: %w", ErrConnectFailed, targetDbConfig.Database, host, err)
+	}
+	return db, target, nil
+}
@@ -0,0 +1,85 @@
+package executor
+
+import (
+	"datacollector/database"
+	"datacollector/models"
+	"sync/atomic"
+	"time"
+)
+
+// GroupStats reports how the targets sharing one worker limit fared
+type GroupStats struct {
+	Name      string // Target group name; empty for the targets outside every group
+	Workers   int
+	Targets   int
+	Succeeded int
+	Failed    int
+	Rows      int
+	Elapsed   time.Duration // From the group's first dispatch until its last target finished
+}
+
+// TargetsPerSecond returns the group's throughput in finished targets per second
+func (g GroupStats) TargetsPerSecond() float64 {
+	if g.Elapsed <= 0 {
+		return 0
+	}
+	return float64(g.Succeeded+g.Failed) / g.Elapsed.Seconds()
+}
+
+// workerPool is a set of targets dispatched under its own concurrency limit
+type workerPool struct {
+	name      string
+	workers   int
+	targets   []string
+	semaphore chan struct{}
+
+	succeeded atomic.Int32
+	rows      atomic.Int64
+	elapsed   time.Duration
+}
+
+// newWorkerPools assigns each target to the first target group matching it,
+// keeping target order within every pool. Targets outside all groups share
+// a pool limited by the global workers setting, which comes first. Pools
+// without targets are left out.
+func newWorkerPools(workload *models.Workload) []*workerPool {
+	pools := []*workerPool{{workers: workload.Workers.Resolve(len(workload.Targets))}}
+	for _, group := range workload.TargetGroups {
+		pools = append(pools, &workerPool{name: group.Name, workers: group.Workers})
+	}
+
+	for _, target := range workload.Targets {
+		pool := pools[0]
+		for i, group := range workload.TargetGroups {
+			// Patterns are validated at startup, so errors can't occur here
+			if matched, _ := database.MatchesTarget(target, group.Targets); matched {
+				pool = pools[i+1]
+				break
+			}
+		}
+		pool.targets = append(pool.targets, target)
+	}
+
+	var used []*workerPool
+	for _, pool := range pools {
+		if len(pool.targets) > 0 {
+			pool.semaphore = make(chan struct{}, pool.workers)
+			used = append(used, pool)
+		}
+	}
+	return used
+}
+
+// stats summarizes the pool once all of its targets have finished; failed
+// is the number of its targets that reported an error
+func (p *workerPool) stats(failed int) GroupStats {
+	return GroupStats{
+		Name:      p.name,
+		Workers:   p.workers,
+		Targets:   len(p.targets),
+		Succeeded: int(p.succeeded.Load()),
+		Failed:    failed,
+		Rows:      int(p.rows.Load()),
+		Elapsed:   p.elapsed,
+	}
+}
@@ -0,0 +1,24 @@
+package executor
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// sleepJitter waits a random interval in [0, jitter) so workers don't all
+// connect at the same instant. It returns early with the context's error
+// when ctx is done; a zero jitter returns immediately.
+func sleepJitter(ctx context.Context, jitter time.Duration) error {
+	if jitter <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(rand.N(jitter))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,23 @@
+package executor
+
+import (
+	"datacollector/metrics"
+	"time"
+)
+
+// recordTargetMetrics emits a finished target's StatsD metrics: how long it
+// took, whether it succeeded and the rows it returned, under
+// "target.<host>" and as run-wide totals
+func recordTargetMetrics(host string, elapsed time.Duration, rows int, succeeded bool) {
+	target := metrics.Name("target", host)
+	metrics.Timing(target+".duration", elapsed)
+	if !succeeded {
+		metrics.Count(target+".failure", 1)
+		metrics.Count("targets.failure", 1)
+		return
+	}
+	metrics.Count(target+".success", 1)
+	metrics.Count(target+".rows", int64(rows))
+	metrics.Count("targets.success", 1)
+	metrics.Count("rows", int64(rows))
+}
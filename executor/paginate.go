@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"datacollector/database"
+	"datacollector/logging"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// limitPattern finds clauses that already restrict the rows returned
+	limitPattern = regexp.MustCompile(`(?i)\b(limit|offset|fetch)\b`)
+	// orderByPattern detects an ORDER BY anywhere in the query
+	orderByPattern = regexp.MustCompile(`(?i)\border\s+by\b`)
+)
+
+// unpageableReason returns why query can't be paginated with LIMIT/OFFSET,
+// or "" when it is a simple SELECT (or WITH ... SELECT) that can be
+func unpageableReason(query string) string {
+	switch keyword := database.LeadingKeyword(query); keyword {
+	case "SELECT", "WITH":
+	default:
+		return fmt.Sprintf("only SELECT queries can be paginated, not %s", keyword)
+	}
+	body := trimStatement(query)
+	if strings.Contains(body, ";") {
+		return "the query contains several statements"
+	}
+	if limitPattern.MatchString(body) {
+		return "the query already has a LIMIT, OFFSET or FETCH clause"
+	}
+	return ""
+}
+
+// trimStatement removes trailing whitespace and semicolons from query
+func trimStatement(query string) string {
+	return strings.TrimRight(query, " \t\r\n;")
+}
+
+// pageQuery returns the statement fetching page (0-based) of query. The
+// clause goes on its own line so a trailing line comment can't swallow it.
+func pageQuery(query string, pageSize int, page int) string {
+	return fmt.Sprintf("%s\nLIMIT %d OFFSET %d", trimStatement(query), pageSize, page*pageSize)
+}
+
+// fetchPages runs query page by page through execute until a short page
+// shows the rows are exhausted, and returns all rows as one result
+func fetchPages(host string, query string, pageSize int, execute func(string) (*database.QueryResult, error)) (*database.QueryResult, error) {
+	if !orderByPattern.MatchString(query) {
+		logging.Warnf("Warning: paginated query on %s has no ORDER BY; pages may overlap or skip rows if the row order isn't stable", host)
+	}
+
+	var combined *database.QueryResult
+	for page := 0; ; page++ {
+		result, err := execute(pageQuery(query, pageSize, page))
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", page+1, err)
+		}
+		if combined == nil {
+			combined = result
+		} else {
+			combined.Rows = append(combined.Rows, result.Rows...)
+		}
+		logging.Debugf("Fetched page %d from %s: %d rows", page+1, host, len(result.Rows))
+
+		if len(result.Rows) < pageSize {
+			return combined, nil
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"datacollector/database"
+	"datacollector/models"
+)
+
+// recordingWriter is a ResultWriter remembering the hosts whose rows it got,
+// in order, and announcing each of them on wrote
+type recordingWriter struct {
+	mu    sync.Mutex
+	hosts []string
+	rows  int
+	wrote chan string
+	err   error
+}
+
+func (w *recordingWriter) Write(result *database.QueryResult) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.mu.Lock()
+	host := result.Rows[0][0]
+	w.hosts = append(w.hosts, host)
+	w.rows += len(result.Rows)
+	w.mu.Unlock()
+	w.wrote <- host
+	return nil
+}
+
+func TestQueryTargetsToWritesAsTargetsFinish(t *testing.T) {
+	writer := &recordingWriter{wrote: make(chan string, 3)}
+	fakeTargets(t, func(host string) (*database.QueryResult, error) {
+		// b only finishes once a's rows have reached the writer
+		if host == "b" {
+			select {
+			case written := <-writer.wrote:
+				if written != "a" {
+					return nil, fmt.Errorf("writer got %s first, want a", written)
+				}
+			case <-time.After(5 * time.Second):
+				return nil, errors.New("a's rows weren't written while b was still running")
+			}
+		}
+		return hostRows(host, 2), nil
+	})
+
+	workload := &models.Workload{Query: "SELECT 1", Targets: []string{"a", "b", "c"}, Workers: 3}
+	result := QueryTargetsTo(context.Background(), workload, database.Config{}, writer)
+
+	if result.Err != nil || result.ErrorCount != 0 {
+		t.Fatalf("Err = %v, Errors = %v", result.Err, result.Errors)
+	}
+	if fmt.Sprint(writer.hosts) != "[a b c]" {
+		t.Errorf("writer got %v, want [a b c] in target order", writer.hosts)
+	}
+	if result.RowCount != 6 || writer.rows != 6 {
+		t.Errorf("RowCount = %d, writer rows = %d, want 6", result.RowCount, writer.rows)
+	}
+	if len(result.Rows) != 0 {
+		t.Errorf("Rows = %v, want none kept in memory", result.Rows)
+	}
+}
+
+func TestQueryTargetsToWriteError(t *testing.T) {
+	fakeTargets(t, func(host string) (*database.QueryResult, error) {
+		return hostRows(host, 1), nil
+	})
+	writer := &recordingWriter{err: errors.New("disk full")}
+
+	workload := &models.Workload{Query: "SELECT 1", Targets: []string{"a", "b"}, Workers: 2}
+	result := QueryTargetsTo(context.Background(), workload, database.Config{}, writer)
+	if !errors.Is(result.Err, writer.err) {
+		t.Errorf("Err = %v, want the write error", result.Err)
+	}
+}
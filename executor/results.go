@@ -0,0 +1,101 @@
+package executor
+
+import (
+	"datacollector/database"
+	"sort"
+	"sync"
+)
+
+// targetResults collects each target's result keyed by host and releases
+// them to the aggregator in target order: a result is handed on once every
+// earlier target has finished, so the aggregate is the same whichever target
+// completes first. Later results wait in the map meanwhile.
+type targetResults struct {
+	mu       sync.Mutex
+	targets  []string
+	index    map[string]int // Position of each host in targets
+	results  map[string]*database.QueryResult
+	finished []bool
+	next     int           // First target not yet released
+	signal   chan struct{} // Wakes the aggregator after a target finishes
+}
+
+// newTargetResults prepares collection for targets
+func newTargetResults(targets []string) *targetResults {
+	index := make(map[string]int, len(targets))
+	for i := len(targets) - 1; i >= 0; i-- {
+		index[targets[i]] = i // The first occurrence wins
+	}
+	return &targetResults{
+		targets:  targets,
+		index:    index,
+		results:  make(map[string]*database.QueryResult, len(targets)),
+		finished: make([]bool, len(targets)),
+		signal:   make(chan struct{}, 1),
+	}
+}
+
+// store records the successful result of host
+func (r *targetResults) store(host string, result *database.QueryResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results[host] = result
+}
+
+// finish marks host as done, with or without a stored result
+func (r *targetResults) finish(host string) {
+	r.mu.Lock()
+	if i, ok := r.index[host]; ok {
+		r.finished[i] = true
+	}
+	r.mu.Unlock()
+
+	select {
+	case r.signal <- struct{}{}:
+	default: // The aggregator has a wake-up pending already
+	}
+}
+
+// release returns, in target order, the results of the finished targets
+// following those already released, up to the first unfinished target
+func (r *targetResults) release() []*database.QueryResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var released []*database.QueryResult
+	for ; r.next < len(r.targets) && r.finished[r.next]; r.next++ {
+		host := r.targets[r.next]
+		if result, ok := r.results[host]; ok {
+			released = append(released, result)
+			delete(r.results, host) // The aggregator owns it now
+		}
+	}
+	return released
+}
+
+// close marks every remaining target (e.g. one never dispatched) as finished
+// once all workers are done, so release returns whatever is left, and stops
+// the aggregator's wake-ups
+func (r *targetResults) close() {
+	r.mu.Lock()
+	for i := range r.finished {
+		r.finished[i] = true
+	}
+	r.mu.Unlock()
+	close(r.signal)
+}
+
+// sortByTarget orders target errors by their host's position in targets
+func (r *targetResults) sortByTarget(targetErrors []TargetError) {
+	sort.SliceStable(targetErrors, func(a, b int) bool {
+		return r.position(targetErrors[a].Host) < r.position(targetErrors[b].Host)
+	})
+}
+
+// position returns where host appears in targets, or len(targets) if nowhere
+func (r *targetResults) position(host string) int {
+	if i, ok := r.index[host]; ok {
+		return i
+	}
+	return len(r.targets)
+}
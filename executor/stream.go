@@ -0,0 +1,199 @@
+package executor
+
+import (
+	"datacollector/csv"
+	"datacollector/database"
+	"datacollector/models"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultStreamBufferRows caps a streamed target's buffer when
+// stream_buffer_rows isn't set
+const DefaultStreamBufferRows = 10000
+
+// initialStreamBuffer is the first batch size of a streamed target: small,
+// so the first rows reach the file quickly, then doubled after every batch
+// up to the cap
+const initialStreamBuffer = 64
+
+// targetStream writes one target's rows to its per-target file while the
+// query is scanned (stream_per_target), so the target is never held in
+// memory as a whole. Rows are buffered, run through processResult one batch
+// at a time and flushed to the file.
+type targetStream struct {
+	host     string
+	workload *models.Workload
+	options  models.WriteOptions
+
+	columns     []string // Query columns, set by Begin
+	columnTypes []string
+	batch       [][]string
+	limit       int // Current batch size, doubling up to maxRows
+	maxRows     int
+	batches     int // Batches processed so far
+
+	path    string
+	file    *os.File
+	counter *byteCounter
+	writer  csv.Writer
+	header  []string // Processed columns, once written
+	types   []string
+
+	scanned      int   // Query rows received
+	scannedBytes int64 // Text size of their values
+	written      int   // Rows written after processing
+	watermark    string
+	marked       bool // Whether watermark holds a value
+}
+
+// newTargetStream prepares streaming host's rows to its per-target file
+func newTargetStream(host string, workload *models.Workload) *targetStream {
+	options := workload.WriteOptions()
+	options.Filename = fmt.Sprintf("%s_%s", workload.OutputFile, SanitizeHost(host))
+	maxRows := workload.StreamBufferRows
+	if maxRows <= 0 {
+		maxRows = DefaultStreamBufferRows
+	}
+	return &targetStream{
+		host:     host,
+		workload: workload,
+		options:  options,
+		limit:    min(initialStreamBuffer, maxRows),
+		maxRows:  maxRows,
+	}
+}
+
+// Begin records the query's columns; a rerun after a dropped connection is
+// only accepted while no row has been received
+func (s *targetStream) Begin(columns []string, columnTypes []string) error {
+	if s.scanned > 0 {
+		return fmt.Errorf("query rerun after %d rows were already streamed to %s", s.scanned, s.path)
+	}
+	s.columns, s.columnTypes = columns, columnTypes
+	return nil
+}
+
+// Row buffers one query row, writing the batch out once it is full
+func (s *targetStream) Row(row []string) error {
+	s.scanned++
+	s.scannedBytes += rowBytes([][]string{row})
+	s.batch = append(s.batch, row)
+	if len(s.batch) < s.limit {
+		return nil
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	s.limit = min(s.limit*2, s.maxRows)
+	return nil
+}
+
+// flush processes the buffered rows, writes them and flushes the file,
+// opening it and writing the header on the first call
+func (s *targetStream) flush() error {
+	// Track the highest watermark on the query's own values, as unstreamed targets do
+	if watermark := s.workload.Watermark; watermark != nil {
+		if value, ok := maxColumnValue(s.columns, s.batch, watermark.Column, s.workload.NullSentinel()); ok {
+			if !s.marked || watermarkGreater(value, s.watermark) {
+				s.watermark, s.marked = value, true
+			}
+		}
+	}
+
+	batch := &database.QueryResult{Columns: s.columns, ColumnTypes: s.columnTypes, Rows: s.batch}
+	processed, err := processResult(s.host, batch, s.workload, s.batches > 0)
+	if err != nil {
+		return err
+	}
+	s.batches++
+
+	if s.file == nil {
+		if err := s.open(processed); err != nil {
+			return err
+		}
+	}
+	for _, row := range processed.Rows {
+		if err := s.writer.Write(row); err != nil {
+			return fmt.Errorf("error writing data to %s: %w", s.path, err)
+		}
+	}
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return fmt.Errorf("error writing data to %s: %w", s.path, err)
+	}
+	s.written += len(processed.Rows)
+	s.batch = s.batch[:0]
+	return nil
+}
+
+// open creates the per-target file and writes the header of processed, plus
+// the types row when column_types is "row"
+func (s *targetStream) open(processed *database.QueryResult) error {
+	path, err := csv.OutputPath(s.options)
+	if err != nil {
+		return err
+	}
+	file, err := csv.CreateFile(path, s.options.FilePerm())
+	if err != nil {
+		return fmt.Errorf("error creating CSV file: %w", err)
+	}
+	s.path, s.file = path, file
+	s.counter = &byteCounter{w: file}
+	s.writer = csv.NewWriter(s.counter, s.options)
+	s.header = processed.Columns
+	s.types = csv.AlignTypes(processed.Columns, processed.ColumnTypes)
+
+	if err := s.writer.Write(s.header); err != nil {
+		return fmt.Errorf("error writing headers to %s: %w", path, err)
+	}
+	if s.options.ColumnTypesMode == models.ColumnTypesRow && len(s.header) > 0 {
+		if err := s.writer.Write(s.types); err != nil {
+			return fmt.Errorf("error writing column types to %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// finish writes the last batch and closes the file, returning its path and
+// size; the path is "" when write_empty is off and no row was written
+func (s *targetStream) finish() (string, int64, error) {
+	if s.file == nil && s.scanned == 0 && !s.workload.WritesEmpty() {
+		return "", 0, nil
+	}
+	if len(s.batch) > 0 || s.file == nil {
+		if err := s.flush(); err != nil {
+			return "", 0, err
+		}
+	}
+	if err := s.file.Close(); err != nil {
+		return "", 0, fmt.Errorf("error closing %s: %w", s.path, err)
+	}
+	if s.options.ColumnTypesMode == models.ColumnTypesSidecar && len(s.header) > 0 {
+		if err := csv.WriteTypesSidecar(s.path+".types", s.header, s.types, s.options.FilePerm()); err != nil {
+			return "", 0, err
+		}
+	}
+	return s.path, s.counter.n, nil
+}
+
+// abort discards a partly written file, e.g. after the query failed
+func (s *targetStream) abort() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.path)
+	}
+}
+
+// byteCounter counts the bytes written through it
+type byteCounter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
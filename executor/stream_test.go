@@ -0,0 +1,56 @@
+package executor
+
+import (
+	"strconv"
+	"testing"
+
+	"datacollector/csv"
+	"datacollector/models"
+)
+
+func TestTargetStreamBoundsBuffer(t *testing.T) {
+	const bufferRows, total = 100, 1050
+	workload := &models.Workload{OutputDir: t.TempDir(), OutputFile: "stream", StreamBufferRows: bufferRows}
+	stream := newTargetStream("db1:3306", workload)
+
+	if err := stream.Begin([]string{"id", "name"}, []string{"INT", "TEXT"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < total; i++ {
+		if err := stream.Row([]string{strconv.Itoa(i), "row " + strconv.Itoa(i)}); err != nil {
+			t.Fatalf("Row %d: %v", i, err)
+		}
+		if len(stream.batch) > stream.maxRows {
+			t.Fatalf("after row %d the buffer holds %d rows, more than stream_buffer_rows %d", i, len(stream.batch), stream.maxRows)
+		}
+	}
+	if stream.limit != bufferRows {
+		t.Errorf("batch size grew to %d, want the %d cap", stream.limit, bufferRows)
+	}
+	path, size, err := stream.finish()
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if size == 0 {
+		t.Errorf("finish reported 0 bytes written")
+	}
+
+	records, err := csv.ReadCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != total+1 {
+		t.Fatalf("file has %d records, want the header and %d rows", len(records), total)
+	}
+	if records[0][0] != "id" || records[0][1] != "name" {
+		t.Errorf("header = %v", records[0])
+	}
+	for i, record := range records[1:] {
+		if record[0] != strconv.Itoa(i) || record[1] != "row "+strconv.Itoa(i) {
+			t.Fatalf("record %d = %v, want row %d: rows were lost, repeated or reordered", i+1, record, i)
+		}
+	}
+	if stream.scanned != total || stream.written != total {
+		t.Errorf("scanned %d, written %d, want %d each", stream.scanned, stream.written, total)
+	}
+}
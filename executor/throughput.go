@@ -0,0 +1,21 @@
+package executor
+
+import "time"
+
+// TargetTiming is how long a successful target took and how much data its
+// query returned, for throughput reporting
+type TargetTiming struct {
+	Elapsed time.Duration // From the worker's start, after start_jitter, until the result was ready
+	Bytes   int64         // Text size of the values the query returned, before column settings
+}
+
+// rowBytes returns the text size of the values in rows
+func rowBytes(rows [][]string) int64 {
+	var size int64
+	for _, row := range rows {
+		for _, value := range row {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
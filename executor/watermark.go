@@ -0,0 +1,61 @@
+package executor
+
+import (
+	"datacollector/models"
+	"strconv"
+	"strings"
+)
+
+// watermarkPlaceholder is replaced by the last watermark value when present in the query
+const watermarkPlaceholder = models.WatermarkPlaceholder
+
+// applyWatermark bounds query to rows newer than last. A query containing
+// {{watermark}} gets the quoted value substituted; otherwise it is wrapped
+// as SELECT * FROM (<query>) WHERE <column> > <value>.
+func applyWatermark(query string, column string, last string) string {
+	literal := sqlLiteral(last)
+	if strings.Contains(query, watermarkPlaceholder) {
+		return strings.ReplaceAll(query, watermarkPlaceholder, literal)
+	}
+
+	inner := strings.TrimRight(strings.TrimSpace(query), ";")
+	return "SELECT * FROM (" + inner + ") AS watermark_source WHERE " + column + " > " + literal
+}
+
+// sqlLiteral quotes value as a SQL string literal
+func sqlLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// maxColumnValue returns the greatest non-NULL value of column across rows.
+// Values compare numerically when both parse as numbers and as strings
+// otherwise, which orders ISO-8601 timestamps correctly.
+func maxColumnValue(columns []string, rows [][]string, column string, nullValue string) (string, bool) {
+	index, ok := columnIndex(columns)[column]
+	if !ok {
+		return "", false
+	}
+
+	var max string
+	found := false
+	for _, row := range rows {
+		if index >= len(row) || row[index] == nullValue {
+			continue
+		}
+		if !found || watermarkGreater(row[index], max) {
+			max = row[index]
+			found = true
+		}
+	}
+	return max, found
+}
+
+// watermarkGreater reports whether a sorts after b
+func watermarkGreater(a string, b string) bool {
+	aNum, aErr := strconv.ParseFloat(a, 64)
+	bNum, bErr := strconv.ParseFloat(b, 64)
+	if aErr == nil && bErr == nil {
+		return aNum > bNum
+	}
+	return a > b
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"datacollector/database"
+	"datacollector/logging"
+	"datacollector/models"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// maxExplainValue bounds the values -explain-config shows, so a long query
+// or target list doesn't push the sources off screen
+const maxExplainValue = 80
+
+// configSource is one resolved setting shown by -explain-config
+type configSource struct {
+	setting string
+	value   string
+	source  string
+}
+
+// configExplanation collects where every resolved setting came from
+type configExplanation struct {
+	workloadFile string
+	loaded       bool              // Whether the workload file was read; otherwise its built-in defaults apply
+	flags        map[string]string // Flags given on the command line, by name
+	settings     []configSource
+}
+
+// add records a setting, encoding its value as JSON unless it is a string
+func (e *configExplanation) add(setting string, value interface{}, source string) {
+	text, ok := value.(string)
+	if !ok {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded = []byte(fmt.Sprint(value))
+		}
+		text = string(encoded)
+	}
+	text = strings.Join(strings.Fields(text), " ") // Queries span several lines
+	if len(text) > maxExplainValue {
+		text = text[:maxExplainValue-3] + "..."
+	}
+	e.settings = append(e.settings, configSource{setting: setting, value: text, source: source})
+}
+
+// fromFlag returns "flag -<name>" when the flag was given, else ""
+func (e *configExplanation) fromFlag(name string) string {
+	if _, ok := e.flags[name]; ok {
+		return "flag -" + name
+	}
+	return ""
+}
+
+// explainConfig writes every resolved setting with its value and where it
+// came from: a command-line flag, an environment variable (noting profile
+// prefixes and .env), a workload field, a target entry or the default.
+// Secrets are redacted as by -print-config.
+func explainConfig(out io.Writer, e *configExplanation, workload *models.Workload, dbConfig database.Config, dbHost string, env envProfile) error {
+	workloadCopy, redactedDB := redactConfig(workload, dbConfig)
+
+	for _, name := range sortedKeys(e.flags) {
+		e.add("-"+name, e.flags[name], "flag")
+	}
+	explainLogging(e)
+	explainDatabase(e, redactedDB, dbHost, workload, env)
+	if err := explainWorkload(e, workloadCopy); err != nil {
+		return err
+	}
+	explainTargets(e, workload.Targets, dbConfig)
+
+	fmt.Fprintf(out, "Configuration sources (secrets redacted; workload file %s):\n\n", e.workloadFile)
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "SETTING\tVALUE\tSOURCE")
+	for _, setting := range e.settings {
+		fmt.Fprintf(writer, "%s\t%s\t%s\n", setting.setting, setting.value, setting.source)
+	}
+	return writer.Flush()
+}
+
+// explainLogging explains the log level
+func explainLogging(e *configExplanation) {
+	source := firstNonEmpty(e.fromFlag("verbose"), e.fromFlag("quiet"))
+	if source == "" && os.Getenv("LOG_LEVEL") != "" {
+		source = "env LOG_LEVEL"
+	}
+	e.add("log_level", logging.CurrentLevel().String(), firstNonEmpty(source, "default"))
+}
+
+// explainDatabase explains the connection settings resolved from the DB_*
+// variables, the workload's database block and their fallbacks
+func explainDatabase(e *configExplanation, dbConfig database.Config, dbHost string, workload *models.Workload, env envProfile) {
+	profileSource := firstNonEmpty(e.fromFlag("profile"), "none")
+	if env.Name != "" && profileSource == "none" {
+		profileSource = "env DB_PROFILE"
+	}
+	e.add("profile", env.Name, profileSource)
+
+	variable := func(setting string, name string, value interface{}, secret bool, fallback string) {
+		e.add("database."+setting, value, firstNonEmpty(env.source(name, secret), fallback))
+	}
+	hostFallback := "default"
+	if len(workload.Targets) > 0 && dbHost == workload.Targets[0] {
+		hostFallback = "first target"
+	}
+	nameFallback := "unset"
+	if dbConfig.Database != "" && dbConfig.Database == workload.FilterPattern {
+		nameFallback = "workload field filter_pattern"
+	}
+	parseTime := ""
+	if dbConfig.ParseTime != nil {
+		parseTime = strconv.FormatBool(*dbConfig.ParseTime)
+	}
+
+	variable("type", "DB_TYPE", dbConfig.Type, false, "default")
+	variable("host", "DB_HOST", dbHost, false, hostFallback)
+	variable("port", "DB_PORT", dbConfig.Port, false, "default for "+dbConfig.Type)
+	variable("user", "DB_USER", dbConfig.User, true, "default")
+	variable("password", "DB_PASSWORD", dbConfig.Password, true, "unset")
+	variable("database", "DB_NAME", dbConfig.Database, false, nameFallback)
+	variable("ssl_mode", "DB_SSL_MODE", dbConfig.SSLMode, false, "unset")
+	variable("socket", "DB_SOCKET", dbConfig.Socket, false, "unset")
+	variable("charset", "DB_CHARSET", dbConfig.Charset, false, "unset")
+	variable("collation", "DB_COLLATION", dbConfig.Collation, false, "unset")
+	variable("loc", "DB_LOC", dbConfig.Loc, false, "unset")
+	variable("parse_time", "DB_PARSE_TIME", parseTime, false, "unset")
+	variable("ssl_root_cert", "DB_SSL_ROOT_CERT", dbConfig.SSLRootCert, false, "unset")
+	variable("ssl_cert", "DB_SSL_CERT", dbConfig.SSLCert, false, "unset")
+	variable("ssl_key", "DB_SSL_KEY", dbConfig.SSLKey, false, "unset")
+
+	proxySource := "unset"
+	switch {
+	case workload.Proxy != "":
+		proxySource = "workload field proxy"
+	case os.Getenv("HTTPS_PROXY") != "":
+		proxySource = env.variableSource("HTTPS_PROXY")
+	case os.Getenv("https_proxy") != "":
+		proxySource = env.variableSource("https_proxy")
+	}
+	if dbConfig.Proxy == "" && proxySource != "unset" {
+		proxySource += ", ignored because ssh_tunnel is set"
+	}
+	e.add("database.proxy", dbConfig.Proxy, proxySource)
+}
+
+// explainWorkload explains every workload field: set in the workload file,
+// changed at startup or left at its default
+func explainWorkload(e *configExplanation, workload *models.Workload) error {
+	present := make(map[string]bool)
+	if e.loaded {
+		data, err := os.ReadFile(e.workloadFile)
+		if err != nil {
+			return err
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		for key := range fields {
+			present[key] = true
+		}
+	}
+
+	value := reflect.ValueOf(*workload)
+	for i := 0; i < value.NumField(); i++ {
+		key, _, _ := strings.Cut(value.Type().Field(i).Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		field := value.Field(i)
+
+		source := "default"
+		switch {
+		case !e.loaded:
+			source = "built-in default (workload file not loaded)"
+		case present[key]:
+			source = "workload field " + key
+		case !field.IsZero():
+			source = "set at startup"
+		}
+		switch {
+		case key == "targets" && (e.fromFlag("only") != "" || e.fromFlag("skip") != ""):
+			source += ", narrowed by -only/-skip"
+		case key == "sample_seed" && !present[key] && !field.IsZero():
+			source = "random for this run (sample_seed not set)"
+		}
+		e.add(key, field.Interface(), source)
+	}
+	return nil
+}
+
+// explainTargets explains, for every candidate host of every target, the
+// connection settings its entry overrides, the way the executor applies them
+func explainTargets(e *configExplanation, targets []string, dbConfig database.Config) {
+	for _, entry := range targets {
+		for _, candidate := range database.SplitCandidates(entry) {
+			target, err := database.ParseTarget(candidate, dbConfig.Type)
+			if err != nil {
+				e.add("target "+candidate, "", "invalid: "+err.Error())
+				continue
+			}
+			prefix := "target " + candidate + "."
+
+			typeSource := "database.type"
+			if target.Type != dbConfig.Type {
+				typeSource = "target entry"
+			}
+			e.add(prefix+"type", target.Type, typeSource)
+
+			if target.Socket != "" {
+				e.add(prefix+"socket", target.Socket, "target entry")
+			} else {
+				e.add(prefix+"host", target.Host, "target entry")
+				port, portSource := target.Port, "target entry"
+				if port == 0 && target.Type != dbConfig.Type {
+					port, portSource = database.DefaultPort(target.Type), "default for "+target.Type
+				} else if port == 0 {
+					port, portSource = dbConfig.Port, "database.port"
+				}
+				e.add(prefix+"port", port, portSource)
+			}
+			databaseName, databaseSource := target.Database, "target entry"
+			if databaseName == "" {
+				databaseName, databaseSource = dbConfig.Database, "database.database"
+			}
+			e.add(prefix+"database", databaseName, databaseSource)
+		}
+	}
+}
+
+// sortedKeys returns the keys of values in order
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
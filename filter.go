@@ -0,0 +1,99 @@
+package main
+
+import (
+	"datacollector/database"
+	"fmt"
+	"strings"
+)
+
+// splitPatterns splits a comma-separated -only/-skip value into patterns
+func splitPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// filterTargets applies the -only and -skip flags to targets, keeping the
+// original order. It returns the targets to run and the ones left out.
+func filterTargets(targets []string, only string, skip string) ([]string, []string, error) {
+	onlyPatterns := splitPatterns(only)
+	skipPatterns := splitPatterns(skip)
+	if len(onlyPatterns) == 0 && len(skipPatterns) == 0 {
+		return targets, nil, nil
+	}
+
+	var included, excluded []string
+	for _, target := range targets {
+		keep := true
+		if len(onlyPatterns) > 0 {
+			matched, err := database.MatchesTarget(target, onlyPatterns)
+			if err != nil {
+				return nil, nil, fmt.Errorf("-only: %w", err)
+			}
+			keep = matched
+		}
+		if keep && len(skipPatterns) > 0 {
+			matched, err := database.MatchesTarget(target, skipPatterns)
+			if err != nil {
+				return nil, nil, fmt.Errorf("-skip: %w", err)
+			}
+			keep = !matched
+		}
+
+		if keep {
+			included = append(included, target)
+		} else {
+			excluded = append(excluded, target)
+		}
+	}
+	return included, excluded, nil
+}
+
+// targetCounts records how the run's targets were resolved, stage by stage,
+// so a run left without targets can say where they went
+type targetCounts struct {
+	inline     int  // Entries of the workload's targets
+	discovery  bool // Whether a discovery query ran
+	discovered int  // Targets the discovery query returned
+	added      int  // Discovered targets not already listed inline
+	filtered   bool // Whether -only/-skip were given
+	selected   int  // Targets left to run
+}
+
+func (c targetCounts) String() string {
+	parts := []string{fmt.Sprintf("inline %d", c.inline)}
+	if c.discovery {
+		parts = append(parts, fmt.Sprintf("discovered %d (%d new)", c.discovered, c.added))
+	}
+	if c.filtered {
+		parts = append(parts, fmt.Sprintf("after -only/-skip %d", c.selected))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkSelected fails when no target is left to run, naming the stage that
+// removed them
+func (c targetCounts) checkSelected(only string, skip string) error {
+	if c.selected > 0 {
+		return nil
+	}
+	switch {
+	case c.filtered && c.inline+c.added > 0:
+		var flags []string
+		if only != "" {
+			flags = append(flags, fmt.Sprintf("-only %q", only))
+		}
+		if skip != "" {
+			flags = append(flags, fmt.Sprintf("-skip %q", skip))
+		}
+		return fmt.Errorf("no targets left (%s): %s excluded all %d", c, strings.Join(flags, " "), c.inline+c.added)
+	case c.discovery:
+		return fmt.Errorf("no targets left (%s): list targets in the workload or check discovery.query", c)
+	default:
+		return fmt.Errorf("no targets left (%s): list hosts in targets or configure discovery in the workload", c)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"datacollector/logging"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPostCommandTimeout bounds post_command when post_command_timeout isn't set
+const defaultPostCommandTimeout = 5 * time.Minute
+
+// Environment variables describing the run to post_command
+const (
+	envOutputFiles = "DATACOLLECTOR_OUTPUT_FILES" // Output files, one absolute path per line
+	envTotalRows   = "DATACOLLECTOR_TOTAL_ROWS"   // Rows collected over all queries
+)
+
+// postCommandSummary records the outcome of post_command in the run summary
+type postCommandSummary struct {
+	Command        []string `json:"command"`
+	ExitCode       int      `json:"exit_code"` // -1 when the command didn't start or was killed
+	ElapsedSeconds float64  `json:"elapsed_seconds"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// runPostCommand runs the workload's post_command once the output has been
+// written, passing every output file as an extra argument and describing the
+// run in DATACOLLECTOR_* environment variables. The command's stdout and
+// stderr are logged line by line as it runs. It fails when the command can't
+// start, exits non-zero or outlives timeout.
+func runPostCommand(command []string, timeout time.Duration, summary *runSummary) error {
+	if timeout <= 0 {
+		timeout = defaultPostCommandTimeout
+	}
+
+	files, rows := outputFiles(summary)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], append(append([]string(nil), command[1:]...), files...)...)
+	cmd.Env = append(os.Environ(),
+		envOutputFiles+"="+strings.Join(files, "\n"),
+		envTotalRows+"="+strconv.Itoa(rows))
+	stdout := &lineLogger{stream: "stdout"}
+	stderr := &lineLogger{stream: "stderr"}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.WaitDelay = time.Second // Don't wait on pipes held open by the command's children
+
+	logging.Infof("Running post_command %s with %d output file(s)", command[0], len(files))
+	started := time.Now()
+	err := cmd.Run()
+	stdout.close()
+	stderr.close()
+
+	result := &postCommandSummary{Command: command, ExitCode: -1, ElapsedSeconds: time.Since(started).Seconds()}
+	summary.PostCommand = result
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %v (post_command_timeout)", timeout)
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return err
+	}
+	logging.Infof("post_command finished in %v", time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// outputFiles returns the absolute paths of the files written by every
+// query, in order and without repeats, and the rows collected
+func outputFiles(summary *runSummary) ([]string, int) {
+	seen := make(map[string]bool)
+	var files []string
+	rows := 0
+	for _, query := range summary.Queries {
+		rows += query.Rows
+		for _, file := range query.Files {
+			if abs, err := filepath.Abs(file); err == nil {
+				file = abs
+			}
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files, rows
+}
+
+// lineLogger logs each complete line written to it as it arrives, so a
+// long-running command's output shows up while it runs
+type lineLogger struct {
+	stream string
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.log(l.buf[:i])
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// close logs a last line left without a newline
+func (l *lineLogger) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.buf) > 0 {
+		l.log(l.buf)
+		l.buf = nil
+	}
+}
+
+func (l *lineLogger) log(line []byte) {
+	logging.Infof("post_command %s: %s", l.stream, strings.TrimRight(string(line), "\r"))
+}
@@ -0,0 +1,99 @@
+// Package logging gates the collector's log output by level
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is a log verbosity; each level includes the ones before it
+type Level int32
+
+// Supported levels, from least to most verbose
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+// levelNames maps levels to the names accepted by ParseLevel
+var levelNames = map[Level]string{
+	LevelError: "error",
+	LevelWarn:  "warn",
+	LevelInfo:  "info",
+	LevelDebug: "debug",
+}
+
+// current is the active level; info matches the collector's historic output
+var current atomic.Int32
+
+func init() {
+	current.Store(int32(LevelInfo))
+}
+
+// String returns the level's name
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Level(%d)", int32(l))
+}
+
+// ParseLevel parses "error", "warn" (or "warning"), "info" or "debug"
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		return LevelWarn, nil
+	}
+	for level, levelName := range levelNames {
+		if levelName == name {
+			return level, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (supported: error, warn, info, debug)", name)
+}
+
+// SetLevel changes the active level
+func SetLevel(level Level) {
+	current.Store(int32(level))
+}
+
+// CurrentLevel returns the active level
+func CurrentLevel() Level {
+	return Level(current.Load())
+}
+
+// Enabled reports whether messages at level are logged
+func Enabled(level Level) bool {
+	return level <= CurrentLevel()
+}
+
+// logf logs through the standard logger when level is enabled
+func logf(level Level, format string, args ...interface{}) {
+	if Enabled(level) {
+		log.Output(3, fmt.Sprintf(format, args...))
+	}
+}
+
+// Errorf logs failures; these are always shown
+func Errorf(format string, args ...interface{}) {
+	logf(LevelError, format, args...)
+}
+
+// Warnf logs problems the run recovers from
+func Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, format, args...)
+}
+
+// Infof logs normal progress
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, format, args...)
+}
+
+// Debugf logs per-worker and per-page detail
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, format, args...)
+}
@@ -1,29 +1,454 @@
 package main
 
 import (
+	"context"
 	"datacollector/csv"
 	"datacollector/database"
 	"datacollector/executor"
+	"datacollector/logging"
+	"datacollector/metrics"
 	"datacollector/models"
+	"datacollector/output"
+	"datacollector/state"
+	"datacollector/transform"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"math/rand/v2"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// getSecretEnv returns the value of the named environment variable, or the
+// contents of the file named by <name>_FILE when that variable is set.
+// The file takes precedence over the inline value; trailing newlines are trimmed.
+func getSecretEnv(name string) (string, error) {
+	filePath := os.Getenv(name + "_FILE")
+	if filePath == "" {
+		return os.Getenv(name), nil
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("%s_FILE is set but %s could not be read: %w", name, filePath, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// buildSinks composes the output sinks selected by the workload's destinations.
+// With no destinations configured the aggregated result is written to a CSV file.
+// Uploads to remote destinations are cancelled when ctx is done.
+func buildSinks(ctx context.Context, workload *models.Workload) (output.MultiSink, error) {
+	destinations := workload.Destinations
+	if len(destinations) == 0 {
+		destinations = []string{models.DestinationFile}
+	}
+
+	var sinks output.MultiSink
+	var fileSink output.FileSink // The local file(s) a "gcs" destination uploads
+	for _, destination := range destinations {
+		switch destination {
+		case models.DestinationFile:
+			formats := workload.OutputFormat.List()
+			switch {
+			case workload.PartitionBy != "":
+				fileSink = output.NewPartitionedCSVSink(workload.WriteOptions(), workload.OutputColumn(workload.PartitionBy))
+			case len(formats) == 1 && formats[0] != models.OutputFormatJSON:
+				fileSink = output.NewCSVSink(workload.WriteOptions())
+			default:
+				fileSink = output.NewFormatsSink(workload.WriteOptions(), formats)
+			}
+			sinks = append(sinks, fileSink)
+		case models.DestinationStdout:
+			sinks = append(sinks, &output.StdoutSink{Comma: workload.WriteOptions().Comma()})
+		case models.DestinationHTTP:
+			httpOutput := workload.HTTPOutput
+			if httpOutput == nil || httpOutput.URL == "" {
+				return nil, fmt.Errorf("destination %q requires http_output.url", destination)
+			}
+			if httpOutput.Format != "" && httpOutput.Format != output.HTTPFormatJSON && httpOutput.Format != output.HTTPFormatNDJSON {
+				return nil, fmt.Errorf("unsupported http_output.format %q (supported: %s, %s)",
+					httpOutput.Format, output.HTTPFormatJSON, output.HTTPFormatNDJSON)
+			}
+			sinks = append(sinks, &output.HTTPSink{
+				URL:       httpOutput.URL,
+				Headers:   httpOutput.Headers,
+				Format:    httpOutput.Format,
+				BatchSize: httpOutput.BatchSize,
+				Retries:   httpOutput.Retries,
+				Backoff:   httpOutput.RetryBackoff.Duration,
+				NullValue: workload.NullSentinel(),
+				Context:   ctx,
+			})
+		case models.DestinationGCS:
+			if workload.GCS == nil || workload.GCS.Bucket == "" {
+				return nil, fmt.Errorf("destination %q requires gcs.bucket", destination)
+			}
+			if fileSink == nil {
+				return nil, fmt.Errorf("destination %q uploads the local file, so %q must be listed before it",
+					destination, models.DestinationFile)
+			}
+			sinks = append(sinks, &output.GCSSink{
+				Bucket:  workload.GCS.Bucket,
+				Prefix:  workload.GCS.Prefix,
+				Source:  fileSink,
+				Context: ctx,
+			})
+		case models.DestinationSQLite:
+			sqliteOutput := workload.SQLite
+			if sqliteOutput == nil || sqliteOutput.Path == "" {
+				return nil, fmt.Errorf("destination %q requires sqlite.path", destination)
+			}
+			if sqliteOutput.Mode != "" && sqliteOutput.Mode != output.SQLiteModeAppend && sqliteOutput.Mode != output.SQLiteModeReplace {
+				return nil, fmt.Errorf("unsupported sqlite.mode %q (supported: %s, %s)",
+					sqliteOutput.Mode, output.SQLiteModeAppend, output.SQLiteModeReplace)
+			}
+			table := sqliteOutput.Table
+			if table == "" {
+				table = workload.QueryName
+			}
+			if table == "" {
+				table = "results"
+			}
+			sinks = append(sinks, &output.SQLiteSink{
+				Path:      sqliteOutput.Path,
+				Table:     table,
+				Mode:      sqliteOutput.Mode,
+				NullValue: workload.NullSentinel(),
+			})
+		case models.DestinationKafka:
+			kafkaOutput := workload.Kafka
+			if kafkaOutput == nil || len(kafkaOutput.Brokers) == 0 || kafkaOutput.Topic == "" {
+				return nil, fmt.Errorf("destination %q requires kafka.brokers and kafka.topic", destination)
+			}
+			switch kafkaOutput.RequiredAcks {
+			case "", output.KafkaAcksAll, output.KafkaAcksOne, output.KafkaAcksNone:
+			default:
+				return nil, fmt.Errorf("unsupported kafka.required_acks %q (supported: %s, %s, %s)",
+					kafkaOutput.RequiredAcks, output.KafkaAcksAll, output.KafkaAcksOne, output.KafkaAcksNone)
+			}
+			keyColumn := ""
+			if kafkaOutput.KeyColumn != "" {
+				keyColumn = workload.OutputColumn(kafkaOutput.KeyColumn)
+			}
+			sinks = append(sinks, &output.KafkaSink{
+				Brokers:      kafkaOutput.Brokers,
+				Topic:        kafkaOutput.Topic,
+				KeyColumn:    keyColumn,
+				BatchSize:    kafkaOutput.BatchSize,
+				BatchTimeout: kafkaOutput.BatchTimeout.Duration,
+				RequiredAcks: kafkaOutput.RequiredAcks,
+				Retries:      kafkaOutput.Retries,
+				NullValue:    workload.NullSentinel(),
+			})
+		default:
+			return nil, fmt.Errorf("unsupported destination %q (supported: %s, %s, %s, %s, %s, %s)",
+				destination, models.DestinationFile, models.DestinationStdout, models.DestinationHTTP,
+				models.DestinationGCS, models.DestinationSQLite, models.DestinationKafka)
+		}
+	}
+
+	return sinks, nil
+}
+
+// selectTargets applies the -only/-skip filters to the workload's targets,
+// logs how the targets were resolved and stops the run when none are left,
+// then sizes an automatic worker pool from the targets left to run
+func selectTargets(workload *models.Workload, only string, skip string, counts targetCounts) {
+	// Narrow the targets down for this run without editing the configuration
+	if only != "" || skip != "" {
+		counts.filtered = true
+		included, excluded, err := filterTargets(workload.Targets, only, skip)
+		if err != nil {
+			log.Fatalf("Invalid target filter: %v", err)
+		}
+		logging.Infof("Target filter: running %d target(s): %v", len(included), included)
+		if len(excluded) > 0 {
+			logging.Infof("Target filter: skipping %d target(s): %v", len(excluded), excluded)
+		}
+		workload.Targets = included
+	}
+	counts.selected = len(workload.Targets)
+	logging.Infof("Targets resolved: %s", counts)
+	if err := counts.checkSelected(only, skip); err != nil {
+		log.Fatalf("Nothing to collect: %v.", err)
+	}
+
+	// A group matching nothing is most likely a typo in its patterns
+	for _, group := range workload.TargetGroups {
+		matched := false
+		for _, target := range workload.Targets {
+			if ok, _ := database.MatchesTarget(target, group.Targets); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			logging.Warnf("Warning: target group %s matches none of the targets being run", group.Name)
+		}
+	}
+
+	// Size an automatic worker pool from the CPUs and the targets actually run
+	if workload.Workers.IsAuto() {
+		workload.Workers = models.Workers(workload.Workers.Resolve(len(workload.Targets)))
+		logging.Infof("Workers set to auto: using %d worker(s) (%d CPU(s), %d target(s))",
+			workload.Workers, runtime.NumCPU(), len(workload.Targets))
+	}
+}
+
+// validateTargets checks that every target is unique and parses, including
+// each of its failover candidates, and that this build has their drivers
+func validateTargets(targets []string, dbType string) error {
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		// Results, errors and files are all keyed by target, so each must be unique
+		if seen[target] {
+			return fmt.Errorf("target %q is listed more than once", target)
+		}
+		seen[target] = true
+
+		candidates := database.SplitCandidates(target)
+		if len(candidates) == 0 {
+			return fmt.Errorf("target %q has no hosts", target)
+		}
+		for _, candidate := range candidates {
+			parsed, err := database.ParseTarget(candidate, dbType)
+			if err != nil {
+				return err
+			}
+			if err := database.CheckDriver(parsed.Type); err != nil {
+				return fmt.Errorf("target %q: %w", candidate, err)
+			}
+		}
+	}
+	return nil
+}
+
+// targetsUse reports whether any target, or any of its failover candidates,
+// connects to a database of type want
+func targetsUse(targets []string, dbType string, want string) bool {
+	for _, target := range targets {
+		for _, candidate := range database.SplitCandidates(target) {
+			if parsed, err := database.ParseTarget(candidate, dbType); err == nil && parsed.Type == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// targetsNameDatabase reports whether every target, including each failover
+// candidate, names its own database, so DB_NAME isn't needed
+func targetsNameDatabase(targets []string, dbType string) bool {
+	if len(targets) == 0 {
+		return false
+	}
+	for _, target := range targets {
+		for _, candidate := range database.SplitCandidates(target) {
+			if parsed, err := database.ParseTarget(candidate, dbType); err != nil || parsed.Database == "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// setsStatementTimeout reports whether an init_sql statement sets PostgreSQL's statement_timeout
+func setsStatementTimeout(statements []string) bool {
+	for _, statement := range statements {
+		if strings.Contains(strings.ToLower(statement), "statement_timeout") {
+			return true
+		}
+	}
+	return false
+}
+
+// writesOutputDir reports whether the run writes files to the output directory
+func writesOutputDir(workload *models.Workload) bool {
+	if len(workload.Destinations) == 0 || workload.PerTargetOutput || workload.SpillThreshold > 0 || workload.CaptureExplain {
+		return true
+	}
+	for _, destination := range workload.Destinations {
+		if destination == models.DestinationFile {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeFiles concatenates the CSV files matching pattern into one output file
+func mergeFiles(pattern string, outputPath string, workload *models.Workload) error {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid glob %q: %w", pattern, err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no files match %q", pattern)
+	}
+	sort.Strings(paths)
+
+	if outputPath == "" {
+		options := workload.WriteOptions()
+		options.Filename = workload.OutputFile + "_merged"
+		options.Format = models.OutputFormatCSV // Merging reads and writes CSV
+		outputPath, err = csv.OutputPath(options)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Never read the file we are writing to
+	absOutput, _ := filepath.Abs(outputPath)
+	inputs := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if absPath, _ := filepath.Abs(path); absPath != absOutput {
+			inputs = append(inputs, path)
+		}
+	}
+
+	logging.Infof("Merging %d file(s) matching %s into %s", len(inputs), pattern, outputPath)
+	rows, err := csv.MergeCSVFiles(inputs, outputPath, workload.WriteOptions().FilePerm())
+	if err != nil {
+		return err
+	}
+	logging.Infof("Merged %d rows into %s", rows, absOutput)
+	return nil
+}
+
+// redacted replaces secret values in printed configuration
+const redacted = "REDACTED"
+
+// redactURL hides the password of a URL's user info, e.g. in logged proxy URLs
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.User == nil {
+		return raw
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), redacted)
+	}
+	return parsed.String()
+}
+
+// firstNonEmpty returns the first of values that isn't empty
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// printEffectiveConfig writes the resolved workload and database configuration
+// to stdout as indented JSON, with secrets redacted
+func printEffectiveConfig(workload *models.Workload, dbConfig database.Config) error {
+	workloadCopy, dbConfig := redactConfig(workload, dbConfig)
+	data, err := json.MarshalIndent(struct {
+		Workload *models.Workload `json:"workload"`
+		Database database.Config  `json:"database"`
+	}{workloadCopy, dbConfig}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// redactConfig returns copies of the workload and database configuration with
+// passwords, passphrases, HTTP headers and proxy credentials redacted; the
+// real configuration is left untouched
+func redactConfig(workload *models.Workload, dbConfig database.Config) (*models.Workload, database.Config) {
+	workloadCopy := *workload
+	if workload.SSHTunnel != nil {
+		tunnel := *workload.SSHTunnel
+		if tunnel.KeyPassphrase != "" {
+			tunnel.KeyPassphrase = redacted
+		}
+		workloadCopy.SSHTunnel = &tunnel
+	}
+	if workload.HTTPOutput != nil {
+		httpOutput := *workload.HTTPOutput
+		httpOutput.Headers = make(map[string]string, len(workload.HTTPOutput.Headers))
+		for name := range workload.HTTPOutput.Headers {
+			httpOutput.Headers[name] = redacted // Headers usually carry auth tokens
+		}
+		workloadCopy.HTTPOutput = &httpOutput
+	}
+
+	if workload.Database != nil && workload.Database.Password != "" {
+		databaseCopy := *workload.Database
+		databaseCopy.Password = redacted
+		workloadCopy.Database = &databaseCopy
+	}
+
+	if dbConfig.Password != "" {
+		dbConfig.Password = redacted
+	}
+	dbConfig.Proxy = redactURL(dbConfig.Proxy)
+	workloadCopy.Proxy = redactURL(workload.Proxy)
+	if dbConfig.SSH != nil {
+		sshConfig := *dbConfig.SSH
+		if sshConfig.KeyPassphrase != "" {
+			sshConfig.KeyPassphrase = redacted
+		}
+		dbConfig.SSH = &sshConfig
+	}
+	return &workloadCopy, dbConfig
+}
+
 func main() {
-	// Only accept workload file as command-line argument
+	// Command-line arguments
 	workloadFile := flag.String("workload", "workload.json", "Path to workload configuration file")
+	printConfig := flag.Bool("print-config", false, "Print the resolved configuration as JSON and exit")
+	explainConfigFlag := flag.Bool("explain-config", false, "Print every resolved setting with the flag, variable, workload field or default it came from, and exit")
+	onlyTargets := flag.String("only", "", "Comma-separated targets (or globs) to run; all others are skipped")
+	skipTargets := flag.String("skip", "", "Comma-separated targets (or globs) not to run")
+	mergeGlob := flag.String("merge", "", "Merge previously written CSV files matching this glob into one file and exit")
+	profileName := flag.String("profile", "", "Database profile: read <PROFILE>_DB_* variables before the unprefixed DB_* ones (default: DB_PROFILE)")
+	mergeOutput := flag.String("merge-output", "", "Output path for -merge (default: <outdir>/<outfile>_merged_<timestamp>.csv)")
+	verbose := flag.Bool("verbose", false, "Log debug detail (same as LOG_LEVEL=debug)")
+	quiet := flag.Bool("quiet", false, "Only log warnings and errors (same as LOG_LEVEL=warn)")
+	listEmpty := flag.Bool("list-empty", false, "After the run, print the targets that succeeded but returned no rows to standard output")
+	noCache := flag.Bool("no-cache", false, "Query every target even when cache_ttl has a fresh cached result (the cache is still refreshed)")
+	benchmark := flag.Bool("benchmark", false, "Report rows and bytes per second collected, overall and per target, in the run summary")
+	resume := flag.Bool("resume", false, "Skip the targets the checkpoint lists as written by an interrupted run and append to its output")
 	flag.Parse()
 
+	// Resolve the log level: the flags win over LOG_LEVEL, info is the default
+	if *verbose && *quiet {
+		log.Fatal("-verbose and -quiet cannot be used together.")
+	}
+	switch {
+	case *verbose:
+		logging.SetLevel(logging.LevelDebug)
+	case *quiet:
+		logging.SetLevel(logging.LevelWarn)
+	case os.Getenv("LOG_LEVEL") != "":
+		level, err := logging.ParseLevel(os.Getenv("LOG_LEVEL"))
+		if err != nil {
+			log.Fatalf("Invalid LOG_LEVEL: %v", err)
+		}
+		logging.SetLevel(level)
+	}
+
 	// Load workload configuration
 	workload, err := models.LoadWorkloadConfig(*workloadFile)
+	workloadLoaded := err == nil
 	if err != nil {
-		log.Printf("Warning: Failed to load workload file %s: %v", *workloadFile, err)
+		logging.Warnf("Warning: Failed to load workload file %s: %v", *workloadFile, err)
 		// Initialize with default values if file cannot be loaded
 		workload = &models.Workload{
 			Workers:       1,
@@ -33,82 +458,439 @@ func main() {
 			OutputDir:     "./output",
 			OutputFile:    "query_results",
 		}
-	} else {
-		// Ensure Workers is at least 1
-		if workload.Workers <= 0 {
-			log.Printf("Warning: Invalid number of workers (%d) specified in workload.json. Defaulting to 1.", workload.Workers)
-			workload.Workers = 1
-		}
+	} else if workload.Workers < 0 {
+		// Negative counts are invalid; 0 means "auto" and is resolved below
+		logging.Warnf("Warning: Invalid number of workers (%d) specified in workload.json. Defaulting to 1.", workload.Workers)
+		workload.Workers = 1
 	}
 
-	log.Printf("Loaded workload configuration from %s: Workers=%d, Targets=%v, Output=%s, FilterPattern=%s, Query=%s",
+	logging.Infof("Loaded workload configuration from %s: Workers=%d, Targets=%v, Output=%s, FilterPattern=%s, Query=%s",
 		*workloadFile, workload.Workers, workload.Targets, workload.Output, workload.FilterPattern, workload.Query)
 
-	// Load environment variables from .env file
+	// Merge mode only concatenates existing files; no database is involved
+	if *mergeGlob != "" {
+		if err := mergeFiles(*mergeGlob, *mergeOutput, workload); err != nil {
+			log.Fatalf("Merge failed: %v", err)
+		}
+		return
+	}
+
+	// With discovery the full target list is only known once it has run
+	counts := targetCounts{inline: len(workload.Targets)}
+	if workload.Discovery == nil {
+		selectTargets(workload, *onlyTargets, *skipTargets, counts)
+	}
+
+	// Load environment variables from .env file, noting which ones it set
+	// (it never overrides the environment) for -explain-config
+	dotEnv := make(map[string]bool)
+	if values, err := godotenv.Read(); err == nil {
+		for key := range values {
+			if _, set := os.LookupEnv(key); !set {
+				dotEnv[key] = true
+			}
+		}
+	}
 	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found or could not be loaded: %v", err)
+		logging.Warnf("Warning: .env file not found or could not be loaded: %v", err)
+	}
+
+	// Select the profile whose prefixed variables override the DB_* defaults
+	if *profileName == "" {
+		*profileName = os.Getenv("DB_PROFILE")
+	}
+	env, err := loadProfile(*profileName)
+	if err != nil {
+		log.Fatalf("Invalid database profile: %v", err)
+	}
+	env.DotEnv = dotEnv
+	if env.Name != "" {
+		logging.Infof("Using database profile %s", env.Name)
+	}
+	if workload.Database != nil {
+		if workload.Database.Port < 0 {
+			log.Fatalf("Invalid database.port %d in workload configuration.", workload.Database.Port)
+		}
+		env.Workload = workload.Database.Variables()
+		names := make([]string, 0, len(env.Workload))
+		for name := range env.Workload {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		logging.Infof("Using database settings from workload configuration for %s; other DB_* settings come from the environment", strings.Join(names, ", "))
 	}
 
 	// Get database configuration from environment variables
-	dbType := os.Getenv("DB_TYPE")
+	dbType := env.Getenv("DB_TYPE")
 	if dbType == "" {
 		dbType = "mysql" // Default to MySQL for backward compatibility
 	}
 
-	dbHost := os.Getenv("DB_HOST")
+	dbHost := env.Getenv("DB_HOST")
 	if dbHost == "" {
 		// Use the first target from workload.json if available, otherwise default to localhost
 		if len(workload.Targets) > 0 {
 			dbHost = workload.Targets[0]
-			log.Printf("DB_HOST not specified in .env, using first target from workload.json: %s", dbHost)
+			logging.Infof("DB_HOST not specified in .env, using first target from workload.json: %s", dbHost)
 		} else {
 			dbHost = "localhost" // Default value
-			log.Printf("DB_HOST not specified in .env and no targets in workload.json, using default: %s", dbHost)
+			logging.Infof("DB_HOST not specified in .env and no targets in workload.json, using default: %s", dbHost)
 		}
 	}
 
-	dbPortStr := os.Getenv("DB_PORT")
-	dbPort := 3306 // Default value for MySQL
-	if dbType == "postgres" && dbPortStr == "" {
-		dbPort = 5432 // Default value for PostgreSQL
-	} else if dbPortStr != "" {
+	dbPortStr := env.Getenv("DB_PORT")
+	dbPort := database.DefaultPort(dbType)
+	if dbPortStr != "" {
 		port, err := strconv.Atoi(dbPortStr)
 		if err == nil {
 			dbPort = port
 		} else {
-			log.Printf("Warning: Invalid DB_PORT in .env file, using default: %v", err)
+			logging.Warnf("Warning: Invalid DB_PORT in .env file, using default: %v", err)
 		}
 	}
+	if dbPort == 0 {
+		log.Fatalf("No default port known for DB_TYPE %q; set DB_PORT in .env file.", dbType)
+	}
 
-	dbUser := os.Getenv("DB_USER")
+	dbUser, err := env.SecretEnv("DB_USER")
+	if err != nil {
+		log.Fatalf("Failed to load database user: %v", err)
+	}
 	if dbUser == "" {
 		dbUser = "root" // Default value
 	}
 
-	dbPass := os.Getenv("DB_PASSWORD")
-	dbName := os.Getenv("DB_NAME")
-	dbSSLMode := os.Getenv("DB_SSL_MODE")
+	dbPass, err := env.SecretEnv("DB_PASSWORD")
+	if err != nil {
+		log.Fatalf("Failed to load database password: %v", err)
+	}
+	dbName := env.Getenv("DB_NAME")
+	dbSSLMode := env.Getenv("DB_SSL_MODE")
+	dbSocket := env.Getenv("DB_SOCKET")
+	dbCharset := env.Getenv("DB_CHARSET")
+	dbCollation := env.Getenv("DB_COLLATION")
+	dbLoc := env.Getenv("DB_LOC")
+	var dbParseTime *bool
+	if value := env.Getenv("DB_PARSE_TIME"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatalf("Invalid DB_PARSE_TIME %q in .env file: %v", value, err)
+		}
+		dbParseTime = &parsed
+	}
+	dbSSLRootCert := env.Getenv("DB_SSL_ROOT_CERT")
+	dbSSLCert := env.Getenv("DB_SSL_CERT")
+	dbSSLKey := env.Getenv("DB_SSL_KEY")
 
 	// If DB_NAME is not set, use filter_pattern from workload.json
 	if dbName == "" {
 		dbName = workload.FilterPattern
-		log.Printf("DB_NAME not specified in .env, using filter_pattern from workload.json: %s", dbName)
+		logging.Infof("DB_NAME not specified in .env, using filter_pattern from workload.json: %s", dbName)
 	}
 
 	// Check required parameters
-	if dbName == "" {
-		log.Fatal("Database name is required. Set DB_NAME in .env file or provide filter_pattern in workload.json.")
+	if dbName == "" && (workload.Discovery != nil || !targetsNameDatabase(workload.Targets, dbType)) {
+		log.Fatal("Database name is required. Set DB_NAME in .env file or database.database in workload.json, provide filter_pattern in workload.json, or name the database in every target (host/dbname).")
+	}
+	queries, err := workload.ResolveQueries()
+	if err != nil {
+		log.Fatalf("Failed to load queries: %v", err)
+	}
+	if len(queries) == 0 {
+		log.Fatal("SQL query is required in workload configuration (set query and/or queries_dir).")
+	}
+	workload.NoCache = *noCache
+	if workload.CacheTTL.Duration > 0 && workload.Watermark != nil {
+		log.Fatal("cache_ttl can't be combined with watermark: a cached result would replay rows already collected.")
 	}
-	if workload.Query == "" {
-		log.Fatal("SQL query is required in workload configuration.")
+	if workload.CountOnly && workload.Watermark != nil {
+		log.Fatal("count_only can't be combined with watermark: a count has no watermark column to track.")
 	}
-	if len(workload.Targets) == 0 {
-		log.Fatal("At least one target host is required in workload configuration.")
+	if workload.SampleRate < 0 || workload.SampleRate > 1 {
+		log.Fatalf("Invalid sample_rate %v in workload configuration (expected a fraction between 0 and 1).", workload.SampleRate)
+	}
+	if workload.SampleN < 0 {
+		log.Fatalf("Invalid sample_n %d in workload configuration.", workload.SampleN)
+	}
+	if workload.Samples() {
+		switch {
+		case workload.SampleRate > 0 && workload.SampleN > 0:
+			log.Fatal("sample_rate and sample_n can't be combined; choose one.")
+		case workload.CountOnly:
+			log.Fatal("count_only can't be combined with sampling: the count covers every row anyway.")
+		case workload.Watermark != nil:
+			log.Fatal("watermark can't be combined with sampling: rows left out of the sample would never be collected.")
+		case workload.SampleN > 0 && workload.PageSize > 0:
+			log.Fatal("sample_n can't be combined with page_size: each page would be sampled on its own.")
+		}
+		if workload.SampleSeed == nil {
+			seed := rand.Uint64()
+			workload.SampleSeed = &seed
+			logging.Infof("sample_seed not set; sampling with seed %d (set sample_seed to draw the same sample again)", seed)
+		}
+	}
+	if chunks := workload.Chunks; chunks != nil {
+		conditions, err := executor.ValidateChunks(chunks)
+		if err != nil {
+			log.Fatalf("Invalid chunks in workload configuration: %v", err)
+		}
+		switch {
+		case workload.PageSize > 0:
+			log.Fatal("chunks can't be combined with page_size; choose one way of splitting the query.")
+		case workload.CountOnly:
+			log.Fatal("chunks can't be combined with count_only: a count is a single row anyway.")
+		case workload.SampleN > 0:
+			log.Fatal("chunks can't be combined with sample_n: each chunk would be sampled on its own.")
+		case workload.StreamPerTarget:
+			log.Fatal("chunks can't be combined with stream_per_target: the chunks are scanned side by side.")
+		}
+		for _, query := range queries {
+			keyword := database.LeadingKeyword(query.SQL)
+			if !strings.Contains(query.SQL, models.ChunkPlaceholder) && keyword != "SELECT" && keyword != "WITH" {
+				log.Fatalf("Query %s can't be split into chunks: only SELECT queries can be wrapped, not %s (or use %s in the query).",
+					query.Name, keyword, models.ChunkPlaceholder)
+			}
+		}
+		logging.Debugf("Splitting each target's query into %d chunks: %s", len(conditions), strings.Join(conditions, ", "))
+	}
+	if len(workload.PostCommand) > 0 && strings.TrimSpace(workload.PostCommand[0]) == "" {
+		log.Fatal("post_command must start with the program to run.")
+	}
+	if len(workload.PostCommand) > 0 {
+		if _, err := exec.LookPath(workload.PostCommand[0]); err != nil {
+			log.Fatalf("Invalid post_command: %v", err)
+		}
+	}
+	if workload.PostCommandTimeout.Duration < 0 {
+		log.Fatalf("Invalid post_command_timeout %v in workload configuration.", workload.PostCommandTimeout.Duration)
+	}
+	if workload.StreamBufferRows < 0 {
+		log.Fatalf("Invalid stream_buffer_rows %d in workload configuration.", workload.StreamBufferRows)
+	}
+	if workload.StreamPerTarget {
+		switch {
+		case !workload.PerTargetOutput:
+			log.Fatal("stream_per_target requires per_target_output.")
+		case slices.ContainsFunc(workload.Destinations, func(d string) bool { return d != models.DestinationFile }):
+			log.Fatal("stream_per_target only writes files: there is no aggregated output for other destinations.")
+		case workload.PartitionBy != "", len(workload.DedupeKeys) > 0, workload.UnionColumns, workload.Manifest:
+			log.Fatal("stream_per_target can't be combined with partition_by, dedupe_keys, union_columns or manifest, which need the aggregated output.")
+		case workload.SampleN > 0, workload.PageSize > 0:
+			log.Fatal("stream_per_target can't be combined with sample_n or page_size, which hold or rerun a target's rows.")
+		case workload.CacheTTL.Duration > 0:
+			log.Fatal("stream_per_target can't be combined with cache_ttl: a streamed result is never held to be cached.")
+		case workload.CountOnly:
+			log.Fatal("stream_per_target can't be combined with count_only: a count has no rows to stream.")
+		}
+	}
+	if watermark := workload.Watermark; watermark != nil {
+		if watermark.Column == "" || watermark.StateFile == "" {
+			log.Fatal("watermark requires column and state_file in workload configuration.")
+		}
+		for _, query := range queries {
+			if strings.Contains(query.SQL, models.WatermarkPlaceholder) && watermark.Initial == "" {
+				log.Fatalf("Query %s uses %s, so watermark.initial is required for targets without a recorded value.",
+					query.Name, models.WatermarkPlaceholder)
+			}
+		}
+	}
+	if checkpoint := workload.Checkpoint; checkpoint != nil {
+		formats := workload.OutputFormat.List()
+		writesFile := len(workload.Destinations) == 0 || slices.Contains(workload.Destinations, models.DestinationFile)
+		switch {
+		case checkpoint.StateFile == "":
+			log.Fatal("checkpoint requires state_file in workload configuration.")
+		case checkpoint.MaxAge.Duration < 0:
+			log.Fatalf("Invalid checkpoint.max_age %v in workload configuration.", checkpoint.MaxAge.Duration)
+		case writesFile && !workload.StreamPerTarget && (len(formats) > 1 || formats[0] == models.OutputFormatJSON):
+			log.Fatal("checkpoint supports a single csv or tsv output_format: a resumed run appends its rows to the output file.")
+		case workload.PartitionBy != "", len(workload.DedupeKeys) > 0, workload.UnionColumns:
+			log.Fatal("checkpoint can't be combined with partition_by, dedupe_keys or union_columns, which need every target's rows in one run.")
+		case slices.Contains(workload.Destinations, models.DestinationSQLite) && workload.SQLite != nil && workload.SQLite.Mode == output.SQLiteModeReplace:
+			log.Fatal("checkpoint can't be combined with sqlite.mode \"replace\": a resumed run would drop the rows written before.")
+		}
+	} else if *resume {
+		log.Fatal("-resume requires checkpoint.state_file in workload configuration.")
+	}
+	if workload.PipelineOutput {
+		formats := workload.OutputFormat.List()
+		switch {
+		case len(workload.Destinations) > 0 && !slices.Equal(workload.Destinations, []string{models.DestinationFile}):
+			log.Fatal("pipeline_output only writes the \"file\" destination: the other destinations take the whole result at once.")
+		case len(formats) > 1 || formats[0] == models.OutputFormatJSON:
+			log.Fatal("pipeline_output supports a single csv or tsv output_format: rows are appended to one file as targets finish.")
+		case workload.PartitionBy != "", len(workload.DedupeKeys) > 0, workload.UnionColumns:
+			log.Fatal("pipeline_output can't be combined with partition_by, dedupe_keys or union_columns, which need every target's rows before writing; leave it off to buffer them.")
+		case workload.StreamPerTarget:
+			log.Fatal("pipeline_output can't be combined with stream_per_target, which writes no aggregated output.")
+		case workload.Checkpoint != nil:
+			log.Fatal("pipeline_output can't be combined with checkpoint: a resumed run appends to the output written before.")
+		}
+	}
+	workload.Resume = *resume
+	for name := range workload.StaticColumns {
+		if name == "" {
+			log.Fatal("static_columns has an empty column name in workload configuration.")
+		}
+		if name == workload.QueryNameColumn || name == workload.CollectedAtColumn {
+			log.Fatalf("static_columns column %q is also used by query_name_column or collected_at_column.", name)
+		}
+	}
+	ordered := make(map[string]bool, len(workload.ColumnsOrder))
+	for _, column := range workload.ColumnsOrder {
+		if ordered[column] {
+			log.Fatalf("columns_order lists column %q more than once.", column)
+		}
+		ordered[column] = true
+	}
+	if err := executor.ValidateColumnCasts(workload.ColumnCasts, workload.CastMode); err != nil {
+		log.Fatalf("Invalid column_casts: %v", err)
+	}
+	for _, column := range workload.DenyColumns {
+		if strings.TrimSpace(column) == "" {
+			log.Fatal("deny_columns has an empty column name in workload configuration.")
+		}
+	}
+	if denied := workload.DenyColumns; len(denied) > 0 {
+		added := []string{workload.QueryNameColumn, workload.CollectedAtColumn, workload.PartitionBy}
+		for name := range workload.StaticColumns {
+			added = append(added, name)
+		}
+		for _, column := range workload.ComputedColumns {
+			added = append(added, column.Name)
+		}
+		for _, name := range append(added, workload.DedupeKeys...) {
+			if name != "" && executor.IsDeniedColumn(name, denied) {
+				log.Fatalf("Column %q is in deny_columns but also configured in the workload (metadata, static, computed, partition_by or dedupe_keys column).", name)
+			}
+		}
+	}
+	if err := executor.ValidateComputedColumns(workload.ComputedColumns, workload.ComputedColumnErrors); err != nil {
+		log.Fatalf("Invalid computed_columns: %v", err)
+	}
+	for _, column := range workload.ComputedColumns {
+		if column.Name == workload.QueryNameColumn || column.Name == workload.CollectedAtColumn {
+			log.Fatalf("computed_columns column %q is also used by query_name_column or collected_at_column.", column.Name)
+		}
+		if _, ok := workload.StaticColumns[column.Name]; ok {
+			log.Fatalf("computed_columns column %q is also in static_columns.", column.Name)
+		}
+	}
+	for column, names := range workload.ColumnTransforms {
+		if _, err := transform.Chain(names); err != nil {
+			log.Fatalf("Invalid column_transforms for %q: %v", column, err)
+		}
+	}
+	if err := validateTargets(workload.Targets, dbType); err != nil {
+		log.Fatalf("Invalid targets in workload configuration: %v", err)
+	}
+	groupNames := make(map[string]bool, len(workload.TargetGroups))
+	for i, group := range workload.TargetGroups {
+		if group.Name == "" || groupNames[group.Name] {
+			log.Fatalf("target_groups[%d] needs a unique name in workload configuration.", i)
+		}
+		groupNames[group.Name] = true
+		if group.Workers <= 0 || len(group.Targets) == 0 {
+			log.Fatalf("Target group %s requires targets and a positive workers count in workload configuration.", group.Name)
+		}
+		if _, err := database.MatchesTarget("", group.Targets); err != nil {
+			log.Fatalf("Invalid targets for target group %s: %v", group.Name, err)
+		}
+	}
+	if discovery := workload.Discovery; discovery != nil {
+		if discovery.Host == "" || discovery.Query == "" {
+			log.Fatal("discovery requires host and query in workload configuration.")
+		}
+		if err := validateTargets([]string{discovery.Host}, dbType); err != nil {
+			log.Fatalf("Invalid discovery.host in workload configuration: %v", err)
+		}
+		if !workload.AllowWrites {
+			if err := database.CheckReadOnly(discovery.Query); err != nil {
+				log.Fatalf("Invalid discovery.query in workload configuration: %v", err)
+			}
+		}
+	}
+	if workload.ColumnTypes != "" && workload.ColumnTypes != models.ColumnTypesRow && workload.ColumnTypes != models.ColumnTypesSidecar {
+		log.Fatalf("Invalid column_types %q in workload configuration (supported: %s, %s).",
+			workload.ColumnTypes, models.ColumnTypesRow, models.ColumnTypesSidecar)
 	}
 
-	// Log start time
-	startTime := time.Now()
-	log.Printf("Starting data collection at %s for targets: %v", startTime.Format(time.RFC3339), workload.Targets)
+	if workload.RowCheck != "" && workload.RowCheck != models.RowCheckFail && workload.RowCheck != models.RowCheckWarn {
+		log.Fatalf("Invalid row_check %q in workload configuration (supported: %s, %s).",
+			workload.RowCheck, models.RowCheckFail, models.RowCheckWarn)
+	}
+	if workload.DedupeKeep != "" && workload.DedupeKeep != executor.DedupeKeepFirst && workload.DedupeKeep != executor.DedupeKeepLast {
+		log.Fatalf("Invalid dedupe_keep %q in workload configuration (supported: %s, %s).",
+			workload.DedupeKeep, executor.DedupeKeepFirst, executor.DedupeKeepLast)
+	}
+	if workload.DedupeKeep == executor.DedupeKeepLast && len(workload.DedupeKeys) > 0 && workload.SpillThreshold > 0 {
+		log.Fatal("dedupe_keep \"last\" keeps every row in memory and can't be combined with spill_threshold.")
+	}
+	if err := database.ValidateDSNParams(workload.DSNParams); err != nil {
+		log.Fatalf("Invalid workload configuration: %v", err)
+	}
+	if workload.ColumnFilter != "" {
+		if _, err := executor.CompileColumnFilter(workload.ColumnFilter); err != nil {
+			log.Fatalf("Invalid workload configuration: %v", err)
+		}
+	}
+	if err := database.ValidateBoolFormat(workload.BoolFormat); err != nil {
+		log.Fatalf("Invalid workload configuration: %v", err)
+	}
+	if err := database.ValidateBinaryFormat(workload.BinaryFormat); err != nil {
+		log.Fatalf("Invalid workload configuration: %v", err)
+	}
+	if err := database.ValidateArrayFormat(workload.ArrayFormat); err != nil {
+		log.Fatalf("Invalid workload configuration: %v", err)
+	}
+	if err := database.ValidateJSONFormat(workload.JSONFormat); err != nil {
+		log.Fatalf("Invalid workload configuration: %v", err)
+	}
+	if workload.CABundle != "" {
+		if _, err := database.LoadCABundle(workload.CABundle); err != nil {
+			log.Fatalf("Invalid workload configuration: %v", err)
+		}
+	}
+	if dbSSLCert != "" || dbSSLKey != "" {
+		if _, err := database.LoadClientCertificate(dbSSLCert, dbSSLKey); err != nil {
+			log.Fatalf("Invalid DB_SSL_CERT/DB_SSL_KEY in .env file: %v", err)
+		}
+	}
+	for i, statement := range workload.InitSQL {
+		// Session setup is SET statements; anything else needs allow_writes like the query
+		keyword := database.LeadingKeyword(statement)
+		if keyword == "" {
+			log.Fatalf("init_sql statement %d is empty.", i+1)
+		}
+		if keyword != "SET" && !workload.AllowWrites {
+			log.Fatalf("init_sql statement %d starts with %s; only SET statements are allowed unless allow_writes is true.", i+1, keyword)
+		}
+	}
+	if workload.QueryTimeout.Duration > 0 && targetsUse(workload.Targets, dbType, "postgres") && !setsStatementTimeout(workload.InitSQL) {
+		logging.Infof("query_timeout only cancels PostgreSQL queries client-side; add \"SET statement_timeout = ...\" to init_sql to stop them on the server too")
+	}
+
+	if err := workload.OutputFormat.Validate(); err != nil {
+		log.Fatalf("Invalid workload configuration: %v", err)
+	}
+	if err := csv.ValidateEncoding(workload.Encoding); err != nil {
+		log.Fatalf("Invalid workload configuration: %v", err)
+	}
+	if formats := workload.OutputFormat.List(); workload.PartitionBy != "" && (len(formats) > 1 || formats[0] == models.OutputFormatJSON) {
+		log.Fatal("partition_by supports a single csv or tsv output_format.")
+	}
+
+	// Validate the output sinks up front; each query builds its own below
+	if _, err := buildSinks(context.Background(), workload); err != nil {
+		log.Fatalf("Invalid output configuration: %v", err)
+	}
+
+	// Find out now, not after an hour of collecting, that the output can't be written
+	if writesOutputDir(workload) {
+		if err := output.CheckWritable(workload.OutputDir, workload.WriteOptions().DirPerm()); err != nil {
+			log.Fatalf("Output pre-flight check failed: %v", err)
+		}
+	}
 
 	// Create basic DB config (the host will be replaced by executor)
 	dbConfig := database.Config{
@@ -118,52 +900,251 @@ func main() {
 		Password: dbPass,
 		Database: dbName,
 		SSLMode:  dbSSLMode,
+		Socket:   dbSocket,
+
+		Charset:   dbCharset,
+		Collation: dbCollation,
+		Loc:       dbLoc,
+		ParseTime: dbParseTime,
+
+		SSLRootCert: dbSSLRootCert,
+		SSLCert:     dbSSLCert,
+		SSLKey:      dbSSLKey,
+		CABundle:    workload.CABundle,
+
+		ConnectTimeout: workload.ConnectTimeout.Duration,
+		DSNParams:      workload.DSNParams,
+		InitSQL:        workload.InitSQL,
 	}
 
-	// Execute queries in parallel using the executor package
-	result := executor.QueryTargets(
-		workload,
-		dbConfig,
-		dbType,
-		dbPort,
-		dbUser,
-		dbPass,
-		dbName,
-		dbSSLMode,
-	)
+	// Tunnel every connection through the bastion when one is configured
+	if tunnel := workload.SSHTunnel; tunnel != nil {
+		if tunnel.Host == "" || tunnel.User == "" || tunnel.KeyFile == "" {
+			log.Fatal("ssh_tunnel requires host, user and key_file in workload configuration.")
+		}
+		if dbSocket != "" {
+			log.Fatal("DB_SOCKET connects locally and can't be combined with ssh_tunnel.")
+		}
+		dbConfig.SSH = &database.SSHConfig{
+			Host:                  tunnel.Host,
+			Port:                  tunnel.Port,
+			User:                  tunnel.User,
+			KeyFile:               tunnel.KeyFile,
+			KeyPassphrase:         tunnel.KeyPassphrase,
+			KnownHostsFile:        tunnel.KnownHostsFile,
+			InsecureIgnoreHostKey: tunnel.InsecureIgnoreHostKey,
+		}
+		logging.Infof("Connecting to targets through SSH bastion %s@%s", tunnel.User, tunnel.Host)
+	}
 
-	// Check for complete failure
-	if !result.HasResults && result.ErrorCount == len(workload.Targets) {
-		log.Fatal("All target queries failed. No data to write.")
+	// Route database connections through an HTTP CONNECT proxy when one is configured
+	dbConfig.Proxy = workload.Proxy
+	if dbConfig.Proxy == "" {
+		dbConfig.Proxy = firstNonEmpty(os.Getenv("HTTPS_PROXY"), os.Getenv("https_proxy"))
 	}
-	if !result.HasResults && result.ErrorCount < len(workload.Targets) {
-		log.Printf("Warning: No data rows retrieved from any successful target.")
-		// Proceed to write empty file with headers if columns were found, or just log completion
+	if dbConfig.Proxy != "" {
+		if dbConfig.SSH != nil {
+			logging.Warnf("Warning: proxy %s is ignored for database connections because ssh_tunnel is set", redactURL(dbConfig.Proxy))
+			dbConfig.Proxy = ""
+		} else {
+			logging.Infof("Connecting to targets through HTTP CONNECT proxy %s (hosts in NO_PROXY connect directly)", redactURL(dbConfig.Proxy))
+		}
 	}
 
-	// Configure CSV output
-	csvOptions := models.WriteOptions{
-		Directory:  workload.OutputDir,
-		Filename:   workload.OutputFile,
-		AppendDate: true,
+	// Print where every setting came from and stop, before any connection is made
+	if *explainConfigFlag {
+		explanation := &configExplanation{workloadFile: *workloadFile, loaded: workloadLoaded, flags: make(map[string]string)}
+		flag.Visit(func(f *flag.Flag) {
+			explanation.flags[f.Name] = f.Value.String()
+		})
+		if err := explainConfig(os.Stdout, explanation, workload, dbConfig, dbHost, env); err != nil {
+			log.Fatalf("Failed to explain configuration: %v", err)
+		}
+		return
 	}
 
-	// Write aggregated results to CSV
-	if len(result.Rows) > 0 || result.HasResults { // Write even if only headers are available
-		log.Printf("Aggregated %d rows from %d targets (out of %d). Writing to CSV...",
-			len(result.Rows), len(workload.Targets)-result.ErrorCount, len(workload.Targets))
-		outputPath, err := csv.WriteToCSV(result.Rows, result.Columns, csvOptions)
+	// Print the fully resolved configuration and stop, before any connection is made
+	if *printConfig {
+		if err := printEffectiveConfig(workload, dbConfig); err != nil {
+			log.Fatalf("Failed to print configuration: %v", err)
+		}
+		return
+	}
+
+	// Send metrics to StatsD when configured; an unreachable server never stops the run
+	if statsd := workload.StatsD; statsd != nil {
+		if statsd.Host == "" {
+			log.Fatal("statsd requires host in workload configuration.")
+		}
+		port, prefix := statsd.Port, statsd.Prefix
+		if port == 0 {
+			port = metrics.DefaultPort
+		}
+		if prefix == "" {
+			prefix = metrics.DefaultPrefix
+		}
+		if err := metrics.Start(statsd.Host, port, prefix); err != nil {
+			logging.Warnf("Warning: metrics disabled: %v", err)
+		}
+		defer metrics.Stop()
+	}
+
+	// Bound the whole run, discovery included, by max_runtime when configured
+	ctx := context.Background()
+	if workload.MaxRuntime.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, workload.MaxRuntime.Duration)
+		defer cancel()
+		logging.Infof("Run deadline set to %v (max_runtime)", workload.MaxRuntime.Duration)
+	}
+
+	// Stop gracefully on SIGINT/SIGTERM, still writing partial output and the summary
+	ctx, stopSignals := handleShutdownSignals(ctx, workload.ShutdownTimeout.Duration)
+	defer stopSignals()
+
+	// Add the targets listed by the discovery query, once for all queries
+	if workload.Discovery != nil {
+		counts.discovery = true
+		discovered, err := executor.DiscoverTargets(ctx, workload.Discovery, workload.QueryTimeout, dbConfig)
+		if err != nil && ctx.Err() != nil {
+			log.Fatalf("Target discovery stopped: %v (targets resolved: %s)", context.Cause(ctx), counts)
+		}
 		if err != nil {
-			log.Fatalf("Failed to write aggregated data to CSV: %v", err)
+			log.Fatalf("Target discovery failed: %v (targets resolved: %s)", err, counts)
+		}
+		counts.discovered = len(discovered)
+		known := make(map[string]bool, len(workload.Targets))
+		for _, target := range workload.Targets {
+			known[target] = true
+		}
+		for _, target := range discovered {
+			if !known[target] {
+				workload.Targets = append(workload.Targets, target)
+				counts.added++
+			}
+		}
+		if err := validateTargets(workload.Targets, dbType); err != nil {
+			log.Fatalf("Invalid discovered target: %v", err)
+		}
+		selectTargets(workload, *onlyTargets, *skipTargets, counts)
+	}
+
+	// Log start time
+	startTime := time.Now()
+	workload.CollectedAt = startTime
+	logging.Infof("Starting data collection at %s for targets: %v", startTime.Format(time.RFC3339), workload.Targets)
+
+	// Load the incremental collection state
+	var watermarks *state.Watermarks
+	if workload.Watermark != nil {
+		watermarks, err = state.LoadWatermarks(workload.Watermark.StateFile)
+		if err != nil {
+			log.Fatalf("Failed to load watermark state: %v", err)
+		}
+	}
+
+	// Load the targets written by an earlier, interrupted run
+	var checkpoints *state.Checkpoints
+	if workload.Checkpoint != nil {
+		checkpoints, err = state.LoadCheckpoints(workload.Checkpoint.StateFile)
+		if err != nil {
+			log.Fatalf("Failed to load checkpoint state: %v", err)
+		}
+	}
+
+	// Run each query across all targets, continuing past failed queries
+	failedQueries := 0
+	summary := &runSummary{StartedAt: startTime, Queries: []querySummary{}}
+	for _, query := range queries {
+		summary.Queries = append(summary.Queries, querySummary{
+			Name:         query.Name,
+			Targets:      []targetStatus{},
+			EmptyTargets: []string{},
+			Failures:     []targetFailure{},
+			Warnings:     []targetFailure{},
+			Files:        []string{},
+		})
+		querySum := &summary.Queries[len(summary.Queries)-1]
+		if ctx.Err() != nil {
+			reason := "run deadline exceeded"
+			if interrupted := interruption(ctx); interrupted != "" {
+				reason = "run " + interrupted
+			}
+			logging.Warnf("Skipping query %s: %s", query.Name, reason)
+			querySum.Error = "skipped: " + reason
+			failedQueries++
+			continue
+		}
+
+		logging.Infof("Running query %s", query.Name)
+		queryWorkload := workload.ForQuery(query)
+		if watermarks != nil {
+			queryWorkload.WatermarkValues = watermarks.ForQuery(query.Name)
+		}
+		if err := runQuery(ctx, queryWorkload, dbConfig, watermarks, checkpoints, querySum); err != nil {
+			logging.Errorf("Query %s failed: %v", query.Name, err)
+			querySum.Error = err.Error()
+			failedQueries++
+			if errors.Is(err, errRunAborted) {
+				break
+			}
+		}
+	}
+
+	// Hand the output to post_command, but only when every query wrote all of
+	// its output: a query cut short by max_runtime wrote partial results
+	truncated := summary.truncated()
+	var postErr error
+	if len(workload.PostCommand) > 0 {
+		if failedQueries == 0 && interruption(ctx) == "" && !truncated {
+			postErr = runPostCommand(workload.PostCommand, workload.PostCommandTimeout.Duration, summary)
+			if postErr != nil {
+				logging.Errorf("post_command failed: %v", postErr)
+			}
+		} else {
+			logging.Warnf("Skipping post_command: the run did not complete successfully")
 		}
-		// Log success
-		absPath, _ := filepath.Abs(outputPath)
-		log.Printf("Aggregated data successfully written to CSV file: %s", absPath)
-	} else {
-		log.Printf("No data rows to write to CSV.")
 	}
 
 	// Calculate elapsed time
 	elapsedTime := time.Since(startTime)
-	log.Printf("Process completed in %v", elapsedTime)
+	logging.Infof("Process completed in %v", elapsedTime)
+	metrics.Timing("run.duration", elapsedTime)
+	if failedQueries > 0 || interruption(ctx) != "" || truncated || postErr != nil {
+		metrics.Count("run.failure", 1)
+	} else {
+		metrics.Count("run.success", 1)
+	}
+	summary.Interrupted = interruption(ctx)
+	if *benchmark {
+		summary.measureThroughput()
+	}
+	summary.finish(elapsedTime, failedQueries)
+	summary.log()
+	if *listEmpty {
+		summary.printEmpty(os.Stdout)
+	}
+
+	// The summary is written for partial failures too, before exiting non-zero
+	if workload.SummaryFile != "" {
+		options := workload.WriteOptions()
+		if err := summary.write(workload.SummaryFile, options.FilePerm(), options.DirPerm()); err != nil {
+			logging.Warnf("Warning: failed to write run summary: %v", err)
+		} else {
+			logging.Infof("Run summary written to %s", workload.SummaryFile)
+		}
+	}
+
+	if summary.Interrupted != "" {
+		log.Fatalf("Run %s; the results collected before it were written.", summary.Interrupted)
+	}
+	if summary.Truncated {
+		log.Fatalf("Run truncated by max_runtime after %v; the results collected before it were written.", workload.MaxRuntime.Duration)
+	}
+	if failedQueries > 0 {
+		log.Fatalf("%d of %d queries failed.", failedQueries, len(queries))
+	}
+	if postErr != nil {
+		log.Fatalf("post_command failed: %v", postErr)
+	}
 }
@@ -0,0 +1,100 @@
+// Package metrics emits the collector's counters and timers to StatsD. Until
+// Start is called every function is a no-op.
+package metrics
+
+import (
+	"datacollector/logging"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPort is the standard StatsD port
+const DefaultPort = 8125
+
+// DefaultPrefix is prepended to every metric name unless another is configured
+const DefaultPrefix = "datacollector"
+
+// dialTimeout bounds resolving the StatsD host at startup
+const dialTimeout = 2 * time.Second
+
+// writeTimeout bounds each send, so a stalled socket never holds up a worker
+const writeTimeout = 50 * time.Millisecond
+
+// client sends metrics over UDP, one datagram per metric. UDP needs no
+// connection, so a StatsD server that is down only loses the metrics.
+type client struct {
+	conn   net.Conn
+	prefix string
+	failed atomic.Bool // Whether a send failure was logged already
+}
+
+// current is the active client; nil while metrics are off
+var current atomic.Pointer[client]
+
+// Start sends metrics to the StatsD server at host:port, naming them
+// "<prefix>.<name>". It fails only when the address can't be resolved.
+func Start(host string, port int, prefix string) error {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("udp", address, dialTimeout)
+	if err != nil {
+		return fmt.Errorf("statsd %s: %w", address, err)
+	}
+	current.Store(&client{conn: conn, prefix: strings.TrimSuffix(prefix, ".")})
+	logging.Infof("Sending metrics to StatsD at %s with prefix %q", address, prefix)
+	return nil
+}
+
+// Stop turns metrics off and releases the socket
+func Stop() {
+	if c := current.Swap(nil); c != nil {
+		c.conn.Close()
+	}
+}
+
+// Count adds value to the counter name
+func Count(name string, value int64) {
+	send(name, strconv.FormatInt(value, 10), "c")
+}
+
+// Timing records one duration sample of the timer name, in milliseconds
+func Timing(name string, d time.Duration) {
+	send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64), "ms")
+}
+
+// Name joins metric name segments with dots, replacing the characters StatsD
+// gives a meaning to (and dots within a segment, e.g. in host names) with
+// underscores, so a host becomes a single segment
+func Name(segments ...string) string {
+	cleaned := make([]string, len(segments))
+	for i, segment := range segments {
+		cleaned[i] = strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+				return r
+			default:
+				return '_'
+			}
+		}, segment)
+	}
+	return strings.Join(cleaned, ".")
+}
+
+// send writes one metric in the StatsD line format, dropping it on failure
+func send(name string, value string, kind string) {
+	c := current.Load()
+	if c == nil {
+		return
+	}
+	line := name + ":" + value + "|" + kind
+	if c.prefix != "" {
+		line = c.prefix + "." + line
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write([]byte(line)); err != nil && !c.failed.Swap(true) {
+		logging.Warnf("Warning: failed to send metrics to StatsD; dropping them: %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ChunkPlaceholder is replaced in the query by the condition selecting one chunk
+const ChunkPlaceholder = "{{chunk}}"
+
+// Chunks splits each target's query into Count ranges of Column, queried
+// concurrently on the target and concatenated. Min and Max only place the
+// boundaries: the first chunk also takes the values below them (and NULL)
+// and the last the values above, so every row is in exactly one chunk.
+type Chunks struct {
+	Column string     `json:"column"` // Numeric or date column the ranges are taken over
+	Min    ChunkBound `json:"min"`    // Lowest expected value, a number or a date
+	Max    ChunkBound `json:"max"`    // Highest expected value, of the same kind as Min
+	Count  int        `json:"count"`  // Number of ranges (at least 2)
+}
+
+// ChunkBound is a chunk range bound, written in workload.json as a number or
+// a string and kept as its text
+type ChunkBound string
+
+// UnmarshalJSON accepts a number (kept exactly as written) or a string
+func (b *ChunkBound) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		*b = ""
+	case json.Number:
+		*b = ChunkBound(v.String())
+	case string:
+		*b = ChunkBound(v)
+	default:
+		return fmt.Errorf("invalid chunk bound %s: must be a number or a string", string(data))
+	}
+	return nil
+}
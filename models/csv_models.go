@@ -1,8 +1,117 @@
 package models
 
+import "os"
+
+// Default permissions for output files and directories
+const (
+	DefaultFileMode os.FileMode = 0644
+	DefaultDirMode  os.FileMode = 0755
+)
+
+// Encodings of delimited output files besides the code pages the csv package
+// supports (WriteOptions.Encoding)
+const (
+	EncodingUTF8    = "utf-8"     // Plain UTF-8 (default)
+	EncodingUTF8BOM = "utf-8-bom" // UTF-8 preceded by a byte order mark, which Excel needs to detect it
+)
+
 // WriteOptions contains configuration for CSV writing
 type WriteOptions struct {
 	Directory  string
 	Filename   string
 	AppendDate bool
+
+	// ColumnTypes holds the SQL type of each column, used when ColumnTypesMode is set
+	ColumnTypes []string
+	// ColumnTypesMode is "" (off), "row" (second header row) or "sidecar" (<file>.types)
+	ColumnTypesMode string
+
+	// QuoteAll encloses every field in double quotes, not only those that need it
+	QuoteAll bool
+	// Format is OutputFormatCSV (default), OutputFormatTSV or, for JSON
+	// sinks, OutputFormatJSON
+	Format string
+	// SanitizeFormulas prefixes fields starting with =, +, - or @ with a single
+	// quote so spreadsheets don't evaluate them
+	SanitizeFormulas bool
+	// FlushRows is how many rows are buffered before they are flushed to the
+	// file (0 = DefaultFlushRows, negative = only at the end)
+	FlushRows int
+	// Encoding is the character encoding of the delimited file: "" or
+	// EncodingUTF8, EncodingUTF8BOM or a code page such as "windows-1252"
+	Encoding string
+	// NullValue is the text NULL values were rendered as, which the JSON
+	// format writes as null
+	NullValue string
+
+	FileMode os.FileMode // Permissions for created files (0 = DefaultFileMode)
+	DirMode  os.FileMode // Permissions for created directories (0 = DefaultDirMode)
+}
+
+// Reencodes reports whether Encoding changes the bytes of UTF-8 output
+func (o WriteOptions) Reencodes() bool {
+	return o.Encoding != "" && o.Encoding != EncodingUTF8
+}
+
+// FilePerm returns the permissions to create output files with
+func (o WriteOptions) FilePerm() os.FileMode {
+	if o.FileMode == 0 {
+		return DefaultFileMode
+	}
+	return o.FileMode
+}
+
+// DefaultFlushRows is the number of rows between flushes when FlushRows is 0
+const DefaultFlushRows = 10000
+
+// FlushEvery returns the number of rows between flushes, or 0 for no
+// periodic flushing
+func (o WriteOptions) FlushEvery() int {
+	switch {
+	case o.FlushRows == 0:
+		return DefaultFlushRows
+	case o.FlushRows < 0:
+		return 0
+	}
+	return o.FlushRows
+}
+
+// DirPerm returns the permissions to create output directories with
+func (o WriteOptions) DirPerm() os.FileMode {
+	if o.DirMode == 0 {
+		return DefaultDirMode
+	}
+	return o.DirMode
+}
+
+// Column type output modes for WriteOptions.ColumnTypesMode
+const (
+	ColumnTypesRow     = "row"
+	ColumnTypesSidecar = "sidecar"
+)
+
+// Output file formats for WriteOptions.Format
+const (
+	OutputFormatCSV  = "csv"
+	OutputFormatTSV  = "tsv"
+	OutputFormatJSON = "json" // An array of row objects; see output.JSONSink
+)
+
+// Comma returns the field delimiter for the output format
+func (o WriteOptions) Comma() rune {
+	if o.Format == OutputFormatTSV {
+		return '\t'
+	}
+	return ','
+}
+
+// Extension returns the file extension for the output format, including the dot
+func (o WriteOptions) Extension() string {
+	switch o.Format {
+	case OutputFormatTSV:
+		return ".tsv"
+	case OutputFormatJSON:
+		return ".json"
+	}
+	return ".csv"
 }
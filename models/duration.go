@@ -0,0 +1,75 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Duration wraps time.Duration so it can be written in workload.json either as
+// a Go duration string ("30s", "2m") or as a plain number of seconds
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration from a string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		d.Duration = 0
+	case float64:
+		d.Duration = time.Duration(v * float64(time.Second))
+	case string:
+		if v == "" {
+			d.Duration = 0
+			return nil
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", v, err)
+		}
+		d.Duration = parsed
+	default:
+		return fmt.Errorf("invalid duration value: %s", string(data))
+	}
+
+	return nil
+}
+
+// MarshalJSON writes the duration in its string form (e.g. "1m30s")
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+// FileMode is an os.FileMode written in workload.json as an octal string (e.g. "0600")
+type FileMode os.FileMode
+
+// UnmarshalJSON parses an octal permission string
+func (m *FileMode) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("file mode must be an octal string such as \"0600\": %s", string(data))
+	}
+	if value == "" {
+		*m = 0
+		return nil
+	}
+	parsed, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || parsed > 0777 {
+		return fmt.Errorf("invalid file mode %q (expected octal such as \"0640\")", value)
+	}
+	*m = FileMode(parsed)
+	return nil
+}
+
+// MarshalJSON writes the mode as an octal string
+func (m FileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%04o", uint32(m)))
+}
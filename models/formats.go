@@ -0,0 +1,87 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OutputFormats is the output_format setting, written in workload.json as a
+// single format name ("tsv") or a list (["csv", "json"]) of files to produce
+// from the same result
+type OutputFormats []string
+
+// UnmarshalJSON accepts a format name or a list of them
+func (f *OutputFormats) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		*f = nil
+	case string:
+		if v == "" {
+			*f = nil
+		} else {
+			*f = OutputFormats{v}
+		}
+	case []interface{}:
+		formats := make(OutputFormats, 0, len(v))
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("output_format entries must be strings: %s", string(data))
+			}
+			formats = append(formats, name)
+		}
+		*f = formats
+	default:
+		return fmt.Errorf("output_format must be a string or a list of strings: %s", string(data))
+	}
+	return nil
+}
+
+// MarshalJSON writes a single format as a plain string and several as a list
+func (f OutputFormats) MarshalJSON() ([]byte, error) {
+	if len(f) == 1 {
+		return json.Marshal(f[0])
+	}
+	return json.Marshal([]string(f))
+}
+
+// List returns the configured formats, defaulting to CSV
+func (f OutputFormats) List() []string {
+	if len(f) == 0 {
+		return []string{OutputFormatCSV}
+	}
+	return f
+}
+
+// Delimited returns the first CSV-like format, used for outputs that are
+// always delimited text (per-target files, stdout); it defaults to CSV
+func (f OutputFormats) Delimited() string {
+	for _, format := range f {
+		if format == OutputFormatCSV || format == OutputFormatTSV {
+			return format
+		}
+	}
+	return OutputFormatCSV
+}
+
+// Validate checks that every format is known and listed only once
+func (f OutputFormats) Validate() error {
+	seen := make(map[string]bool, len(f))
+	for _, format := range f {
+		switch format {
+		case OutputFormatCSV, OutputFormatTSV, OutputFormatJSON:
+		default:
+			return fmt.Errorf("invalid output_format %q (supported: %s, %s, %s)", format, OutputFormatCSV, OutputFormatTSV, OutputFormatJSON)
+		}
+		if seen[format] {
+			return fmt.Errorf("output_format %q is listed twice", format)
+		}
+		seen[format] = true
+	}
+	return nil
+}
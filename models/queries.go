@@ -0,0 +1,82 @@
+package models
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NamedQuery is a single query to collect, with the label used for its output
+type NamedQuery struct {
+	Name       string // Label used in logs
+	SQL        string
+	OutputFile string // Base output filename for this query's results
+}
+
+// LoadQueriesDir loads every *.sql file in dir, in sorted filename order.
+// Each file's name without extension is used as the query label and output
+// filename. A missing directory or one without .sql files is an error.
+func LoadQueriesDir(dir string) ([]NamedQuery, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("queries_dir %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("queries_dir %s is not a directory", dir)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, fmt.Errorf("queries_dir %s: %w", dir, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("queries_dir %s contains no .sql files", dir)
+	}
+	sort.Strings(paths)
+
+	queries := make([]NamedQuery, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading query file %s: %w", path, err)
+		}
+		sql := strings.TrimSpace(string(data))
+		if sql == "" {
+			return nil, fmt.Errorf("query file %s is empty", path)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		queries = append(queries, NamedQuery{Name: name, SQL: sql, OutputFile: name})
+	}
+
+	return queries, nil
+}
+
+// ResolveQueries returns the inline query (if any) followed by the queries
+// loaded from QueriesDir (if set)
+func (w *Workload) ResolveQueries() ([]NamedQuery, error) {
+	var queries []NamedQuery
+	if w.Query != "" {
+		queries = append(queries, NamedQuery{Name: w.OutputFile, SQL: w.Query, OutputFile: w.OutputFile})
+	}
+
+	if w.QueriesDir != "" {
+		loaded, err := LoadQueriesDir(w.QueriesDir)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, loaded...)
+	}
+
+	return queries, nil
+}
+
+// ForQuery returns a copy of the workload that runs only the given query
+func (w *Workload) ForQuery(query NamedQuery) *Workload {
+	copied := *w
+	copied.Query = query.SQL
+	copied.QueryName = query.Name
+	copied.OutputFile = query.OutputFile
+	return &copied
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// WorkersAuto is the workload.json value asking for an automatic worker count
+const WorkersAuto = "auto"
+
+// Workers is the worker count from workload.json: a number, or "auto" (or 0)
+// to size it from the machine. Auto is stored as 0 and resolved by Resolve.
+type Workers int
+
+// UnmarshalJSON accepts a number or the string "auto"
+func (w *Workers) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		*w = 0
+	case float64:
+		if v != float64(int(v)) {
+			return fmt.Errorf("invalid workers value %v: must be a whole number", v)
+		}
+		*w = Workers(v)
+	case string:
+		if !strings.EqualFold(v, WorkersAuto) {
+			return fmt.Errorf("invalid workers value %q: must be a number or %q", v, WorkersAuto)
+		}
+		*w = 0
+	default:
+		return fmt.Errorf("invalid workers value: %s", string(data))
+	}
+	return nil
+}
+
+// IsAuto reports whether the worker count should be chosen automatically
+func (w Workers) IsAuto() bool {
+	return w == 0
+}
+
+// Resolve returns the effective worker count for a run over the given number
+// of targets: explicit positive values are used as is, auto means one worker
+// per CPU but never more than there are targets (and at least one)
+func (w Workers) Resolve(targets int) int {
+	if w > 0 {
+		return int(w)
+	}
+	workers := runtime.NumCPU()
+	if targets > 0 && workers > targets {
+		workers = targets
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
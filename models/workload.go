@@ -3,17 +3,343 @@ package models
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"datacollector/database"
 )
 
 // Workload represents the configuration loaded from workload.json
 type Workload struct {
-	Workers       int      `json:"workers"`
-	Targets       []string `json:"targets"`
-	Output        string   `json:"output"`
-	FilterPattern string   `json:"filter_pattern"`
-	Query         string   `json:"query"`   // SQL query to execute
-	OutputDir     string   `json:"outdir"`  // Optional output directory
-	OutputFile    string   `json:"outfile"` // Optional output file name
+	Workers       Workers       `json:"workers"` // Concurrent targets; 0 or "auto" sizes it from the CPU count
+	Targets       []string      `json:"targets"`
+	Discovery     *Discovery    `json:"discovery"`     // Optional query listing more targets, run once at startup
+	TargetGroups  []TargetGroup `json:"target_groups"` // Targets with their own worker limits instead of workers
+	Output        string        `json:"output"`
+	FilterPattern string        `json:"filter_pattern"`
+	Query         string        `json:"query"`   // SQL query to execute
+	OutputDir     string        `json:"outdir"`  // Optional output directory
+	OutputFile    string        `json:"outfile"` // Optional output file name
+
+	QueriesDir string `json:"queries_dir"` // Optional directory of *.sql files to run as well
+	QueryName  string `json:"-"`           // Label of the query being run (set per query)
+
+	QueryNameColumn   string            `json:"query_name_column"`   // Optional column filled with the query label
+	CollectedAtColumn string            `json:"collected_at_column"` // Optional column filled with the run start time
+	StaticColumns     map[string]string `json:"static_columns"`      // Constant columns appended to every row
+	CollectedAt       time.Time         `json:"-"`                   // Run start time (set by main)
+
+	Watermark       *Watermark        `json:"watermark"` // Optional incremental collection settings
+	WatermarkValues map[string]string `json:"-"`         // Last watermark per target for the current query
+
+	Checkpoint *Checkpoint `json:"checkpoint"` // Optional record of the targets written, for resuming an interrupted run
+	Resume     bool        `json:"-"`          // Skip the targets the checkpoint lists as written (-resume)
+
+	ConnectTimeout  Duration `json:"connect_timeout"`  // Optional limit for establishing each connection
+	QueryTimeout    Duration `json:"query_timeout"`    // Optional limit for each query's execution
+	MaxRuntime      Duration `json:"max_runtime"`      // Optional deadline for the whole run
+	ShutdownTimeout Duration `json:"shutdown_timeout"` // Time allowed to finish after SIGINT/SIGTERM before exiting (default 30s)
+	StartJitter     Duration `json:"start_jitter"`     // Workers wait a random delay below this before their first connect
+
+	DSNParams map[string]string `json:"dsn_params"` // Extra driver parameters appended to every DSN
+	InitSQL   []string          `json:"init_sql"`   // Statements run on every new connection before the query
+
+	PageSize int     `json:"page_size"` // Fetch simple SELECTs in LIMIT/OFFSET pages of this size (0 = one shot)
+	Chunks   *Chunks `json:"chunks"`    // Optional split of each target's query into ranges queried in parallel
+
+	SampleRate float64 `json:"sample_rate"` // Keep each row with this probability (0 < rate <= 1; 0 = every row)
+	SampleN    int     `json:"sample_n"`    // Keep a uniform random sample of this many rows per target (0 = every row)
+	SampleSeed *uint64 `json:"sample_seed"` // Seed making samples reproducible; nil picks one at random (set by main)
+	CountOnly  bool    `json:"count_only"`  // Only count each target's rows, writing host,row_count
+
+	Retries int `json:"retries"` // Reruns of a query on a fresh connection after the connection dropped
+
+	CacheTTL Duration `json:"cache_ttl"` // Reuse each target's result for this long instead of querying (0 = off)
+	CacheDir string   `json:"cache_dir"` // Directory of cached results (default ".cache")
+	NoCache  bool     `json:"-"`         // Ignore cached results for this run (-no-cache)
+
+	FileMode FileMode `json:"file_mode"` // Output file permissions as octal, e.g. "0600" (default "0644")
+	DirMode  FileMode `json:"dir_mode"`  // Output directory permissions as octal (default "0755")
+
+	PerTargetOutput  bool  `json:"per_target_output"`  // Also write each target's result to its own file
+	StreamPerTarget  bool  `json:"stream_per_target"`  // Write per-target files while each target is scanned, with no aggregated output
+	StreamBufferRows int   `json:"stream_buffer_rows"` // Most rows a streamed target buffers between writes (0 = default 10000)
+	SpillThreshold   int   `json:"spill_threshold"`    // Spill aggregated rows to disk above this count (0 = never)
+	PipelineOutput   bool  `json:"pipeline_output"`    // Write rows to the output file as targets finish instead of after the last one
+	UnionColumns     bool  `json:"union_columns"`      // Header from all targets' columns, rows aligned by name
+	FlushRows        int   `json:"flush_rows"`         // Rows written between flushes of CSV output (0 = default, negative = at the end)
+	WriteEmpty       *bool `json:"write_empty"`        // Write header-only output when there are no rows; nil keeps the default true
+
+	DedupeKeys []string `json:"dedupe_keys"` // Columns forming the key rows are deduplicated on
+	DedupeKeep string   `json:"dedupe_keep"` // Which duplicate survives: "first" (default) or "last"
+
+	NullValue *string `json:"null_value"` // Text written for NULL values; nil keeps the default "NULL"
+
+	ColumnTypes      string        `json:"column_types"`      // Optional column type output: "row" or "sidecar"
+	QuoteAll         bool          `json:"quote_all"`         // Quote every CSV field, not only those that need it
+	SanitizeFormulas bool          `json:"sanitize_formulas"` // Prefix fields starting with =, +, - or @ with a single quote
+	OutputFormat     OutputFormats `json:"output_format"`     // "csv" (default), "tsv", "json", or a list of them
+	Encoding         string        `json:"encoding"`          // Character encoding of csv/tsv files: "utf-8" (default), "utf-8-bom" or a code page
+	BoolFormat       string        `json:"bool_format"`       // Booleans and BIT(1) values as "numeric" (0/1, default) or "text" (true/false)
+	BinaryFormat     string        `json:"binary_format"`     // Binary columns as "hex" (default), "base64", "placeholder" or "raw"
+	ArrayFormat      string        `json:"array_format"`      // PostgreSQL arrays as "text" (default), "comma" or "json"
+	JSONFormat       string        `json:"json_format"`       // JSON columns as "text" (default), "compact" or "pretty"
+	PartitionBy      string        `json:"partition_by"`      // Write one file per distinct value of this column
+	Manifest         bool          `json:"manifest"`          // Write a checksum manifest next to the output files
+
+	SummaryFile string  `json:"summary_file"` // Optional path of a JSON summary of the run
+	StatsD      *StatsD `json:"statsd"`       // Optional StatsD server receiving run and per-target metrics
+
+	PostCommand        []string `json:"post_command"`         // Program and arguments run after a successful run, given the output files
+	PostCommandTimeout Duration `json:"post_command_timeout"` // Time post_command may run before it is killed (default 5m)
+
+	ColumnFilter string `json:"column_filter"` // Glob list or "re:" regular expression selecting the columns written
+
+	ColumnsOrder         []string `json:"columns_order"`          // Query columns placed first, in this order
+	DropUnorderedColumns bool     `json:"drop_unordered_columns"` // Drop the columns not in columns_order instead of keeping them after it
+	StrictColumnsOrder   bool     `json:"strict_columns_order"`   // Fail a target when a column in columns_order is missing
+
+	DenyColumns       []string `json:"deny_columns"`        // Columns never written, matched by query or alias name ignoring case
+	StrictDenyColumns bool     `json:"strict_deny_columns"` // Fail a target returning a denied column instead of dropping it
+
+	DisambiguateColumns bool `json:"disambiguate_columns"` // Rename repeated column names to name_2, name_3, ...
+
+	ColumnAliases map[string]string `json:"column_aliases"` // Output header names keyed by query column name
+	StrictAliases bool              `json:"strict_aliases"` // Fail a target when an aliased column is missing
+
+	ColumnTransforms map[string][]string `json:"column_transforms"` // Named transforms applied per column, in order
+	ColumnCasts      map[string]string   `json:"column_casts"`      // Type ("int", "float", "bool", "date") each column is converted to
+	CastMode         string              `json:"cast_mode"`         // "strict" (default) fails a target on a bad value, "lenient" writes NULL
+
+	ComputedColumns      []ComputedColumn `json:"computed_columns"`       // Columns appended from an expression over each row
+	ComputedColumnErrors string           `json:"computed_column_errors"` // "fail" (default) fails a target on an expression error, "skip" drops the row
+
+	NullDefaults map[string]string `json:"null_defaults"` // Value written instead of NULL, per column
+
+	MinRows    int    `json:"min_rows"`    // Flag targets returning fewer rows (0 = no check)
+	ExpectRows *int   `json:"expect_rows"` // Flag targets not returning exactly this many rows
+	RowCheck   string `json:"row_check"`   // "fail" (default) or "warn" when a row expectation isn't met
+
+	FailFast             bool `json:"fail_fast"`              // Abort the whole run on the first target error
+	AllowWrites          bool `json:"allow_writes"`           // Disable the read-only query check
+	AllowMultiStatements bool `json:"allow_multi_statements"` // Permit queries holding several statements
+	CaptureExplain       bool `json:"capture_explain"`        // Save each target's EXPLAIN plan to a sidecar file
+
+	Database  *Database  `json:"database"`   // Connection settings taking precedence over the DB_* variables
+	SSHTunnel *SSHTunnel `json:"ssh_tunnel"` // Optional bastion every target is reached through
+	Proxy     string     `json:"proxy"`      // HTTP CONNECT proxy URL for database connections (default HTTPS_PROXY)
+	CABundle  string     `json:"ca_bundle"`  // PEM file of CA certificates trusted by every driver's TLS
+
+	Destinations []string      `json:"destinations"` // Output sinks to write to; defaults to ["file"]
+	HTTPOutput   *HTTPOutput   `json:"http_output"`  // Settings for the "http" destination
+	GCS          *GCSOutput    `json:"gcs"`          // Settings for the "gcs" destination
+	SQLite       *SQLiteOutput `json:"sqlite"`       // Settings for the "sqlite" destination
+	Kafka        *KafkaOutput  `json:"kafka"`        // Settings for the "kafka" destination
+}
+
+// Supported values for Workload.Destinations
+const (
+	DestinationFile   = "file"
+	DestinationStdout = "stdout"
+	DestinationHTTP   = "http"
+	DestinationGCS    = "gcs"
+	DestinationSQLite = "sqlite"
+	DestinationKafka  = "kafka"
+)
+
+// StatsD configures sending metrics to a StatsD server over UDP
+type StatsD struct {
+	Host   string `json:"host"`
+	Port   int    `json:"port"`   // Defaults to 8125
+	Prefix string `json:"prefix"` // Prepended to every metric name (default "datacollector")
+}
+
+// SQLiteOutput configures inserting results into a local SQLite database
+type SQLiteOutput struct {
+	Path  string `json:"path"`  // Database file, created when missing
+	Table string `json:"table"` // Table name; defaults to the query label
+	Mode  string `json:"mode"`  // "append" (default) or "replace" an existing table
+}
+
+// KafkaOutput configures publishing every row as a JSON message to a Kafka topic
+type KafkaOutput struct {
+	Brokers      []string `json:"brokers"`       // Bootstrap brokers as host:port
+	Topic        string   `json:"topic"`         // Topic the messages are published to
+	KeyColumn    string   `json:"key_column"`    // Column whose value keys each message; unkeyed when empty
+	BatchSize    int      `json:"batch_size"`    // Messages per produce request (default 100)
+	BatchTimeout Duration `json:"batch_timeout"` // How long a partly filled batch waits before it is sent (default 100ms)
+	RequiredAcks string   `json:"required_acks"` // "all" (default), "one" or "none"
+	Retries      int      `json:"retries"`       // Retries per failed batch
+}
+
+// GCSOutput configures uploading the output files to Google Cloud Storage
+type GCSOutput struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix"` // Optional object name prefix, e.g. "exports/daily"
+}
+
+// HTTPOutput configures POSTing results to a webhook/API endpoint
+type HTTPOutput struct {
+	URL          string            `json:"url"`
+	Headers      map[string]string `json:"headers"`       // Extra request headers (e.g. Authorization)
+	Format       string            `json:"format"`        // "json" (default) or "ndjson"
+	BatchSize    int               `json:"batch_size"`    // Rows per request; 0 sends all rows at once
+	Retries      int               `json:"retries"`       // Retries per failed batch
+	RetryBackoff Duration          `json:"retry_backoff"` // Initial delay between retries (default 1s)
+}
+
+// Supported values for Workload.RowCheck
+const (
+	RowCheckFail = "fail"
+	RowCheckWarn = "warn"
+)
+
+// WatermarkPlaceholder is replaced in the query by the last watermark value
+const WatermarkPlaceholder = "{{watermark}}"
+
+// Watermark configures incremental collection: each run only fetches rows
+// whose Column is greater than the highest value seen on the previous run
+type Watermark struct {
+	Column    string `json:"column"`     // Column compared against the last value
+	StateFile string `json:"state_file"` // JSON file recording the last value per query and target
+	Initial   string `json:"initial"`    // Bound used for targets without a recorded value (optional)
+}
+
+// DefaultCheckpointMaxAge is how long a checkpoint can be resumed when
+// Checkpoint.MaxAge isn't set
+const DefaultCheckpointMaxAge = 24 * time.Hour
+
+// Checkpoint configures recording, per query, the targets whose rows are in
+// the output, so a run interrupted part way can be resumed with -resume
+type Checkpoint struct {
+	StateFile string   `json:"state_file"` // JSON file recording the written targets per query
+	MaxAge    Duration `json:"max_age"`    // Checkpoints older than this are discarded (default 24h)
+}
+
+// TargetGroup gives the targets it matches their own worker limit
+type TargetGroup struct {
+	Name    string   `json:"name"`
+	Targets []string `json:"targets"` // Target entries or globs, matched like -only
+	Workers int      `json:"workers"` // Concurrent targets of this group
+}
+
+// Discovery sources targets from a query run against a bootstrap database:
+// the first column of every row returned is a target
+type Discovery struct {
+	Host       string `json:"host"`        // Bootstrap target, in the same form as targets entries
+	Database   string `json:"database"`    // Database the query runs in (default DB_NAME)
+	Query      string `json:"query"`       // Read-only query listing the target hosts
+	MaxTargets int    `json:"max_targets"` // Keep at most this many discovered targets (default 1000)
+}
+
+// ComputedColumn is a column whose value is an expression evaluated per row,
+// e.g. {"name": "total", "expr": "float(price) * int(quantity)"}
+type ComputedColumn struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"` // expr-lang expression; the row's columns are its variables
+}
+
+// Database holds connection settings in the workload itself. Each field set
+// takes precedence over its DB_* variable, which stays the fallback for the
+// fields left out (e.g. a password kept out of the file).
+type Database struct {
+	Type        string `json:"type"`
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	User        string `json:"user"`
+	Password    string `json:"password"`
+	Name        string `json:"database"`
+	SSLMode     string `json:"sslmode"`
+	Socket      string `json:"socket"`
+	SSLRootCert string `json:"ssl_root_cert"`
+	SSLCert     string `json:"ssl_cert"`
+	SSLKey      string `json:"ssl_key"`
+}
+
+// Variables returns the settings that are set, keyed by the DB_* variable
+// each one replaces
+func (d *Database) Variables() map[string]string {
+	variables := map[string]string{
+		"DB_TYPE":          d.Type,
+		"DB_HOST":          d.Host,
+		"DB_USER":          d.User,
+		"DB_PASSWORD":      d.Password,
+		"DB_NAME":          d.Name,
+		"DB_SSL_MODE":      d.SSLMode,
+		"DB_SOCKET":        d.Socket,
+		"DB_SSL_ROOT_CERT": d.SSLRootCert,
+		"DB_SSL_CERT":      d.SSLCert,
+		"DB_SSL_KEY":       d.SSLKey,
+	}
+	if d.Port != 0 {
+		variables["DB_PORT"] = strconv.Itoa(d.Port)
+	}
+	for name, value := range variables {
+		if value == "" {
+			delete(variables, name)
+		}
+	}
+	return variables
+}
+
+// SSHTunnel describes the bastion host used to reach the targets
+type SSHTunnel struct {
+	Host                  string `json:"host"`
+	Port                  int    `json:"port"` // Defaults to 22
+	User                  string `json:"user"`
+	KeyFile               string `json:"key_file"`
+	KeyPassphrase         string `json:"key_passphrase"`
+	KnownHostsFile        string `json:"known_hosts_file"` // Defaults to ~/.ssh/known_hosts
+	InsecureIgnoreHostKey bool   `json:"insecure_ignore_host_key"`
+}
+
+// WriteOptions returns the CSV write options for the workload's aggregated output
+func (w *Workload) WriteOptions() WriteOptions {
+	return WriteOptions{
+		Directory:  w.OutputDir,
+		Filename:   w.OutputFile,
+		AppendDate: true,
+
+		ColumnTypesMode:  w.ColumnTypes,
+		QuoteAll:         w.QuoteAll,
+		SanitizeFormulas: w.SanitizeFormulas,
+		Format:           w.OutputFormat.Delimited(),
+		FlushRows:        w.FlushRows,
+		Encoding:         w.Encoding,
+		NullValue:        w.NullSentinel(),
+
+		FileMode: os.FileMode(w.FileMode),
+		DirMode:  os.FileMode(w.DirMode),
+	}
+}
+
+// OutputColumn returns the output header of a query column, applying column_aliases
+func (w *Workload) OutputColumn(column string) string {
+	if alias, ok := w.ColumnAliases[column]; ok {
+		return alias
+	}
+	return column
+}
+
+// NullSentinel returns the configured NULL representation, defaulting to
+// database.DefaultNullValue
+func (w *Workload) NullSentinel() string {
+	if w.NullValue == nil {
+		return database.DefaultNullValue
+	}
+	return *w.NullValue
+}
+
+// Samples reports whether targets keep only a random sample of their rows
+func (w *Workload) Samples() bool {
+	return w.SampleRate > 0 || w.SampleN > 0
+}
+
+// WritesEmpty reports whether header-only output is written for a result
+// without rows, defaulting to true
+func (w *Workload) WritesEmpty() bool {
+	return w.WriteEmpty == nil || *w.WriteEmpty
 }
 
 // LoadWorkloadConfig reads and parses the workload configuration file
@@ -0,0 +1,27 @@
+package models
+
+import "testing"
+
+func TestNullSentinel(t *testing.T) {
+	empty, escaped := "", `\N`
+	tests := []struct {
+		name      string
+		nullValue *string
+		want      string
+	}{
+		{"unset", nil, "NULL"},
+		{"empty", &empty, ""},
+		{"escaped", &escaped, `\N`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workload := &Workload{NullValue: tt.nullValue}
+			if got := workload.NullSentinel(); got != tt.want {
+				t.Errorf("NullSentinel() = %q, want %q", got, tt.want)
+			}
+			if got := workload.WriteOptions().NullValue; got != tt.want {
+				t.Errorf("WriteOptions().NullValue = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package output
+
+import (
+	encodingcsv "encoding/csv"
+	"fmt"
+	"io"
+	"os"
+
+	"datacollector/csv"
+	"datacollector/database"
+	"datacollector/models"
+)
+
+// CSVSink writes results to a CSV file using csv.WriteToCSV
+type CSVSink struct {
+	Options  models.WriteOptions
+	AppendTo string // Existing output file the rows are appended to instead of a new file (-resume)
+
+	path string
+}
+
+// NewCSVSink creates a CSV file sink with the given write options
+func NewCSVSink(options models.WriteOptions) *CSVSink {
+	return &CSVSink{Options: options}
+}
+
+// Write writes result to a new CSV file
+func (s *CSVSink) Write(result *database.QueryResult) error {
+	options := s.Options
+	options.ColumnTypes = result.ColumnTypes
+
+	if s.AppendTo != "" {
+		if err := csv.AppendToOutput(s.AppendTo, result.Columns, options, func(emit func([]string) error) error {
+			for _, row := range result.Rows {
+				if err := emit(row); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		s.path = s.AppendTo
+		return nil
+	}
+
+	path, err := csv.WriteToCSV(result.Rows, result.Columns, options)
+	if err != nil {
+		return err
+	}
+	s.path = path
+	return nil
+}
+
+// WriteSpill turns a spilled aggregate into the output file. When the spill
+// can be used as is it is simply renamed into place; a types row, quote_all,
+// sanitize_formulas, a non-CSV format or another encoding means it is
+// streamed into a new file instead. With AppendTo the spilled rows are
+// appended to that file and the spill file is left in place.
+func (s *CSVSink) WriteSpill(spillPath string, result *database.QueryResult) (string, error) {
+	options := s.Options
+	options.ColumnTypes = result.ColumnTypes
+
+	if s.AppendTo != "" {
+		if err := csv.AppendToOutput(s.AppendTo, result.Columns, options, func(emit func([]string) error) error {
+			return readSpillRows(spillPath, emit)
+		}); err != nil {
+			return spillPath, err
+		}
+		s.path = s.AppendTo
+		return spillPath, nil
+	}
+
+	path, err := csv.OutputPath(options)
+	if err != nil {
+		return spillPath, err
+	}
+
+	// dataPath is where the plain header + rows data lives once we're done
+	dataPath := spillPath
+	withTypes := options.ColumnTypesMode == models.ColumnTypesRow && len(result.Columns) > 0
+	if withTypes || options.QuoteAll || options.SanitizeFormulas || options.Comma() != ',' || options.Reencodes() {
+		var types []string
+		if withTypes {
+			types = csv.AlignTypes(result.Columns, result.ColumnTypes)
+		}
+		if err := copySpill(spillPath, path, types, options); err != nil {
+			return spillPath, err
+		}
+	} else {
+		dataPath = path
+		if err := os.Rename(spillPath, path); err != nil {
+			return spillPath, fmt.Errorf("error moving spill file into place: %w", err)
+		}
+		// Temporary files are created 0600; give the output its configured mode
+		if err := os.Chmod(path, options.FilePerm()); err != nil {
+			return path, fmt.Errorf("error setting output file mode: %w", err)
+		}
+	}
+
+	if options.ColumnTypesMode == models.ColumnTypesSidecar && len(result.Columns) > 0 {
+		if err := csv.WriteTypesSidecar(path+".types", result.Columns, result.ColumnTypes, options.FilePerm()); err != nil {
+			return dataPath, err
+		}
+	}
+
+	s.path = path
+	return dataPath, nil
+}
+
+// copySpill rewrites a spill file to path with the writer options applied,
+// inserting types as a row after the header when it is non-nil
+func copySpill(spillPath string, path string, types []string, options models.WriteOptions) error {
+	in, err := os.Open(spillPath)
+	if err != nil {
+		return fmt.Errorf("error opening spill file: %w", err)
+	}
+	defer in.Close()
+
+	out, err := csv.CreateFile(path, options.FilePerm())
+	if err != nil {
+		return fmt.Errorf("error creating CSV file: %w", err)
+	}
+	defer out.Close()
+
+	reader := encodingcsv.NewReader(in)
+	writer := csv.NewWriter(out, options)
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading spill file: %w", err)
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("error writing data to CSV: %w", err)
+		}
+		if first && types != nil {
+			if err := writer.Write(types); err != nil {
+				return fmt.Errorf("error writing column types to CSV: %w", err)
+			}
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("error writing data to CSV: %w", err)
+	}
+	return nil
+}
+
+// Files returns the path of the last file written
+func (s *CSVSink) Files() []string {
+	if s.path == "" {
+		return nil
+	}
+	return []string{s.path}
+}
+
+// StdoutSink writes results as CSV to standard output (or another writer)
+type StdoutSink struct {
+	Writer io.Writer // Defaults to os.Stdout
+	Comma  rune      // Field delimiter; defaults to ','
+}
+
+// Write writes the header and rows of result as CSV
+func (s *StdoutSink) Write(result *database.QueryResult) error {
+	out := s.Writer
+	if out == nil {
+		out = os.Stdout
+	}
+
+	writer := encodingcsv.NewWriter(out)
+	if s.Comma != 0 {
+		writer.Comma = s.Comma
+	}
+	if len(result.Columns) > 0 {
+		if err := writer.Write(result.Columns); err != nil {
+			return fmt.Errorf("error writing headers to stdout: %w", err)
+		}
+	}
+	if err := writer.WriteAll(result.Rows); err != nil {
+		return fmt.Errorf("error writing data to stdout: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package output
+
+import (
+	"datacollector/csv"
+	"datacollector/database"
+	"datacollector/models"
+)
+
+// FormatsSink writes the same result in several file formats (e.g. CSV for
+// people and JSON for machines). All files share one name stem, e.g.
+// results_2025-04-17_103000_aB3x.csv and results_2025-04-17_103000_aB3x.json.
+type FormatsSink struct {
+	Options models.WriteOptions // Options.NullValue is written as null by the JSON format
+	Formats []string            // Output formats, written in this order
+
+	files []string
+}
+
+// NewFormatsSink creates a sink writing one file per format
+func NewFormatsSink(options models.WriteOptions, formats []string) *FormatsSink {
+	return &FormatsSink{Options: options, Formats: formats}
+}
+
+// Write writes result once per format
+func (s *FormatsSink) Write(result *database.QueryResult) error {
+	sinks, err := s.start()
+	if err != nil {
+		return err
+	}
+	for _, sink := range sinks {
+		if err := sink.Write(result); err != nil {
+			return err
+		}
+		s.files = append(s.files, sink.Files()...)
+	}
+	return nil
+}
+
+// WriteSpill writes a spilled aggregate once per format. A format that
+// adopts the spill as its file hands that file on to the following formats.
+func (s *FormatsSink) WriteSpill(spillPath string, result *database.QueryResult) (string, error) {
+	sinks, err := s.start()
+	if err != nil {
+		return spillPath, err
+	}
+	for _, sink := range sinks {
+		newPath, err := sink.(SpillSink).WriteSpill(spillPath, result)
+		if err != nil {
+			return spillPath, err
+		}
+		spillPath = newPath
+		s.files = append(s.files, sink.Files()...)
+	}
+	return spillPath, nil
+}
+
+// Files returns the files written by the last Write, in format order
+func (s *FormatsSink) Files() []string {
+	return s.files
+}
+
+// start reserves the shared name stem and returns a sink per format pinned to it
+func (s *FormatsSink) start() ([]FileSink, error) {
+	s.files = nil
+
+	extensions := make([]string, len(s.Formats))
+	for i, format := range s.Formats {
+		options := s.Options
+		options.Format = format
+		extensions[i] = options.Extension()
+	}
+	stem, err := csv.ReserveStem(s.Options, extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	sinks := make([]FileSink, len(s.Formats))
+	for i, format := range s.Formats {
+		options := s.Options
+		options.Format = format
+		options.Filename = stem
+		options.AppendDate = false
+		if format == models.OutputFormatJSON {
+			sinks[i] = &JSONSink{Options: options, NullValue: options.NullValue}
+		} else {
+			sinks[i] = NewCSVSink(options)
+		}
+	}
+	return sinks, nil
+}
@@ -0,0 +1,58 @@
+package output
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"datacollector/database"
+	"datacollector/models"
+)
+
+func TestFormatsSinkEmptyNullValue(t *testing.T) {
+	empty := ""
+	workload := &models.Workload{OutputDir: t.TempDir(), OutputFile: "nulls", NullValue: &empty}
+	sink := NewFormatsSink(workload.WriteOptions(), []string{models.OutputFormatCSV, models.OutputFormatJSON})
+
+	// The query layer renders NULL as the sentinel, here the empty string
+	result := &database.QueryResult{
+		Columns: []string{"id", "name", "note"},
+		Rows:    [][]string{{"1", "", "x"}, {"2", "b", ""}},
+	}
+	if err := sink.Write(result); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	files := sink.Files()
+	if len(files) != 2 {
+		t.Fatalf("Files() = %v, want a CSV and a JSON file", files)
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "id,name,note\n1,,x\n2,b,\n"; string(data) != want {
+		t.Errorf("CSV = %q, want %q", data, want)
+	}
+
+	data, err = os.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		t.Fatalf("JSON output: %v\n%s", err, data)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("JSON has %d objects, want 2", len(objects))
+	}
+	if value, ok := objects[0]["name"]; !ok || value != nil {
+		t.Errorf(`row 1 "name" = %v, want null`, value)
+	}
+	if value, ok := objects[1]["note"]; !ok || value != nil {
+		t.Errorf(`row 2 "note" = %v, want null`, value)
+	}
+	if objects[0]["note"] != "x" {
+		t.Errorf(`row 1 "note" = %v, want "x"`, objects[0]["note"])
+	}
+}
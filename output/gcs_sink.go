@@ -0,0 +1,152 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+
+	"datacollector/database"
+	"datacollector/logging"
+)
+
+// DefaultGCSEndpoint is the root of the Cloud Storage JSON API
+const DefaultGCSEndpoint = "https://storage.googleapis.com"
+
+// gcsScope is the OAuth scope needed to create objects
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// GCSSink uploads the files produced by a local file sink to a Google Cloud
+// Storage bucket. It must come after Source in a MultiSink, so the local file
+// is already safely written when the upload starts.
+type GCSSink struct {
+	Bucket string
+	Prefix string   // Prepended to each file's base name to form the object name
+	Source FileSink // The sink whose files are uploaded
+
+	// Context cancels an upload in progress, e.g. on a signal or when
+	// max_runtime expires; nil never cancels
+	Context context.Context
+
+	// Client makes the API requests; it defaults to one authorised with
+	// Google application default credentials
+	Client *http.Client
+	// Endpoint defaults to DefaultGCSEndpoint
+	Endpoint string
+
+	uploaded []string
+}
+
+// Write uploads the files the source sink just wrote; the rows themselves
+// are not used
+func (s *GCSSink) Write(result *database.QueryResult) error {
+	return s.upload()
+}
+
+// WriteSpill uploads the source's files, leaving the spilled data where it is
+func (s *GCSSink) WriteSpill(spillPath string, result *database.QueryResult) (string, error) {
+	return spillPath, s.upload()
+}
+
+// URIs returns the gs:// URIs of the objects uploaded by the last Write
+func (s *GCSSink) URIs() []string {
+	return s.uploaded
+}
+
+// upload copies every source file to the bucket
+func (s *GCSSink) upload() error {
+	s.uploaded = nil
+	files := s.Source.Files()
+	if len(files) == 0 {
+		return errors.New("no local file to upload")
+	}
+
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	client := s.Client
+	if client == nil {
+		var err error
+		client, err = google.DefaultClient(ctx, gcsScope)
+		if err != nil {
+			return fmt.Errorf("error creating GCS client: %w", err)
+		}
+	}
+
+	for _, file := range files {
+		object := path.Join(s.Prefix, filepath.Base(file))
+		if err := s.uploadFile(ctx, client, file, object); err != nil {
+			return fmt.Errorf("error uploading %s to gs://%s/%s: %w", file, s.Bucket, object, err)
+		}
+		uri := fmt.Sprintf("gs://%s/%s", s.Bucket, object)
+		s.uploaded = append(s.uploaded, uri)
+		logging.Infof("Uploaded %s to %s", file, uri)
+	}
+	return nil
+}
+
+// uploadFile streams one local file into a bucket object with a single
+// media upload. The object is only created once the whole body has arrived,
+// so a read error or a cancelled ctx, which abort the request, never leave a
+// truncated object behind.
+func (s *GCSSink) uploadFile(ctx context.Context, client *http.Client, file string, object string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultGCSEndpoint
+	}
+	uploadURL := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		strings.TrimRight(endpoint, "/"), url.PathEscape(s.Bucket), url.QueryEscape(object))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, in)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", contentType(file))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("cloud storage returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// contentType returns the MIME type of an output file from its extension
+func contentType(file string) string {
+	name := strings.ToLower(file)
+	if strings.HasSuffix(name, ".gz") {
+		return "application/gzip"
+	}
+	switch filepath.Ext(name) {
+	case ".csv":
+		return "text/csv"
+	case ".tsv":
+		return "text/tab-separated-values"
+	case ".json":
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,119 @@
+package output
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"datacollector/database"
+)
+
+// staticFiles is a FileSink whose files are already on disk
+type staticFiles []string
+
+func (f staticFiles) Write(result *database.QueryResult) error { return nil }
+func (f staticFiles) Files() []string                          { return f }
+
+// upload is one object received by fakeGCS
+type upload struct {
+	path        string
+	name        string
+	contentType string
+	body        string
+}
+
+// fakeGCS accepts media uploads like the Cloud Storage JSON API
+func fakeGCS(t *testing.T, status int) (*httptest.Server, func() []upload) {
+	t.Helper()
+	var mu sync.Mutex
+	var uploads []upload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		uploads = append(uploads, upload{
+			path:        r.URL.Path,
+			name:        r.URL.Query().Get("name"),
+			contentType: r.Header.Get("Content-Type"),
+			body:        string(body),
+		})
+		mu.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, `{"kind": "storage#object"}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []upload {
+		mu.Lock()
+		defer mu.Unlock()
+		return uploads
+	}
+}
+
+// outputFiles writes name -> content files to a temporary directory
+func outputFiles(t *testing.T, contents map[string]string) staticFiles {
+	t.Helper()
+	dir := t.TempDir()
+	var files staticFiles
+	for name, content := range contents {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, path)
+	}
+	return files
+}
+
+func TestGCSSinkUpload(t *testing.T) {
+	server, uploads := fakeGCS(t, http.StatusOK)
+	files := outputFiles(t, map[string]string{"out.tsv": "id\tname\n1\ta\n"})
+	sink := &GCSSink{Bucket: "exports", Prefix: "daily", Source: files, Client: server.Client(), Endpoint: server.URL}
+
+	if err := sink.Write(nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got := uploads()
+	if len(got) != 1 {
+		t.Fatalf("got %d uploads, want 1", len(got))
+	}
+	want := upload{path: "/upload/storage/v1/b/exports/o", name: "daily/out.tsv", contentType: "text/tab-separated-values", body: "id\tname\n1\ta\n"}
+	if got[0] != want {
+		t.Errorf("upload = %+v, want %+v", got[0], want)
+	}
+	if uris := sink.URIs(); len(uris) != 1 || uris[0] != "gs://exports/daily/out.tsv" {
+		t.Errorf("URIs() = %v, want [gs://exports/daily/out.tsv]", uris)
+	}
+}
+
+func TestGCSSinkUploadRejected(t *testing.T) {
+	server, _ := fakeGCS(t, http.StatusForbidden)
+	files := outputFiles(t, map[string]string{"out.csv": "id\n1\n"})
+	sink := &GCSSink{Bucket: "exports", Source: files, Client: server.Client(), Endpoint: server.URL}
+
+	err := sink.Write(nil)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Write() error = %v, want the 403 response", err)
+	}
+	if len(sink.URIs()) != 0 {
+		t.Errorf("URIs() = %v, want none after a failed upload", sink.URIs())
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := map[string]string{
+		"out.csv":     "text/csv",
+		"out.TSV":     "text/tab-separated-values",
+		"out.json":    "application/json",
+		"out.csv.gz":  "application/gzip",
+		"out.parquet": "application/octet-stream",
+	}
+	for file, want := range tests {
+		if got := contentType(file); got != want {
+			t.Errorf("contentType(%q) = %q, want %q", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"datacollector/database"
+	"datacollector/logging"
+)
+
+// HTTP body formats supported by HTTPSink
+const (
+	HTTPFormatJSON   = "json"   // One JSON array of row objects per batch
+	HTTPFormatNDJSON = "ndjson" // One JSON object per line
+)
+
+// HTTPSink POSTs results to an HTTP endpoint in batches of rows
+type HTTPSink struct {
+	URL       string
+	Headers   map[string]string
+	Format    string        // HTTPFormatJSON (default) or HTTPFormatNDJSON
+	BatchSize int           // Rows per request; 0 sends everything in one request
+	Retries   int           // Extra attempts per batch after the first failure
+	Backoff   time.Duration // Delay before the first retry, doubled on each attempt
+	NullValue string        // Values equal to this sentinel are sent as JSON null
+	Client    *http.Client  // Defaults to a client with a 30s timeout
+
+	// Context cancels requests and retry waits, e.g. on a signal or when
+	// max_runtime expires; nil never cancels
+	Context context.Context
+
+	// Batch outcome counters from the last Write
+	Succeeded int
+	Failed    int
+}
+
+// Write sends every row of result to the endpoint, batch by batch
+func (s *HTTPSink) Write(result *database.QueryResult) error {
+	s.Succeeded, s.Failed = 0, 0
+
+	batchSize := s.BatchSize
+	if batchSize <= 0 || batchSize > len(result.Rows) {
+		batchSize = len(result.Rows)
+	}
+
+	// Always send at least one (possibly empty) batch so the receiver sees the run
+	var lastErr error
+	for start := 0; start == 0 || start < len(result.Rows); start += batchSize {
+		end := start + batchSize
+		if end > len(result.Rows) {
+			end = len(result.Rows)
+		}
+
+		body, err := s.encode(result.Columns, result.Rows[start:end])
+		if err != nil {
+			return err
+		}
+
+		if err := s.post(body); err != nil {
+			logging.Warnf("Warning: HTTP batch of rows %d-%d failed: %v", start, end, err)
+			s.Failed++
+			lastErr = err
+		} else {
+			s.Succeeded++
+		}
+
+		if batchSize == 0 {
+			break
+		}
+	}
+
+	logging.Infof("HTTP output to %s: %d batch(es) succeeded, %d failed", s.URL, s.Succeeded, s.Failed)
+	if lastErr != nil {
+		return fmt.Errorf("%d of %d HTTP batch(es) failed, last error: %w", s.Failed, s.Succeeded+s.Failed, lastErr)
+	}
+	return nil
+}
+
+// encode renders rows as a JSON array or as newline-delimited JSON objects
+func (s *HTTPSink) encode(columns []string, rows [][]string) ([]byte, error) {
+	objects := rowObjects(columns, rows, s.NullValue)
+
+	if s.Format == HTTPFormatNDJSON {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		for _, object := range objects {
+			if err := encoder.Encode(object); err != nil {
+				return nil, fmt.Errorf("error encoding row as JSON: %w", err)
+			}
+		}
+		return buf.Bytes(), nil
+	}
+
+	body, err := json.Marshal(objects)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding rows as JSON: %w", err)
+	}
+	return body, nil
+}
+
+// post sends one batch, retrying with exponential backoff on network errors,
+// 5xx and 429 responses; other statuses won't change on a retry
+func (s *HTTPSink) post(body []byte) error {
+	ctx := s.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	contentType := "application/json"
+	if s.Format == HTTPFormatNDJSON {
+		contentType = "application/x-ndjson"
+	}
+
+	backoff := s.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	var err error
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("%w (retry cancelled: %v)", err, context.Cause(ctx))
+			}
+			backoff *= 2
+		}
+
+		err = s.postOnce(ctx, client, contentType, body)
+		if err == nil || !retryable(ctx, err) {
+			return err
+		}
+	}
+	return err
+}
+
+// httpStatusError is a response with a non-2xx status
+type httpStatusError struct {
+	code   int
+	status string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %s", e.status)
+}
+
+// retryable reports whether a failed POST may succeed when sent again: the
+// request didn't get through, or the server is failing or rate limiting
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500 || statusErr.code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// postOnce performs a single POST and treats non-2xx statuses as errors
+func (s *HTTPSink) postOnce(ctx context.Context, client *http.Client, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range s.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &httpStatusError{code: resp.StatusCode, status: resp.Status}
+	}
+	return nil
+}
+
+// rowObjects converts rows to objects keyed by column name, mapping the NULL
+// sentinel to a JSON null
+func rowObjects(columns []string, rows [][]string, nullValue string) []map[string]interface{} {
+	objects := make([]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		objects = append(objects, rowObject(columns, row, nullValue))
+	}
+	return objects
+}
+
+// rowObject converts one row to an object keyed by column name
+func rowObject(columns []string, row []string, nullValue string) map[string]interface{} {
+	object := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if i >= len(row) || row[i] == nullValue {
+			object[column] = nil
+		} else {
+			object[column] = row[i]
+		}
+	}
+	return object
+}
@@ -0,0 +1,74 @@
+package output
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"datacollector/database"
+)
+
+// statusServer answers every request with status and counts the requests
+func statusServer(t *testing.T, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestHTTPSinkRetries(t *testing.T) {
+	tests := []struct {
+		status   int
+		requests int32
+	}{
+		{http.StatusOK, 1},
+		{http.StatusBadRequest, 1},
+		{http.StatusUnauthorized, 1},
+		{http.StatusNotFound, 1},
+		{http.StatusTooManyRequests, 3},
+		{http.StatusInternalServerError, 3},
+		{http.StatusServiceUnavailable, 3},
+	}
+	result := &database.QueryResult{Columns: []string{"id"}, Rows: [][]string{{"1"}}}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server, requests := statusServer(t, tt.status)
+			sink := &HTTPSink{URL: server.URL, Retries: 2, Backoff: time.Millisecond}
+			err := sink.Write(result)
+			if (err == nil) != (tt.status == http.StatusOK) {
+				t.Errorf("Write() error = %v", err)
+			}
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("sent %d request(s), want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestHTTPSinkCancelledRetry(t *testing.T) {
+	server, requests := statusServer(t, http.StatusServiceUnavailable)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sink := &HTTPSink{URL: server.URL, Retries: 5, Backoff: time.Minute, Context: ctx}
+
+	start := time.Now()
+	if err := sink.Write(&database.QueryResult{Columns: []string{"id"}}); err == nil {
+		t.Fatal("Write() succeeded against a failing endpoint")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Write() took %v after the context ended", elapsed)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("sent %d request(s), want 1 before the cancelled wait", got)
+	}
+	if sink.Failed != 1 || sink.Succeeded != 0 {
+		t.Errorf("Succeeded = %d, Failed = %d, want 0 and 1", sink.Succeeded, sink.Failed)
+	}
+}
@@ -0,0 +1,118 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"datacollector/csv"
+	"datacollector/database"
+	"datacollector/models"
+)
+
+// JSONSink writes results to a .json file holding an array of row objects,
+// one per line, with keys in column order and the NULL sentinel as null
+type JSONSink struct {
+	Options   models.WriteOptions // Naming and permissions; Format is ignored
+	NullValue string
+
+	path string
+}
+
+// Write writes result to a new JSON file
+func (s *JSONSink) Write(result *database.QueryResult) error {
+	return s.write(result.Columns, func(emit func([]string) error) error {
+		for _, row := range result.Rows {
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteSpill streams a spilled aggregate into a new JSON file; the spill
+// file itself is left in place
+func (s *JSONSink) WriteSpill(spillPath string, result *database.QueryResult) (string, error) {
+	return spillPath, s.write(result.Columns, func(emit func([]string) error) error {
+		return readSpillRows(spillPath, emit)
+	})
+}
+
+// Files returns the JSON file written by the last Write
+func (s *JSONSink) Files() []string {
+	if s.path == "" {
+		return nil
+	}
+	return []string{s.path}
+}
+
+// write creates the output file and encodes every row produced by rows
+func (s *JSONSink) write(columns []string, rows func(emit func([]string) error) error) error {
+	options := s.Options
+	options.Format = models.OutputFormatJSON
+	path, err := csv.OutputPath(options)
+	if err != nil {
+		return err
+	}
+	file, err := csv.CreateFile(path, options.FilePerm())
+	if err != nil {
+		return fmt.Errorf("error creating JSON file: %w", err)
+	}
+	defer file.Close()
+
+	// Encode the keys once; they are the same for every row
+	keys := make([][]byte, len(columns))
+	for i, column := range columns {
+		if keys[i], err = json.Marshal(column); err != nil {
+			return fmt.Errorf("error encoding column name as JSON: %w", err)
+		}
+	}
+
+	out := bufio.NewWriter(file)
+	out.WriteString("[")
+	count := 0
+	err = rows(func(row []string) error {
+		var buf bytes.Buffer
+		if count > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n{")
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			buf.Write(key)
+			buf.WriteString(":")
+			if i >= len(row) || row[i] == s.NullValue {
+				buf.WriteString("null")
+				continue
+			}
+			value, err := json.Marshal(row[i])
+			if err != nil {
+				return fmt.Errorf("error encoding row as JSON: %w", err)
+			}
+			buf.Write(value)
+		}
+		buf.WriteString("}")
+		count++
+		_, err := out.Write(buf.Bytes())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error writing data to JSON: %w", err)
+	}
+	if count > 0 {
+		out.WriteString("\n")
+	}
+	out.WriteString("]\n")
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("error writing data to JSON: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error closing JSON file: %w", err)
+	}
+	s.path = path
+	return nil
+}
@@ -0,0 +1,190 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+
+	"datacollector/database"
+	"datacollector/logging"
+)
+
+// Acknowledgements KafkaSink waits for before a message counts as delivered
+const (
+	KafkaAcksAll  = "all"  // Every in-sync replica (default)
+	KafkaAcksOne  = "one"  // The partition leader only
+	KafkaAcksNone = "none" // None; a message counts as delivered once sent
+)
+
+// Defaults for the batching of KafkaSink
+const (
+	defaultKafkaBatchSize    = 100
+	defaultKafkaBatchTimeout = 100 * time.Millisecond
+)
+
+// KafkaSink publishes every row of a result as a JSON object message to a
+// Kafka topic. Rows are sent in batches; a batch that fails after its retries
+// is counted and publishing goes on, unless nothing of it was delivered (the
+// brokers or the topic are unavailable), which stops the write.
+type KafkaSink struct {
+	Brokers      []string
+	Topic        string
+	KeyColumn    string        // Column whose value keys each message; NULL or empty KeyColumn sends no key
+	BatchSize    int           // Messages per produce request; defaults to 100
+	BatchTimeout time.Duration // How long a partly filled batch waits; defaults to 100ms
+	RequiredAcks string        // KafkaAcksAll (default), KafkaAcksOne or KafkaAcksNone
+	Retries      int           // Extra attempts per batch after the first failure
+	NullValue    string        // Values equal to this sentinel are sent as JSON null
+
+	// Delivery outcome of the last Write
+	Delivered int
+	Failed    int
+	LastError error
+}
+
+// Write publishes every row of result
+func (s *KafkaSink) Write(result *database.QueryResult) error {
+	return s.write(result, func(emit func([]string) error) error {
+		for _, row := range result.Rows {
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteSpill publishes a spilled aggregate batch by batch without loading it;
+// the spill file itself is left in place
+func (s *KafkaSink) WriteSpill(spillPath string, result *database.QueryResult) (string, error) {
+	return spillPath, s.write(result, func(emit func([]string) error) error {
+		return readSpillRows(spillPath, emit)
+	})
+}
+
+// write publishes every row produced by rows, flushing a batch whenever
+// BatchSize messages are pending
+func (s *KafkaSink) write(result *database.QueryResult, rows func(emit func([]string) error) error) error {
+	s.Delivered, s.Failed, s.LastError = 0, 0, nil
+
+	keyIndex := -1
+	if s.KeyColumn != "" {
+		keyIndex = slices.Index(result.Columns, s.KeyColumn)
+		if keyIndex < 0 {
+			return fmt.Errorf("kafka.key_column %q is not a column of the result", s.KeyColumn)
+		}
+	}
+
+	batchSize := s.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultKafkaBatchSize
+	}
+	batchTimeout := s.BatchTimeout
+	if batchTimeout <= 0 {
+		batchTimeout = defaultKafkaBatchTimeout
+	}
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(s.Brokers...),
+		Topic:        s.Topic,
+		Balancer:     &kafka.Murmur2Balancer{}, // Partitions keys like the Java client; unkeyed messages spread randomly
+		BatchSize:    batchSize,
+		BatchTimeout: batchTimeout,
+		RequiredAcks: kafkaRequiredAcks(s.RequiredAcks),
+		MaxAttempts:  s.Retries + 1,
+	}
+	defer writer.Close()
+
+	pending := make([]kafka.Message, 0, batchSize)
+	flush := func() error {
+		err := s.flush(writer, pending)
+		pending = pending[:0]
+		return err
+	}
+	err := rows(func(row []string) error {
+		value, err := json.Marshal(rowObject(result.Columns, row, s.NullValue))
+		if err != nil {
+			return fmt.Errorf("error encoding row as JSON: %w", err)
+		}
+		message := kafka.Message{Value: value}
+		if keyIndex >= 0 && keyIndex < len(row) && row[keyIndex] != s.NullValue {
+			message.Key = []byte(row[keyIndex])
+		}
+		pending = append(pending, message)
+		if len(pending) == batchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && len(pending) > 0 {
+		err = flush()
+	}
+
+	logging.Infof("Kafka output to topic %s: %d message(s) delivered, %d failed", s.Topic, s.Delivered, s.Failed)
+	if err != nil {
+		return err
+	}
+	if s.Failed > 0 {
+		return fmt.Errorf("%d of %d Kafka message(s) failed, last error: %w", s.Failed, s.Delivered+s.Failed, s.LastError)
+	}
+	return nil
+}
+
+// flush publishes one batch and counts its deliveries. A message too large
+// for a produce request fails on its own and the rest is sent without it.
+// It returns an error only when none of the batch could be delivered.
+func (s *KafkaSink) flush(writer *kafka.Writer, messages []kafka.Message) error {
+	for len(messages) > 0 {
+		err := writer.WriteMessages(context.Background(), messages...)
+		if err == nil {
+			s.Delivered += len(messages)
+			return nil
+		}
+
+		var tooLarge kafka.MessageTooLargeError
+		if errors.As(err, &tooLarge) {
+			logging.Warnf("Warning: Kafka message of %d bytes exceeds the batch size limit and was not sent", len(tooLarge.Message.Value))
+			s.Failed++
+			s.LastError = err
+			messages = tooLarge.Remaining
+			continue
+		}
+
+		failed := len(messages)
+		var writeErrors kafka.WriteErrors
+		if errors.As(err, &writeErrors) {
+			failed = writeErrors.Count()
+			for _, messageErr := range writeErrors {
+				if messageErr != nil {
+					err = messageErr
+					break
+				}
+			}
+		}
+		logging.Warnf("Warning: Kafka batch of %d message(s) failed for %d: %v", len(messages), failed, err)
+		s.Delivered += len(messages) - failed
+		s.Failed += failed
+		s.LastError = err
+		if failed == len(messages) {
+			return fmt.Errorf("publishing to Kafka topic %s stopped after %d message(s) delivered: %w", s.Topic, s.Delivered, err)
+		}
+		return nil
+	}
+	return nil
+}
+
+// kafkaRequiredAcks maps a configured acknowledgement level to the client's
+func kafkaRequiredAcks(acks string) kafka.RequiredAcks {
+	switch acks {
+	case KafkaAcksOne:
+		return kafka.RequireOne
+	case KafkaAcksNone:
+		return kafka.RequireNone
+	default:
+		return kafka.RequireAll
+	}
+}
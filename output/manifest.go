@@ -0,0 +1,165 @@
+package output
+
+import (
+	"crypto/sha256"
+	encodingcsv "encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ManifestSuffix is appended to the aggregate file's path to name its manifest
+const ManifestSuffix = ".manifest.json"
+
+// ManifestEntry describes one produced file
+type ManifestEntry struct {
+	File   string `json:"file"`   // Path relative to the manifest
+	Rows   int    `json:"rows"`   // Data rows, excluding header rows
+	Bytes  int64  `json:"bytes"`  // File size
+	SHA256 string `json:"sha256"` // Hex-encoded checksum of the file contents
+}
+
+// Manifest lists the files produced by a run so transfers can be verified
+type Manifest struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Files     []ManifestEntry `json:"files"`
+}
+
+// BuildManifest checksums and counts the rows of every file in paths: CSV,
+// or TSV and JSON going by the .tsv and .json extensions. The first
+// headerRows records of each CSV or TSV file are not counted as data. File
+// names are recorded relative to dir, where the manifest will be written.
+func BuildManifest(dir string, paths []string, headerRows int) (*Manifest, error) {
+	manifest := &Manifest{CreatedAt: time.Now().UTC(), Files: []ManifestEntry{}}
+	for _, path := range paths {
+		entry, err := manifestEntry(path, headerRows)
+		if err != nil {
+			return nil, err
+		}
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			entry.File = rel
+		}
+		manifest.Files = append(manifest.Files, entry)
+	}
+	return manifest, nil
+}
+
+// manifestEntry reads path once, hashing the bytes while counting its rows
+func manifestEntry(path string, headerRows int) (ManifestEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ManifestEntry{}, fmt.Errorf("error opening %s for manifest: %w", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	in := io.TeeReader(file, io.MultiWriter(hash, counter))
+
+	var rows int
+	switch filepath.Ext(path) {
+	case ".json":
+		rows, err = countJSONRows(in)
+	case ".tsv":
+		rows, err = countRecords(in, '\t', headerRows)
+	default:
+		rows, err = countRecords(in, ',', headerRows)
+	}
+	if err != nil {
+		return ManifestEntry{}, fmt.Errorf("error reading %s for manifest: %w", path, err)
+	}
+	io.Copy(io.Discard, in) // Hash anything the counter didn't consume
+	return ManifestEntry{
+		File:   path,
+		Rows:   rows,
+		Bytes:  counter.n,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// countRecords counts the delimited records of in past the first headerRows
+func countRecords(in io.Reader, comma rune, headerRows int) (int, error) {
+	reader := encodingcsv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	reader.Comma = comma
+	records := 0
+	for {
+		if _, err := reader.Read(); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		records++
+	}
+
+	if records < headerRows {
+		return 0, nil
+	}
+	return records - headerRows, nil
+}
+
+// countJSONRows counts the elements of the JSON array in in
+func countJSONRows(in io.Reader) (int, error) {
+	decoder := json.NewDecoder(in)
+	if token, err := decoder.Token(); err != nil {
+		return 0, err
+	} else if token != json.Delim('[') {
+		return 0, fmt.Errorf("expected a JSON array")
+	}
+	rows := 0
+	for decoder.More() {
+		var row json.RawMessage
+		if err := decoder.Decode(&row); err != nil {
+			return 0, err
+		}
+		rows++
+	}
+	return rows, nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// Write saves the manifest as indented JSON, through a temporary file that
+// is renamed into place so it never appears half written
+func (m *Manifest) Write(path string, perm os.FileMode) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating manifest: %w", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error setting manifest mode: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error moving manifest into place: %w", err)
+	}
+	return nil
+}
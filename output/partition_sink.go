@@ -0,0 +1,237 @@
+package output
+
+import (
+	encodingcsv "encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"datacollector/csv"
+	"datacollector/database"
+	"datacollector/logging"
+	"datacollector/models"
+)
+
+// PartitionedCSVSink writes the rows of a result to one file per distinct
+// value of Column, named <filename>_<value> (sanitized) with the usual date
+// suffix. Every file repeats the header.
+type PartitionedCSVSink struct {
+	Options models.WriteOptions
+	Column  string // Output column whose value selects the file
+
+	files      []string          // In order of first appearance
+	partitions map[string]string // Partition value -> file
+}
+
+// NewPartitionedCSVSink creates a sink partitioning rows by column
+func NewPartitionedCSVSink(options models.WriteOptions, column string) *PartitionedCSVSink {
+	return &PartitionedCSVSink{Options: options, Column: column}
+}
+
+// Write splits result into one file per partition value
+func (s *PartitionedCSVSink) Write(result *database.QueryResult) error {
+	p, err := s.start(result)
+	if err != nil {
+		return err
+	}
+	for _, row := range result.Rows {
+		if err := p.write(row); err != nil {
+			p.close()
+			return err
+		}
+	}
+	return s.finish(p)
+}
+
+// WriteSpill streams a spilled aggregate into the partition files; the spill
+// file itself is left in place
+func (s *PartitionedCSVSink) WriteSpill(spillPath string, result *database.QueryResult) (string, error) {
+	in, err := os.Open(spillPath)
+	if err != nil {
+		return spillPath, fmt.Errorf("error opening spill file: %w", err)
+	}
+	defer in.Close()
+
+	p, err := s.start(result)
+	if err != nil {
+		return spillPath, err
+	}
+	reader := encodingcsv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.close()
+			return spillPath, fmt.Errorf("error reading spill file: %w", err)
+		}
+		if first {
+			continue // The spill file's own header
+		}
+		if err := p.write(record); err != nil {
+			p.close()
+			return spillPath, err
+		}
+	}
+	return spillPath, s.finish(p)
+}
+
+// Files returns the partition files written by the last Write
+func (s *PartitionedCSVSink) Files() []string {
+	return s.files
+}
+
+// Partitions maps each partition value to its file
+func (s *PartitionedCSVSink) Partitions() map[string]string {
+	return s.partitions
+}
+
+// start prepares a partitioner for the columns of result
+func (s *PartitionedCSVSink) start(result *database.QueryResult) (*partitioner, error) {
+	s.files = nil
+	s.partitions = make(map[string]string)
+
+	column := -1
+	for i, name := range result.Columns {
+		if name == s.Column {
+			column = i
+			break
+		}
+	}
+	if column == -1 {
+		return nil, fmt.Errorf("partition_by column %q not in result", s.Column)
+	}
+
+	options := s.Options
+	options.ColumnTypes = result.ColumnTypes
+	return &partitioner{
+		options: options,
+		columns: result.Columns,
+		column:  column,
+		open:    make(map[string]*partitionFile),
+	}, nil
+}
+
+// finish closes every partition file and records where each one went
+func (s *PartitionedCSVSink) finish(p *partitioner) error {
+	if err := p.close(); err != nil {
+		return err
+	}
+	for _, value := range p.order {
+		path := p.open[value].path
+		s.files = append(s.files, path)
+		s.partitions[value] = path
+		logging.Infof("Partition %s=%q written to %s", s.Column, value, path)
+	}
+
+	if p.options.ColumnTypesMode == models.ColumnTypesSidecar {
+		for _, path := range s.files {
+			if err := csv.WriteTypesSidecar(path+".types", p.columns, p.options.ColumnTypes, p.options.FilePerm()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// partitioner routes rows to a lazily created writer per partition value
+type partitioner struct {
+	options models.WriteOptions
+	columns []string
+	column  int
+
+	open  map[string]*partitionFile
+	order []string
+}
+
+// partitionFile is one open partition output
+type partitionFile struct {
+	path   string
+	file   *os.File
+	writer csv.Writer
+}
+
+// write appends row to the file of its partition, creating it on first use
+func (p *partitioner) write(row []string) error {
+	value := ""
+	if p.column < len(row) {
+		value = row[p.column]
+	}
+
+	out, ok := p.open[value]
+	if !ok {
+		var err error
+		if out, err = p.create(value); err != nil {
+			return err
+		}
+		p.open[value] = out
+		p.order = append(p.order, value)
+	}
+	if err := out.writer.Write(row); err != nil {
+		return fmt.Errorf("error writing data to %s: %w", out.path, err)
+	}
+	return nil
+}
+
+// create opens the file for a partition value and writes its header rows
+func (p *partitioner) create(value string) (*partitionFile, error) {
+	options := p.options
+	options.Filename = fmt.Sprintf("%s_%s", options.Filename, partitionName(value))
+	path, err := csv.OutputPath(options)
+	if err != nil {
+		return nil, err
+	}
+	file, err := csv.CreateFile(path, options.FilePerm())
+	if err != nil {
+		return nil, fmt.Errorf("error creating CSV file: %w", err)
+	}
+
+	writer := csv.NewWriter(file, options)
+	if err := writer.Write(p.columns); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing headers to CSV: %w", err)
+	}
+	if options.ColumnTypesMode == models.ColumnTypesRow {
+		if err := writer.Write(csv.AlignTypes(p.columns, options.ColumnTypes)); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error writing column types to CSV: %w", err)
+		}
+	}
+	return &partitionFile{path: path, file: file, writer: writer}, nil
+}
+
+// close flushes and closes every partition file, returning the first error
+func (p *partitioner) close() error {
+	var firstErr error
+	for _, value := range p.order {
+		out := p.open[value]
+		out.writer.Flush()
+		if err := out.writer.Error(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error writing %s: %w", out.path, err)
+		}
+		if err := out.file.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error closing %s: %w", out.path, err)
+		}
+	}
+	return firstErr
+}
+
+// partitionName turns a partition value into a filesystem-safe name by
+// replacing every character outside [A-Za-z0-9._-] with an underscore
+func partitionName(value string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, value)
+	if name == "" {
+		return "_"
+	}
+	return name
+}
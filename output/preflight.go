@@ -0,0 +1,36 @@
+package output
+
+import (
+	"fmt"
+	"os"
+)
+
+// CheckWritable verifies before any work is done that files can be written
+// to dir: it is created (with dirPerm) if missing, and a temporary file is
+// written to it and removed again. An empty dir means the current directory.
+func CheckWritable(dir string, dirPerm os.FileMode) error {
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, dirPerm); err != nil {
+		return fmt.Errorf("output directory %s cannot be created: %w", dir, err)
+	}
+
+	probe, err := os.CreateTemp(dir, ".datacollector-preflight-*")
+	if err != nil {
+		return fmt.Errorf("output directory %s is not writable: %w", dir, err)
+	}
+	name := probe.Name()
+	_, writeErr := probe.WriteString("ok\n")
+	closeErr := probe.Close()
+	removeErr := os.Remove(name)
+	switch {
+	case writeErr != nil:
+		return fmt.Errorf("output directory %s is not writable: %w", dir, writeErr)
+	case closeErr != nil:
+		return fmt.Errorf("output directory %s is not writable: %w", dir, closeErr)
+	case removeErr != nil:
+		return fmt.Errorf("cannot remove files from output directory %s: %w", dir, removeErr)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"datacollector/csv"
+	"datacollector/database"
+	"datacollector/models"
+)
+
+// SharedCSVWriter is a Sink that many goroutines can write to at once,
+// appending each result's rows to a single CSV file. The header of the first
+// result written is emitted exactly once, followed by its column types as
+// options.ColumnTypesMode asks.
+type SharedCSVWriter struct {
+	mu            sync.Mutex
+	file          *os.File
+	writer        csv.Writer
+	options       models.WriteOptions
+	path          string
+	headers       []string
+	columnTypes   []string
+	headerWritten bool
+	rows          int
+	closed        bool
+}
+
+// NewSharedCSVWriter creates the output file described by options
+func NewSharedCSVWriter(options models.WriteOptions) (*SharedCSVWriter, error) {
+	path, err := csv.OutputPath(options)
+	if err != nil {
+		return nil, err
+	}
+	file, err := csv.CreateFile(path, options.FilePerm())
+	if err != nil {
+		return nil, fmt.Errorf("error creating CSV file: %w", err)
+	}
+	return &SharedCSVWriter{
+		file:    file,
+		writer:  csv.NewWriter(file, options),
+		options: options,
+		path:    path,
+	}, nil
+}
+
+// Write appends the rows of result, writing the header first if this is the
+// first result seen. It is safe for concurrent use.
+func (w *SharedCSVWriter) Write(result *database.QueryResult) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errors.New("shared CSV writer is closed")
+	}
+
+	if !w.headerWritten && len(result.Columns) > 0 {
+		if err := w.writer.Write(result.Columns); err != nil {
+			return fmt.Errorf("error writing headers to CSV: %w", err)
+		}
+		if w.options.ColumnTypesMode == models.ColumnTypesRow {
+			if err := w.writer.Write(csv.AlignTypes(result.Columns, result.ColumnTypes)); err != nil {
+				return fmt.Errorf("error writing column types to CSV: %w", err)
+			}
+		}
+		w.headers = result.Columns
+		w.columnTypes = result.ColumnTypes
+		w.headerWritten = true
+	}
+
+	if err := w.writer.WriteAll(result.Rows); err != nil {
+		return fmt.Errorf("error writing data to CSV: %w", err)
+	}
+	w.rows += len(result.Rows)
+	return nil
+}
+
+// Headers returns the header written to the file, if any
+func (w *SharedCSVWriter) Headers() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.headers
+}
+
+// Rows returns the number of data rows written so far
+func (w *SharedCSVWriter) Rows() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rows
+}
+
+// Files returns the path of the shared file
+func (w *SharedCSVWriter) Files() []string {
+	return []string{w.path}
+}
+
+// Close flushes buffered rows and closes the file, writing the column types
+// sidecar when options.ColumnTypesMode asks for one
+func (w *SharedCSVWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("error flushing CSV file: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("error closing CSV file: %w", err)
+	}
+	if w.options.ColumnTypesMode == models.ColumnTypesSidecar && len(w.headers) > 0 {
+		return csv.WriteTypesSidecar(w.path+".types", w.headers, w.columnTypes, w.options.FilePerm())
+	}
+	return nil
+}
+
+// Discard closes and removes the file, unless Close already finished it, so
+// output that is not wanted after all (a failed or empty run) leaves nothing
+// behind. It is safe to defer after NewSharedCSVWriter.
+func (w *SharedCSVWriter) Discard() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.file.Close()
+	os.Remove(w.path)
+}
@@ -0,0 +1,132 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"datacollector/csv"
+	"datacollector/database"
+	"datacollector/models"
+)
+
+// TestSharedCSVWriterConcurrent writes batches from many goroutines at once;
+// run it with -race. The header must be written once and every batch's rows
+// must stay together.
+func TestSharedCSVWriterConcurrent(t *testing.T) {
+	const writers, batches, rowsPerBatch = 16, 20, 25
+
+	writer, err := NewSharedCSVWriter(models.WriteOptions{Directory: t.TempDir(), Filename: "shared", FlushRows: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Discard()
+
+	columns := []string{"writer", "batch", "row"}
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*batches)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				result := &database.QueryResult{Columns: columns}
+				for r := 0; r < rowsPerBatch; r++ {
+					result.Rows = append(result.Rows, []string{strconv.Itoa(w), strconv.Itoa(b), strconv.Itoa(r)})
+				}
+				if err := writer.Write(result); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Write: %v", err)
+	}
+	if got, want := writer.Rows(), writers*batches*rowsPerBatch; got != want {
+		t.Errorf("Rows() = %d, want %d", got, want)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	records, err := csv.ReadCSV(writer.Files()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1+writers*batches*rowsPerBatch {
+		t.Fatalf("file has %d records, want the header and %d rows", len(records), writers*batches*rowsPerBatch)
+	}
+	if fmt.Sprint(records[0]) != fmt.Sprint(columns) {
+		t.Errorf("header = %v, want %v", records[0], columns)
+	}
+
+	// Each batch must be rowsPerBatch consecutive records numbered 0..n-1
+	seen := make(map[string]bool)
+	for start := 1; start < len(records); start += rowsPerBatch {
+		writerID, batch := records[start][0], records[start][1]
+		key := writerID + "/" + batch
+		if seen[key] {
+			t.Fatalf("batch %s written twice", key)
+		}
+		seen[key] = true
+		for r := 0; r < rowsPerBatch; r++ {
+			record := records[start+r]
+			if record[0] == columns[0] {
+				t.Fatalf("header repeated at record %d", start+r)
+			}
+			if record[0] != writerID || record[1] != batch || record[2] != strconv.Itoa(r) {
+				t.Fatalf("record %d = %v, want row %d of batch %s: batches were interleaved", start+r, record, r, key)
+			}
+		}
+	}
+	if len(seen) != writers*batches {
+		t.Errorf("found %d batches, want %d", len(seen), writers*batches)
+	}
+}
+
+func TestSharedCSVWriterDiscard(t *testing.T) {
+	result := &database.QueryResult{Columns: []string{"id"}, Rows: [][]string{{"1"}}}
+	tests := []struct {
+		name  string
+		write bool
+		close bool
+		kept  bool
+	}{
+		{"failed run", true, false, false},
+		{"empty run", false, false, false},
+		{"finished run", true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writer, err := NewSharedCSVWriter(models.WriteOptions{Directory: t.TempDir(), Filename: "pipeline"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.write {
+				if err := writer.Write(result); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.close {
+				if err := writer.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			writer.Discard()
+
+			_, err = os.Stat(writer.Files()[0])
+			if tt.kept && err != nil {
+				t.Errorf("Discard after Close removed the output: %v", err)
+			}
+			if !tt.kept && !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("Discard left the output behind (stat error %v)", err)
+			}
+		})
+	}
+}
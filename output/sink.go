@@ -0,0 +1,124 @@
+// Package output provides pluggable destinations for collected query results
+package output
+
+import (
+	"datacollector/csv"
+	"datacollector/database"
+	encodingcsv "encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Sink is a destination that collected query results can be written to
+type Sink interface {
+	Write(result *database.QueryResult) error
+}
+
+// FileSink is a Sink that produces files on local disk
+type FileSink interface {
+	Sink
+	// Files returns the paths written by the last successful Write
+	Files() []string
+}
+
+// SpillSink is a Sink that can consume an aggregate already written to disk
+// as a CSV file (header + rows) without loading it into memory
+type SpillSink interface {
+	Sink
+	// WriteSpill consumes the data at spillPath and returns where the data now
+	// lives (a sink may move it rather than copy it)
+	WriteSpill(spillPath string, result *database.QueryResult) (string, error)
+}
+
+// MultiSink writes the same result to several sinks in order, so outputs can
+// be chained (e.g. write a file AND upload it). Every sink is attempted even
+// if an earlier one fails; the errors are joined.
+type MultiSink []Sink
+
+// Write sends result to every sink
+func (m MultiSink) Write(result *database.QueryResult) error {
+	var errs []error
+	for _, sink := range m {
+		if err := sink.Write(result); err != nil {
+			errs = append(errs, fmt.Errorf("%T: %w", sink, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Files returns the files produced by every FileSink in the chain
+func (m MultiSink) Files() []string {
+	var files []string
+	for _, sink := range m {
+		if fileSink, ok := sink.(FileSink); ok {
+			files = append(files, fileSink.Files()...)
+		}
+	}
+	return files
+}
+
+// WriteSpill sends a spilled aggregate to every sink. Sinks implementing
+// SpillSink consume the file directly; the others receive the rows read back
+// from disk. It returns where the spilled data lives afterwards.
+func (m MultiSink) WriteSpill(spillPath string, result *database.QueryResult) (string, error) {
+	var errs []error
+	var loaded *database.QueryResult
+	for _, sink := range m {
+		if spillSink, ok := sink.(SpillSink); ok {
+			newPath, err := spillSink.WriteSpill(spillPath, result)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%T: %w", sink, err))
+				continue
+			}
+			spillPath = newPath
+			continue
+		}
+
+		// Fall back to loading the rows for sinks that need them in memory
+		if loaded == nil {
+			records, err := csv.ReadCSV(spillPath)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%T: %w", sink, err))
+				continue
+			}
+			loaded = &database.QueryResult{Columns: result.Columns, ColumnTypes: result.ColumnTypes}
+			if len(records) > 0 {
+				loaded.Rows = records[1:]
+			}
+		}
+		if err := sink.Write(loaded); err != nil {
+			errs = append(errs, fmt.Errorf("%T: %w", sink, err))
+		}
+	}
+	return spillPath, errors.Join(errs...)
+}
+
+// readSpillRows calls emit for every data row of a spill file (a CSV whose
+// first record is the header), in order
+func readSpillRows(spillPath string, emit func([]string) error) error {
+	in, err := os.Open(spillPath)
+	if err != nil {
+		return fmt.Errorf("error opening spill file: %w", err)
+	}
+	defer in.Close()
+
+	reader := encodingcsv.NewReader(in)
+	reader.FieldsPerRecord = -1
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading spill file: %w", err)
+		}
+		if first {
+			continue // The header
+		}
+		if err := emit(record); err != nil {
+			return err
+		}
+	}
+}
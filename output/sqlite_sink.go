@@ -0,0 +1,155 @@
+package output
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite" // Pure-Go SQLite driver registered as "sqlite"
+
+	"datacollector/database"
+	"datacollector/logging"
+)
+
+// Ways SQLiteSink treats a table that already exists
+const (
+	SQLiteModeAppend  = "append"  // Insert into the existing table (default)
+	SQLiteModeReplace = "replace" // Drop and recreate the table
+)
+
+// SQLiteSink writes results into a table of a local SQLite database, creating
+// the database file and the table as needed. All rows are inserted in one
+// transaction, so a failed write leaves the table as it was.
+type SQLiteSink struct {
+	Path      string // Database file
+	Table     string // Table name, used as is (quoted)
+	Mode      string // SQLiteModeAppend (default) or SQLiteModeReplace
+	NullValue string // Sentinel stored as SQL NULL
+}
+
+// Write inserts the rows of result
+func (s *SQLiteSink) Write(result *database.QueryResult) error {
+	return s.write(result, func(emit func([]string) error) error {
+		for _, row := range result.Rows {
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteSpill streams a spilled aggregate into the table; the spill file
+// itself is left in place
+func (s *SQLiteSink) WriteSpill(spillPath string, result *database.QueryResult) (string, error) {
+	return spillPath, s.write(result, func(emit func([]string) error) error {
+		return readSpillRows(spillPath, emit)
+	})
+}
+
+// write (re)creates the table for the columns of result and inserts every
+// row produced by rows
+func (s *SQLiteSink) write(result *database.QueryResult, rows func(emit func([]string) error) error) error {
+	if len(result.Columns) == 0 {
+		logging.Warnf("Warning: no columns to write to SQLite table %s", s.Table)
+		return nil
+	}
+	if dir := filepath.Dir(s.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating SQLite database directory: %w", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", s.Path)
+	if err != nil {
+		return fmt.Errorf("error opening SQLite database %s: %w", s.Path, err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting SQLite transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	table := quoteSQLiteIdent(s.Table)
+	if s.Mode == SQLiteModeReplace {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return fmt.Errorf("error dropping SQLite table %s: %w", s.Table, err)
+		}
+	}
+
+	definitions := make([]string, len(result.Columns))
+	names := make([]string, len(result.Columns))
+	placeholders := make([]string, len(result.Columns))
+	for i, column := range result.Columns {
+		columnType := ""
+		if i < len(result.ColumnTypes) {
+			columnType = result.ColumnTypes[i]
+		}
+		names[i] = quoteSQLiteIdent(column)
+		definitions[i] = names[i] + " " + sqliteType(columnType)
+		placeholders[i] = "?"
+	}
+	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(definitions, ", "))
+	if _, err := tx.Exec(create); err != nil {
+		return fmt.Errorf("error creating SQLite table %s: %w", s.Table, err)
+	}
+
+	insert, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		table, strings.Join(names, ", "), strings.Join(placeholders, ", ")))
+	if err != nil {
+		return fmt.Errorf("error preparing insert into SQLite table %s: %w", s.Table, err)
+	}
+	defer insert.Close()
+
+	count := 0
+	args := make([]interface{}, len(result.Columns))
+	err = rows(func(row []string) error {
+		for i := range args {
+			if i >= len(row) || row[i] == s.NullValue {
+				args[i] = nil
+			} else {
+				args[i] = row[i]
+			}
+		}
+		if _, err := insert.Exec(args...); err != nil {
+			return fmt.Errorf("error inserting row into SQLite table %s: %w", s.Table, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing SQLite transaction: %w", err)
+	}
+	logging.Infof("Inserted %d rows into SQLite table %s in %s", count, s.Table, s.Path)
+	return nil
+}
+
+// sqliteType maps a source column type (as reported in QueryResult.ColumnTypes)
+// to the SQLite column type with the matching affinity. Values are inserted as
+// text and converted by SQLite according to that affinity.
+func sqliteType(columnType string) string {
+	upper := strings.ToUpper(columnType)
+	switch {
+	case strings.Contains(upper, "INT"), upper == "BOOL", upper == "BOOLEAN", upper == "BIT":
+		return "INTEGER"
+	case strings.Contains(upper, "FLOAT"), strings.Contains(upper, "DOUBLE"), strings.Contains(upper, "REAL"):
+		return "REAL"
+	case strings.Contains(upper, "DECIMAL"), strings.Contains(upper, "NUMERIC"):
+		return "NUMERIC"
+	default:
+		return "TEXT"
+	}
+}
+
+// quoteSQLiteIdent quotes a table or column name for SQLite
+func quoteSQLiteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// profileMarker separates a profile prefix from the DB_* variable name,
+// e.g. PROD_DB_HOST belongs to profile PROD
+const profileMarker = "_DB_"
+
+// envProfile resolves DB_* variables for the selected profile: PREFIX_DB_X
+// wins over DB_X, so a profile only needs to set what differs. Settings from
+// the workload's database block win over both.
+// The zero value reads the unprefixed variables.
+type envProfile struct {
+	Name     string            // Upper-cased profile name, empty when no profile is selected
+	Workload map[string]string // DB_* values set in the workload's database block
+	DotEnv   map[string]bool   // Variables that came from the .env file rather than the environment
+}
+
+// loadProfile selects the named profile, checking that at least one
+// <NAME>_DB_* variable exists for it
+func loadProfile(name string) (envProfile, error) {
+	if name == "" {
+		return envProfile{}, nil
+	}
+	profile := envProfile{Name: strings.ToUpper(name)}
+	available := availableProfiles()
+	for _, candidate := range available {
+		if candidate == profile.Name {
+			return profile, nil
+		}
+	}
+	if len(available) == 0 {
+		return envProfile{}, fmt.Errorf("unknown profile %q: no <PROFILE>%s* variables are set", name, profileMarker)
+	}
+	return envProfile{}, fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(available, ", "))
+}
+
+// availableProfiles lists the prefixes of all <PROFILE>_DB_* variables, sorted
+func availableProfiles() []string {
+	seen := make(map[string]bool)
+	for _, entry := range os.Environ() {
+		key, _, _ := strings.Cut(entry, "=")
+		if i := strings.Index(key, profileMarker); i > 0 {
+			seen[key[:i]] = true
+		}
+	}
+	profiles := make([]string, 0, len(seen))
+	for profile := range seen {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+// key returns the profile-specific name of variable name
+func (p envProfile) key(name string) string {
+	if p.Name == "" {
+		return name
+	}
+	return p.Name + "_" + name
+}
+
+// Getenv returns the workload's or the profile's value of name, falling back
+// to the unprefixed variable
+func (p envProfile) Getenv(name string) string {
+	if value, ok := p.Workload[name]; ok {
+		return value
+	}
+	if value := os.Getenv(p.key(name)); value != "" {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// SecretEnv is getSecretEnv for the profile: a value from the workload wins,
+// then the profile's <name>_FILE or <name> when either is set, otherwise the
+// unprefixed pair
+func (p envProfile) SecretEnv(name string) (string, error) {
+	if value, ok := p.Workload[name]; ok {
+		return value, nil
+	}
+	key := p.key(name)
+	if os.Getenv(key+"_FILE") != "" || os.Getenv(key) != "" {
+		return getSecretEnv(key)
+	}
+	return getSecretEnv(name)
+}
+
+// source describes where Getenv, or SecretEnv when secret is set, finds name:
+// the workload's database block, the profile's variable or the unprefixed
+// one (or a _FILE of either). It is "" when name is set nowhere.
+func (p envProfile) source(name string, secret bool) string {
+	if _, ok := p.Workload[name]; ok {
+		return "workload database block"
+	}
+	keys := []string{name}
+	if key := p.key(name); key != name {
+		keys = []string{key, name}
+	}
+	for _, key := range keys {
+		if secret && os.Getenv(key+"_FILE") != "" {
+			return p.variableSource(key + "_FILE")
+		}
+		if os.Getenv(key) != "" {
+			return p.variableSource(key)
+		}
+	}
+	return ""
+}
+
+// variableSource names an environment variable as a source, noting when the
+// .env file set it
+func (p envProfile) variableSource(key string) string {
+	if p.DotEnv[key] {
+		return "env " + key + " (.env)"
+	}
+	return "env " + key
+}
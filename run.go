@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"datacollector/database"
+	"datacollector/executor"
+	"datacollector/logging"
+	"datacollector/models"
+	"datacollector/output"
+	"datacollector/state"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errRunAborted marks a query failure that must stop the remaining queries too
+var errRunAborted = errors.New("run aborted")
+
+// runQuery executes the workload's query on every target and writes the
+// aggregated result to the configured sinks. When watermarks is non-nil the
+// new per-target watermarks are saved once the output has been written, and
+// when checkpoints is non-nil so are the targets written.
+// The outcome is recorded in summary as the run progresses.
+func runQuery(ctx context.Context, workload *models.Workload, dbConfig database.Config, watermarks *state.Watermarks,
+	checkpoints *state.Checkpoints, summary *querySummary) error {
+	sinks, err := buildSinks(ctx, workload)
+	if err != nil {
+		return fmt.Errorf("invalid output configuration: %w", err)
+	}
+
+	// With -resume, skip the targets an interrupted run already wrote and
+	// append to its output; targets keeps the full list for the checkpoint
+	targets := workload.Targets
+	var fingerprint string
+	var resumed *state.Checkpoint
+	if checkpoints != nil {
+		fingerprint = checkpointFingerprint(workload, dbConfig)
+		resumed = resumeCheckpoint(checkpoints, workload, fingerprint)
+	}
+	if resumed != nil {
+		workload.Targets = remainingTargets(targets, resumed)
+		for _, target := range targets {
+			if !slices.Contains(workload.Targets, target) {
+				summary.Resumed = append(summary.Resumed, target)
+			}
+		}
+		logging.Infof("Resuming query %s from the checkpoint saved at %s: %d of %d target(s) already written, %d left",
+			workload.QueryName, resumed.SavedAt.Format(time.RFC3339), len(summary.Resumed), len(targets), len(workload.Targets))
+		if len(workload.Targets) == 0 {
+			summary.Files = append(summary.Files, resumed.OutputFiles...)
+			logging.Infof("Nothing left to collect for query %s", workload.QueryName)
+			return saveCheckpoint(checkpoints, workload, targets, resumed, fingerprint, nil, executor.ExecutionResult{})
+		}
+		if len(resumed.OutputFiles) > 0 {
+			for _, sink := range sinks {
+				if csvSink, ok := sink.(*output.CSVSink); ok {
+					csvSink.AppendTo = resumed.OutputFiles[0]
+					logging.Infof("Appending the rows of query %s to %s", workload.QueryName, csvSink.AppendTo)
+				}
+			}
+		}
+	}
+
+	// With pipeline_output the rows reach the output file while later targets
+	// are still queried; an aborted, failed or empty query removes the file
+	var pipe executor.ResultWriter
+	var pipeline *output.SharedCSVWriter
+	if workload.PipelineOutput {
+		pipeline, err = output.NewSharedCSVWriter(workload.WriteOptions())
+		if err != nil {
+			return fmt.Errorf("failed to create the output file: %w", err)
+		}
+		defer pipeline.Discard()
+		pipe = pipeline
+	}
+
+	// Execute queries in parallel using the executor package
+	collectStart := time.Now()
+	result := executor.QueryTargetsTo(ctx, workload, dbConfig, pipe)
+	summary.collectElapsed = time.Since(collectStart)
+	if resumed != nil {
+		// Per-target files of the earlier run are listed with this run's
+		if result.TargetFiles == nil {
+			result.TargetFiles = make(map[string]string)
+		}
+		for target, path := range resumed.TargetFiles {
+			if _, ok := result.TargetFiles[target]; !ok {
+				result.TargetFiles[target] = path
+			}
+		}
+	}
+	summary.record(workload.Targets, result)
+
+	logErrorSummary(result.Errors)
+
+	// With fail_fast (or a failed aggregation) the run aborts before writing output
+	if result.Err != nil {
+		return fmt.Errorf("%w: %v", errRunAborted, result.Err)
+	}
+
+	logFailovers(workload.Targets, result.ServedBy)
+
+	// Whatever was collected before the deadline or a signal is still written below
+	if result.Truncated {
+		logging.Warnf("Run truncated by deadline after %v: %d target(s) did not complete; writing partial results.",
+			workload.MaxRuntime.Duration, result.Incomplete)
+	} else if interrupted := interruption(ctx); interrupted != "" {
+		logging.Warnf("Run %s: %d target(s) did not complete; writing partial results.", interrupted, result.Incomplete)
+	}
+
+	// Check for complete failure
+	if !result.HasResults && result.ErrorCount == len(workload.Targets) {
+		return errors.New("all target queries failed, no data to write")
+	}
+	if !result.HasResults && result.ErrorCount < len(workload.Targets) {
+		logging.Warnf("Warning: No data rows retrieved from any successful target.")
+		// Proceed to write empty file with headers if columns were found, or just log completion
+	}
+
+	// Write aggregated results to every sink, even if only headers are
+	// available unless write_empty is off; streamed targets wrote their own
+	// files and there is nothing to aggregate
+	if workload.StreamPerTarget {
+		for _, target := range targets {
+			if path, ok := result.TargetFiles[target]; ok {
+				summary.Files = append(summary.Files, path)
+			}
+		}
+		logging.Infof("Streamed %d rows from %d targets (out of %d) to %d per-target file(s); no aggregated output is written.",
+			result.RowCount, len(workload.Targets)-result.ErrorCount, len(workload.Targets), len(result.TargetFiles))
+	} else if result.RowCount > 0 || (result.HasResults && workload.WritesEmpty()) {
+		if pipeline != nil {
+			logging.Infof("Wrote %d rows from %d targets (out of %d) while querying. Finishing output...",
+				result.RowCount, len(workload.Targets)-result.ErrorCount, len(workload.Targets))
+		} else {
+			logging.Infof("Aggregated %d rows from %d targets (out of %d). Writing output...",
+				result.RowCount, len(workload.Targets)-result.ErrorCount, len(workload.Targets))
+		}
+		var writeErr error
+		files := sinks.Files
+		switch {
+		case pipeline != nil:
+			// The rows are in the file already; it only needs finishing
+			writeErr = pipeline.Close()
+			files = pipeline.Files
+		case result.SpillPath != "":
+			// The aggregate lives on disk; sinks adopt or stream it, and any leftover is removed
+			var finalPath string
+			finalPath, writeErr = sinks.WriteSpill(result.SpillPath, result.Aggregate())
+			if finalPath == result.SpillPath {
+				os.Remove(result.SpillPath)
+			}
+		default:
+			writeErr = sinks.Write(result.Aggregate())
+		}
+		// Record deliveries and log the local files even if a later sink (e.g. an upload) failed
+		for _, sink := range sinks {
+			switch deliverySink := sink.(type) {
+			case *output.HTTPSink:
+				summary.HTTP = newHTTPSummary(deliverySink)
+			case *output.KafkaSink:
+				summary.Kafka = newKafkaSummary(deliverySink)
+			}
+		}
+		summary.Files = append(summary.Files, files()...)
+		for _, target := range targets {
+			if path, ok := result.TargetFiles[target]; ok {
+				summary.Files = append(summary.Files, path)
+			}
+		}
+		for _, outputPath := range files() {
+			absPath, _ := filepath.Abs(outputPath)
+			logging.Infof("Aggregated data successfully written to file: %s", absPath)
+		}
+		if writeErr != nil {
+			return fmt.Errorf("failed to write aggregated data: %w", writeErr)
+		}
+
+		// Describe the finalized files for transfer verification
+		if workload.Manifest {
+			if err := writeManifest(workload, targets, files(), result.TargetFiles); err != nil {
+				return fmt.Errorf("failed to write manifest: %w", err)
+			}
+		}
+	} else if result.HasResults {
+		logging.Infof("No data rows to write; no output written (write_empty is false).")
+	} else {
+		logging.Infof("No data rows to write.")
+	}
+
+	// Likewise the checkpoint only lists targets whose rows are written
+	if checkpoints != nil {
+		if err := saveCheckpoint(checkpoints, workload, targets, resumed, fingerprint, sinks.Files(), result); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+	}
+
+	// Advance the watermarks only now that the rows are safely written
+	if watermarks != nil && len(result.Watermarks) > 0 {
+		for host, value := range result.Watermarks {
+			watermarks.Set(workload.QueryName, host, value)
+		}
+		if err := watermarks.Save(); err != nil {
+			return fmt.Errorf("failed to save watermark state: %w", err)
+		}
+		logging.Infof("Updated watermarks for %d target(s) in %s", len(result.Watermarks), workload.Watermark.StateFile)
+	}
+
+	return nil
+}
+
+// writeManifest writes <aggregate file>.manifest.json listing the aggregate
+// file(s) and any per-target files, in the order of targets
+func writeManifest(workload *models.Workload, targets []string, files []string, targetFiles map[string]string) error {
+	if len(files) == 0 {
+		logging.Warnf("Warning: manifest requested but no output files were written")
+		return nil
+	}
+	for _, target := range targets {
+		if path, ok := targetFiles[target]; ok {
+			files = append(files, path)
+		}
+	}
+
+	headerRows := 1
+	if workload.ColumnTypes == models.ColumnTypesRow {
+		headerRows++
+	}
+
+	manifestPath := files[0] + output.ManifestSuffix
+	manifest, err := output.BuildManifest(filepath.Dir(manifestPath), files, headerRows)
+	if err != nil {
+		return err
+	}
+	if err := manifest.Write(manifestPath, workload.WriteOptions().FilePerm()); err != nil {
+		return err
+	}
+	logging.Infof("Manifest for %d file(s) written to %s", len(manifest.Files), manifestPath)
+	return nil
+}
+
+// logErrorSummary logs failed targets grouped by error category
+func logErrorSummary(targetErrors []executor.TargetError) {
+	if len(targetErrors) == 0 {
+		return
+	}
+
+	byCategory := make(map[string][]string)
+	for _, targetErr := range targetErrors {
+		category := targetErr.Category()
+		byCategory[category] = append(byCategory[category], targetErr.Host)
+	}
+
+	categories := make([]string, 0, len(byCategory))
+	for category := range byCategory {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	logging.Errorf("Error summary: %d target(s) failed", len(targetErrors))
+	for _, category := range categories {
+		hosts := byCategory[category]
+		sort.Strings(hosts)
+		logging.Errorf("  %s (%d): %s", category, len(hosts), strings.Join(hosts, ", "))
+	}
+}
+
+// logFailovers reports which candidate served each target that lists
+// failover candidates, flagging those not served by their preferred host
+func logFailovers(targets []string, servedBy map[string]string) {
+	for _, target := range targets {
+		candidates := database.SplitCandidates(target)
+		host, ok := servedBy[target]
+		if len(candidates) < 2 || !ok {
+			continue
+		}
+		if host == candidates[0] {
+			logging.Infof("Target %s served by preferred host %s", target, host)
+		} else {
+			logging.Infof("Target %s served by failover host %s", target, host)
+		}
+	}
+}
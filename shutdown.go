@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"datacollector/logging"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defaultShutdownTimeout bounds a graceful shutdown when shutdown_timeout isn't set
+const defaultShutdownTimeout = 30 * time.Second
+
+// errInterrupted is the cause of a run context cancelled by SIGINT or SIGTERM
+var errInterrupted = errors.New("interrupted")
+
+// handleShutdownSignals returns a context that is cancelled with
+// errInterrupted on the first SIGINT or SIGTERM, so in-flight queries stop,
+// remaining targets are skipped and what was collected is still written. A
+// second signal, or the shutdown taking longer than timeout, exits at once.
+// The returned function stops listening for signals.
+func handleShutdownSignals(parent context.Context, timeout time.Duration) (context.Context, func()) {
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		var received os.Signal
+		select {
+		case received = <-signals:
+		case <-done:
+			return
+		}
+		logging.Warnf("Received %s: stopping the run and writing the results collected so far (send it again to exit immediately)", signalName(received))
+		cancel(fmt.Errorf("%w by %s", errInterrupted, signalName(received)))
+
+		select {
+		case received = <-signals:
+			log.Fatalf("Received %s again: exiting without finishing the output.", signalName(received))
+		case <-time.After(timeout):
+			log.Fatalf("Shutdown did not finish within %v (shutdown_timeout): exiting without finishing the output.", timeout)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel(nil)
+	}
+}
+
+// signalName returns the conventional name of a shutdown signal, e.g. "SIGTERM"
+func signalName(sig os.Signal) string {
+	switch sig {
+	case os.Interrupt:
+		return "SIGINT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	}
+	return sig.String()
+}
+
+// interruption returns the reason ctx was cancelled by a signal, or "" if it wasn't
+func interruption(ctx context.Context) string {
+	if cause := context.Cause(ctx); errors.Is(cause, errInterrupted) {
+		return cause.Error()
+	}
+	return ""
+}
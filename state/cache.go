@@ -0,0 +1,77 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"datacollector/database"
+)
+
+// ResultCache keeps query results on disk for a limited time, one file per
+// key. Keys are hashed, so anything that changes the result (host, query
+// text, ...) should be part of the key; a changed query then simply misses.
+type ResultCache struct {
+	Dir string
+	TTL time.Duration
+}
+
+// cachedResult is the file format of one cache entry
+type cachedResult struct {
+	StoredAt    time.Time  `json:"stored_at"`
+	Columns     []string   `json:"columns"`
+	ColumnTypes []string   `json:"column_types"`
+	Rows        [][]string `json:"rows"`
+}
+
+// Get returns the result stored under key if it is younger than the TTL,
+// along with when it was stored. A missing or expired entry is a miss
+// (ok false, nil error); an unreadable one is a miss with an error.
+func (c *ResultCache) Get(key string) (result *database.QueryResult, storedAt time.Time, ok bool, err error) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, time.Time{}, false, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, false, fmt.Errorf("error reading cache entry %s: %w", path, err)
+	}
+
+	var entry cachedResult
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, time.Time{}, false, fmt.Errorf("error parsing cache entry %s: %w", path, err)
+	}
+	if time.Since(entry.StoredAt) >= c.TTL {
+		return nil, entry.StoredAt, false, nil
+	}
+	return &database.QueryResult{
+		Columns:     entry.Columns,
+		ColumnTypes: entry.ColumnTypes,
+		Rows:        entry.Rows,
+	}, entry.StoredAt, true, nil
+}
+
+// Put stores result under key, replacing any previous entry
+func (c *ResultCache) Put(key string, result *database.QueryResult) error {
+	data, err := json.Marshal(cachedResult{
+		StoredAt:    time.Now(),
+		Columns:     result.Columns,
+		ColumnTypes: result.ColumnTypes,
+		Rows:        result.Rows,
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding cache entry: %w", err)
+	}
+	return writeFileAtomic(c.path(key), data)
+}
+
+// path returns the file of the entry for key
+func (c *ResultCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.Dir, hex.EncodeToString(sum[:])+".json")
+}
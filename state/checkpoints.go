@@ -0,0 +1,77 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Checkpoints records, per query, the targets whose rows are already in the
+// output, so an interrupted run can be resumed without collecting them again
+type Checkpoints struct {
+	Queries map[string]*Checkpoint `json:"queries"` // query label -> checkpoint
+
+	path string
+}
+
+// Checkpoint is the progress of one query
+type Checkpoint struct {
+	Fingerprint string            `json:"fingerprint"`            // Hash of the query and the settings shaping its output
+	SavedAt     time.Time         `json:"saved_at"`               // When the output was last written
+	OutputFiles []string          `json:"output_files"`           // Aggregate files holding the rows, appended to on resume
+	TargetFiles map[string]string `json:"target_files,omitempty"` // Per-target files written so far, by target
+	Completed   []string          `json:"completed"`              // Targets whose rows are in the output, in target order
+}
+
+// LoadCheckpoints reads the state file at path; a missing file yields empty state
+func LoadCheckpoints(path string) (*Checkpoints, error) {
+	checkpoints := &Checkpoints{Queries: map[string]*Checkpoint{}, path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoints, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint state %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, checkpoints); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint state %s: %w", path, err)
+	}
+	if checkpoints.Queries == nil {
+		checkpoints.Queries = map[string]*Checkpoint{}
+	}
+	return checkpoints, nil
+}
+
+// Get returns the checkpoint of a query, or nil when there is none
+func (c *Checkpoints) Get(query string) *Checkpoint {
+	return c.Queries[query]
+}
+
+// Set records the checkpoint of a query
+func (c *Checkpoints) Set(query string, checkpoint *Checkpoint) {
+	c.Queries[query] = checkpoint
+}
+
+// Delete forgets the checkpoint of a query
+func (c *Checkpoints) Delete(query string) {
+	delete(c.Queries, query)
+}
+
+// Save writes the state back to its file atomically; with no checkpoints
+// left the file is removed
+func (c *Checkpoints) Save() error {
+	if len(c.Queries) == 0 {
+		if err := os.Remove(c.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error removing checkpoint state: %w", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint state: %w", err)
+	}
+	return writeFileAtomic(c.path, data)
+}
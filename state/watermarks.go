@@ -0,0 +1,91 @@
+// Package state persists information between collection runs
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Watermarks records, per query and target, the highest watermark column
+// value collected so far
+type Watermarks struct {
+	Values map[string]map[string]string `json:"watermarks"` // query label -> host -> value
+
+	path string
+}
+
+// LoadWatermarks reads the state file at path; a missing file yields empty state
+func LoadWatermarks(path string) (*Watermarks, error) {
+	watermarks := &Watermarks{Values: map[string]map[string]string{}, path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return watermarks, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading watermark state %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, watermarks); err != nil {
+		return nil, fmt.Errorf("error parsing watermark state %s: %w", path, err)
+	}
+	if watermarks.Values == nil {
+		watermarks.Values = map[string]map[string]string{}
+	}
+	return watermarks, nil
+}
+
+// ForQuery returns the recorded values for a query, keyed by host
+func (w *Watermarks) ForQuery(query string) map[string]string {
+	values := make(map[string]string, len(w.Values[query]))
+	for host, value := range w.Values[query] {
+		values[host] = value
+	}
+	return values
+}
+
+// Set records the watermark for a query and host
+func (w *Watermarks) Set(query string, host string, value string) {
+	if w.Values[query] == nil {
+		w.Values[query] = map[string]string{}
+	}
+	w.Values[query][host] = value
+}
+
+// Save writes the state back to its file atomically
+func (w *Watermarks) Save() error {
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding watermark state: %w", err)
+	}
+	return writeFileAtomic(w.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place so readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating state directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating state file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("error replacing state file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"datacollector/executor"
+	"datacollector/logging"
+	"datacollector/output"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runSummary is the machine-readable record of a run written to summary_file
+type runSummary struct {
+	StartedAt      time.Time           `json:"started_at"`
+	FinishedAt     time.Time           `json:"finished_at"`
+	ElapsedSeconds float64             `json:"elapsed_seconds"`
+	Success        bool                `json:"success"`
+	TotalRows      int                 `json:"total_rows"`            // Rows collected over all queries
+	Interrupted    string              `json:"interrupted,omitempty"` // Set when a signal stopped the run, e.g. "interrupted by SIGTERM"
+	Truncated      bool                `json:"truncated,omitempty"`   // Set when max_runtime cut a query short
+	Queries        []querySummary      `json:"queries"`
+	PostCommand    *postCommandSummary `json:"post_command,omitempty"` // Set when post_command ran
+	Throughput     *throughputSummary  `json:"throughput,omitempty"`   // Over every query, with -benchmark
+}
+
+// querySummary describes the outcome of one query across its targets
+type querySummary struct {
+	Name              string             `json:"name"`
+	TargetsAttempted  int                `json:"targets_attempted"`
+	TargetsSucceeded  int                `json:"targets_succeeded"`
+	TargetsFailed     int                `json:"targets_failed"`
+	TargetsIncomplete int                `json:"targets_incomplete"`
+	Truncated         bool               `json:"truncated,omitempty"` // max_runtime expired before every target completed
+	Targets           []targetStatus     `json:"targets"`             // Outcome of every target, in target order
+	EmptyTargets      []string           `json:"empty_targets"`       // Targets that succeeded with no rows
+	Resumed           []string           `json:"resumed,omitempty"`   // Targets skipped with -resume, already written by an earlier run
+	Failures          []targetFailure    `json:"failures"`
+	Warnings          []targetFailure    `json:"warnings"`
+	Rows              int                `json:"rows"`
+	Groups            []groupSummary     `json:"groups,omitempty"` // Per worker pool, with target_groups
+	Files             []string           `json:"files"`
+	HTTP              *httpSummary       `json:"http,omitempty"`       // Set when the "http" destination was written
+	Kafka             *kafkaSummary      `json:"kafka,omitempty"`      // Set when the "kafka" destination was written
+	Throughput        *throughputSummary `json:"throughput,omitempty"` // With -benchmark
+	Error             string             `json:"error,omitempty"`
+
+	collectElapsed time.Duration                    // Wall time the targets were queried in
+	timings        map[string]executor.TargetTiming // Per successful target
+}
+
+// throughputSummary is the collection speed measured with -benchmark. Bytes
+// are the text size of the values fetched, before column settings.
+type throughputSummary struct {
+	ElapsedSeconds float64            `json:"elapsed_seconds"` // Wall time the targets were queried in, without writing the output
+	Rows           int                `json:"rows"`
+	Bytes          int64              `json:"bytes"`
+	RowsPerSecond  float64            `json:"rows_per_second"`
+	BytesPerSecond float64            `json:"bytes_per_second"`
+	Targets        []targetThroughput `json:"targets,omitempty"` // Every successful target, in target order
+}
+
+// targetThroughput is the collection speed of one target
+type targetThroughput struct {
+	Host           string  `json:"host"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"` // From connecting until its result was ready
+	Rows           int     `json:"rows"`
+	Bytes          int64   `json:"bytes"`
+	RowsPerSecond  float64 `json:"rows_per_second"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+// perSecond divides amount by elapsed, or returns 0 for no time at all
+func perSecond(amount float64, elapsed float64) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return amount / elapsed
+}
+
+// httpSummary is the outcome of the batches sent to the "http" destination
+type httpSummary struct {
+	URL       string `json:"url"`
+	Succeeded int    `json:"batches_succeeded"`
+	Failed    int    `json:"batches_failed"`
+}
+
+// newHTTPSummary records the batches of a written HTTP sink
+func newHTTPSummary(sink *output.HTTPSink) *httpSummary {
+	return &httpSummary{URL: sink.URL, Succeeded: sink.Succeeded, Failed: sink.Failed}
+}
+
+// kafkaSummary is the delivery outcome of the "kafka" destination
+type kafkaSummary struct {
+	Topic     string `json:"topic"`
+	Delivered int    `json:"delivered"`
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"` // Last delivery error
+}
+
+// newKafkaSummary records the deliveries of a written Kafka sink
+func newKafkaSummary(sink *output.KafkaSink) *kafkaSummary {
+	summary := &kafkaSummary{Topic: sink.Topic, Delivered: sink.Delivered, Failed: sink.Failed}
+	if sink.LastError != nil {
+		summary.Error = sink.LastError.Error()
+	}
+	return summary
+}
+
+// Values of targetStatus.Status
+const (
+	targetStatusOK         = "ok"         // Succeeded with rows
+	targetStatusEmpty      = "empty"      // Succeeded without rows
+	targetStatusFailed     = "failed"     // Listed under failures
+	targetStatusIncomplete = "incomplete" // Not run or cut short by cancellation
+)
+
+// targetStatus is the outcome of one target in a querySummary
+type targetStatus struct {
+	Host   string `json:"host"`
+	Status string `json:"status"`
+	Rows   int    `json:"rows"`
+	Bytes  int64  `json:"bytes,omitempty"` // Size of the per-target output file, if one was written
+}
+
+// groupSummary is the throughput of one worker pool in a querySummary
+type groupSummary struct {
+	Name             string  `json:"name"` // Empty for the targets outside every group
+	Workers          int     `json:"workers"`
+	Targets          int     `json:"targets"`
+	Succeeded        int     `json:"succeeded"`
+	Failed           int     `json:"failed"`
+	Rows             int     `json:"rows"`
+	ElapsedSeconds   float64 `json:"elapsed_seconds"`
+	TargetsPerSecond float64 `json:"targets_per_second"`
+}
+
+// targetFailure is one failed target in a querySummary
+type targetFailure struct {
+	Host      string `json:"host"`
+	Category  string `json:"category"`
+	Diagnosis string `json:"diagnosis,omitempty"` // Cause of a connection failure, e.g. "dns"
+	Error     string `json:"error"`
+}
+
+// record fills the summary from an execution result over targets
+func (q *querySummary) record(targets []string, result executor.ExecutionResult) {
+	q.TargetsAttempted = len(targets)
+	q.TargetsSucceeded = result.SuccessCount
+	q.TargetsFailed = result.ErrorCount
+	q.TargetsIncomplete = result.Incomplete
+	q.Truncated = result.Truncated
+	q.Rows = result.RowCount
+	q.timings = result.TargetTimings
+	failed := make(map[string]bool, len(result.Errors))
+	for _, targetErr := range result.Errors {
+		q.Failures = append(q.Failures, newTargetFailure(targetErr))
+		failed[targetErr.Host] = true
+	}
+	for _, target := range targets {
+		status := targetStatus{Host: target, Status: targetStatusIncomplete}
+		if rows, ok := result.TargetRows[target]; ok {
+			status.Rows = rows
+			status.Bytes = result.TargetBytes[target]
+			status.Status = targetStatusOK
+			if rows == 0 {
+				status.Status = targetStatusEmpty
+			}
+		} else if failed[target] {
+			status.Status = targetStatusFailed
+		}
+		q.Targets = append(q.Targets, status)
+	}
+	q.EmptyTargets = append(q.EmptyTargets, result.EmptyTargets...)
+	for _, targetErr := range result.Warnings {
+		q.Warnings = append(q.Warnings, newTargetFailure(targetErr))
+	}
+	for _, group := range result.Groups {
+		q.Groups = append(q.Groups, groupSummary{
+			Name:             group.Name,
+			Workers:          group.Workers,
+			Targets:          group.Targets,
+			Succeeded:        group.Succeeded,
+			Failed:           group.Failed,
+			Rows:             group.Rows,
+			ElapsedSeconds:   group.Elapsed.Seconds(),
+			TargetsPerSecond: group.TargetsPerSecond(),
+		})
+	}
+}
+
+// newTargetFailure converts a target error for the summary
+func newTargetFailure(targetErr executor.TargetError) targetFailure {
+	return targetFailure{
+		Host:      targetErr.Host,
+		Category:  targetErr.Category(),
+		Diagnosis: targetErr.Diagnosis(),
+		Error:     targetErr.Err.Error(),
+	}
+}
+
+// measureThroughput fills in the throughput of every query from its target
+// timings, and of the run from the queries' totals
+func (s *runSummary) measureThroughput() {
+	run := &throughputSummary{}
+	for i := range s.Queries {
+		query := &s.Queries[i]
+		throughput := &throughputSummary{ElapsedSeconds: query.collectElapsed.Seconds(), Rows: query.Rows}
+		for _, target := range query.Targets {
+			timing, ok := query.timings[target.Host]
+			if !ok {
+				continue
+			}
+			elapsed := timing.Elapsed.Seconds()
+			throughput.Bytes += timing.Bytes
+			throughput.Targets = append(throughput.Targets, targetThroughput{
+				Host:           target.Host,
+				ElapsedSeconds: elapsed,
+				Rows:           target.Rows,
+				Bytes:          timing.Bytes,
+				RowsPerSecond:  perSecond(float64(target.Rows), elapsed),
+				BytesPerSecond: perSecond(float64(timing.Bytes), elapsed),
+			})
+		}
+		throughput.RowsPerSecond = perSecond(float64(throughput.Rows), throughput.ElapsedSeconds)
+		throughput.BytesPerSecond = perSecond(float64(throughput.Bytes), throughput.ElapsedSeconds)
+		query.Throughput = throughput
+
+		run.ElapsedSeconds += throughput.ElapsedSeconds
+		run.Rows += throughput.Rows
+		run.Bytes += throughput.Bytes
+	}
+	run.RowsPerSecond = perSecond(float64(run.Rows), run.ElapsedSeconds)
+	run.BytesPerSecond = perSecond(float64(run.Bytes), run.ElapsedSeconds)
+	s.Throughput = run
+}
+
+// finish records the end of the run and the totals derived from the queries
+func (s *runSummary) finish(elapsed time.Duration, failedQueries int) {
+	s.FinishedAt = s.StartedAt.Add(elapsed)
+	s.ElapsedSeconds = elapsed.Seconds()
+	s.Truncated = s.truncated()
+	s.Success = failedQueries == 0 && s.Interrupted == "" && !s.Truncated && (s.PostCommand == nil || s.PostCommand.Error == "")
+	s.TotalRows = 0
+	for _, query := range s.Queries {
+		s.TotalRows += query.Rows
+	}
+}
+
+// truncated reports whether max_runtime cut any query short
+func (s *runSummary) truncated() bool {
+	for _, query := range s.Queries {
+		if query.Truncated {
+			return true
+		}
+	}
+	return false
+}
+
+// log prints the consolidated end-of-run summary: one line per query with
+// its row count and target outcomes, after a line with the totals
+func (s *runSummary) log() {
+	elapsed := s.FinishedAt.Sub(s.StartedAt).Round(time.Millisecond)
+	logging.Infof("Run summary: %d rows from %d queries in %v", s.TotalRows, len(s.Queries), elapsed)
+	if s.Interrupted != "" {
+		logging.Infof("  Run %s before it completed", s.Interrupted)
+	} else if s.Truncated {
+		logging.Infof("  Run truncated by max_runtime before it completed")
+	}
+	for _, query := range s.Queries {
+		name := query.Name
+		if name == "" {
+			name = "query" // The workload's inline query without an output_file
+		}
+		if query.TargetsAttempted == 0 && query.Error != "" {
+			logging.Infof("  %s: not run (%s)", name, query.Error)
+			continue
+		}
+		line := fmt.Sprintf("  %s: %d rows, %d/%d targets succeeded", name, query.Rows,
+			query.TargetsSucceeded, query.TargetsAttempted)
+		if len(query.EmptyTargets) > 0 {
+			line += fmt.Sprintf(", %d empty", len(query.EmptyTargets))
+		}
+		if len(query.Resumed) > 0 {
+			line += fmt.Sprintf(", %d already written before -resume", len(query.Resumed))
+		}
+		if len(query.Failures) > 0 {
+			hosts := make([]string, len(query.Failures))
+			for i, failure := range query.Failures {
+				hosts[i] = fmt.Sprintf("%s [%s]", failure.Host, failure.Category)
+			}
+			line += fmt.Sprintf(", failed: %s", strings.Join(hosts, ", "))
+		}
+		if query.TargetsIncomplete > 0 {
+			line += fmt.Sprintf(", %d incomplete", query.TargetsIncomplete)
+		}
+		if query.Error != "" {
+			line += fmt.Sprintf(" (query failed: %s)", query.Error)
+		}
+		logging.Infof("%s", line)
+		if httpOutput := query.HTTP; httpOutput != nil {
+			logging.Infof("    http %s: %d batch(es) succeeded, %d failed", httpOutput.URL, httpOutput.Succeeded, httpOutput.Failed)
+		}
+		if kafka := query.Kafka; kafka != nil {
+			logging.Infof("    kafka topic %s: %d message(s) delivered, %d failed", kafka.Topic, kafka.Delivered, kafka.Failed)
+		}
+		if throughput := query.Throughput; throughput != nil {
+			logging.Infof("    throughput: %.0f rows/s, %s/s (%d rows, %s in %.2fs)", throughput.RowsPerSecond,
+				formatBytes(throughput.BytesPerSecond), throughput.Rows, formatBytes(float64(throughput.Bytes)), throughput.ElapsedSeconds)
+			for _, target := range throughput.Targets {
+				logging.Infof("      %s: %.0f rows/s, %s/s (%d rows, %s in %.2fs)", target.Host, target.RowsPerSecond,
+					formatBytes(target.BytesPerSecond), target.Rows, formatBytes(float64(target.Bytes)), target.ElapsedSeconds)
+			}
+		}
+		for _, group := range query.Groups {
+			groupName := group.Name
+			if groupName == "" {
+				groupName = "(ungrouped)"
+			}
+			logging.Infof("    group %s: %d/%d targets succeeded, %d rows, %d workers, %.1fs (%.2f targets/s)",
+				groupName, group.Succeeded, group.Targets, group.Rows, group.Workers,
+				group.ElapsedSeconds, group.TargetsPerSecond)
+		}
+	}
+	if throughput := s.Throughput; throughput != nil {
+		logging.Infof("  Throughput: %.0f rows/s, %s/s over %.2fs of collection", throughput.RowsPerSecond,
+			formatBytes(throughput.BytesPerSecond), throughput.ElapsedSeconds)
+	}
+	if hook := s.PostCommand; hook != nil {
+		if hook.Error != "" {
+			logging.Infof("  post_command failed after %.1fs: %s", hook.ElapsedSeconds, hook.Error)
+		} else {
+			logging.Infof("  post_command succeeded in %.1fs", hook.ElapsedSeconds)
+		}
+	}
+}
+
+// formatBytes renders a byte count with a binary unit, e.g. "1.5 MiB"
+func formatBytes(bytes float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for bytes >= 1024 && unit < len(units)-1 {
+		bytes /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f B", bytes)
+	}
+	return fmt.Sprintf("%.1f %s", bytes, units[unit])
+}
+
+// printEmpty writes the targets that succeeded without rows to out, one per
+// line; with several queries each line is "<query>\t<target>"
+func (s *runSummary) printEmpty(out io.Writer) {
+	for _, query := range s.Queries {
+		for _, target := range query.EmptyTargets {
+			if len(s.Queries) > 1 {
+				fmt.Fprintf(out, "%s\t%s\n", query.Name, target)
+			} else {
+				fmt.Fprintln(out, target)
+			}
+		}
+	}
+}
+
+// write saves the summary as indented JSON to path
+func (s *runSummary) write(path string, perm os.FileMode, dirPerm os.FileMode) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding summary: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, dirPerm); err != nil {
+			return fmt.Errorf("error creating summary directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, append(data, '\n'), perm); err != nil {
+		return fmt.Errorf("error writing summary: %w", err)
+	}
+	return nil
+}
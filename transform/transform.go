@@ -0,0 +1,88 @@
+// Package transform provides a registry of named value transformations that
+// can be applied to result columns
+package transform
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Func transforms a single column value
+type Func func(value string) string
+
+var (
+	registry   = map[string]Func{}
+	registryMu sync.RWMutex
+)
+
+func init() {
+	Register("hash", Hash)
+	Register("mask", Mask)
+	Register("upper", strings.ToUpper)
+	Register("lower", strings.ToLower)
+	Register("trim", strings.TrimSpace)
+}
+
+// Register adds or replaces a named transform
+func Register(name string, fn Func) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = fn
+}
+
+// Lookup returns the transform registered under name
+func Lookup(name string) (Func, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	fn, ok := registry[name]
+	return fn, ok
+}
+
+// Names lists the registered transforms in sorted order
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain resolves a list of transform names into one function applying them in order
+func Chain(names []string) (Func, error) {
+	fns := make([]Func, 0, len(names))
+	for _, name := range names {
+		fn, ok := Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown transform %q (available: %s)", name, strings.Join(Names(), ", "))
+		}
+		fns = append(fns, fn)
+	}
+	return func(value string) string {
+		for _, fn := range fns {
+			value = fn(value)
+		}
+		return value
+	}, nil
+}
+
+// Hash returns the hex-encoded SHA-256 digest of value
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// Mask replaces all but the last four characters of value with '*'
+func Mask(value string) string {
+	runes := []rune(value)
+	for i := 0; i < len(runes)-4; i++ {
+		runes[i] = '*'
+	}
+	return string(runes)
+}